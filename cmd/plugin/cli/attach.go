@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/fenio/pv-mounter/pkg/plugin"
+	"github.com/spf13/cobra"
+)
+
+func attachCmd() *cobra.Command {
+	var podName string
+	var keyFile string
+	var address string
+	var allowNonEmpty bool
+	var sshfsPath string
+	var sshfsOptions []string
+
+	cmd := &cobra.Command{
+		Use:   "attach <namespace> <pvc-name> <local-mount-point>",
+		Short: "Mount a PVC from a pod created with mount --wait=false",
+		Long: `Mount a PVC from the pod created for it earlier with mount --wait=false,
+possibly in another shell or step.
+
+The pod is the one created for the local mount point, or the one given with --pod.
+The private key kept by mount --wait=false is used unless --key is given. The
+port-forward of the pod is reused if it's still running, otherwise a new one is
+started.`,
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completeMountArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signalContext()
+			defer stop()
+
+			opts := plugin.AttachOptions{
+				PodName: podName,
+				KeyFile: keyFile,
+				Mount: plugin.MountOptions{
+					Address:       address,
+					AllowNonEmpty: allowNonEmpty,
+					SSHFSPath:     sshfsPath,
+					SSHFSOptions:  sshfsOptions,
+				},
+			}
+			if err := plugin.Attach(ctx, args[0], args[1], args[2], opts); err != nil {
+				return fmt.Errorf("failed to attach PVC %s: %w", args[1], err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&podName, "pod", "", "Exposer pod to attach to (default the one created for the mount point)")
+	cmd.Flags().StringVar(&keyFile, "key", "", "Private key reaching the pod, e.g. the one of mount --keep-key (default the one kept by mount --wait=false)")
+	cmd.Flags().StringVar(&address, "address", "", "Address the port-forward binds to and SSHFS connects to (default localhost)")
+	cmd.Flags().BoolVar(&allowNonEmpty, "allow-nonempty", false, "Mount even if the local mount point isn't empty, hiding its contents until unmounted")
+	cmd.Flags().StringVar(&sshfsPath, "sshfs-path", "", "Path of the sshfs binary to run (default sshfs from the PATH)")
+	cmd.Flags().StringArrayVar(&sshfsOptions, "sshfs-opt", nil, "Additional SSHFS option passed as -o, can be repeated")
+	return cmd
+}
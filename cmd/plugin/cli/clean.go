@@ -2,30 +2,153 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/fenio/pv-mounter/pkg/plugin"
 	"github.com/spf13/cobra"
 )
 
 func cleanCmd() *cobra.Command {
+	var gracePeriod int64
+	var force bool
+	var all bool
+	var selector string
+	var maxAge time.Duration
+	var ignoreNotFound bool
+	var skipUnmount bool
+	var output string
+	var workloadPod string
+	var waitDeleted time.Duration
+
 	cmd := &cobra.Command{
-		Use:   "clean <namespace> <pvc-name> <local-mount-point>",
-		Short: "Clean the mounted PVC",
-		Args:  cobra.ExactArgs(3),
+		Use:     "clean [<namespace> <pvc-name>] <local-mount-point> | --all [<namespace>] | --pod <pod-name> <namespace>",
+		Aliases: []string{"unmount"},
+		Short:   "Clean the mounted PVC",
+		Long: `Unmount the PVC and delete the resources created for mounting it.
+
+When only the local mount point is given, the namespace and PVC are looked up
+from the pod that was created for that mount point.
+
+With --all, every mount in the namespace (all namespaces if omitted) is cleaned,
+optionally narrowed down with --selector.
+
+With --pod, every mount from ephemeral containers in the pod is cleaned, e.g.
+those of mount --pod.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			if workloadPod != "" {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			if len(args) != 1 && len(args) != 3 {
+				return fmt.Errorf("accepts 1 or 3 arg(s), received %d", len(args))
+			}
+			return nil
+		},
+		ValidArgsFunction: completeMountArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			namespace := args[0]
-			pvcName := args[1]
-			localMountPoint := args[2]
+			if gracePeriod < 0 {
+				return fmt.Errorf("--grace-period must not be negative")
+			}
+			if maxAge < 0 {
+				return fmt.Errorf("--max-age must not be negative")
+			}
+			if waitDeleted < 0 {
+				return fmt.Errorf("--wait-deleted must not be negative")
+			}
 
 			// Create a context
 			ctx := context.Background()
 
-			if err := plugin.Clean(ctx, namespace, pvcName, localMountPoint); err != nil {
-				return fmt.Errorf("failed to clean PVC: %w", err)
+			opts := plugin.CleanOptions{
+				GracePeriodSeconds: gracePeriod,
+				Force:              force,
+				Selector:           selector,
+				MaxAge:             maxAge,
+				IgnoreNotFound:     ignoreNotFound,
+				SkipUnmount:        skipUnmount,
+				WaitDeleted:        waitDeleted,
 			}
+
+			if all && workloadPod != "" {
+				return fmt.Errorf("--all can't be used with --pod")
+			}
+			if !all && workloadPod == "" && (selector != "" || maxAge != 0) {
+				return fmt.Errorf("--selector and --max-age can only be used with --all or --pod")
+			}
+			if output != "" && output != "json" {
+				return fmt.Errorf("--output must be json")
+			}
+
+			clean := func() (*plugin.CleanResult, error) {
+				if all {
+					var namespace string
+					if len(args) == 1 {
+						namespace = args[0]
+					}
+					result, err := plugin.CleanAll(ctx, namespace, opts)
+					if err != nil {
+						return result, fmt.Errorf("failed to clean PVCs: %w", err)
+					}
+					return result, nil
+				}
+
+				if workloadPod != "" {
+					result, err := plugin.CleanPod(ctx, args[0], workloadPod, opts)
+					if err != nil {
+						return result, fmt.Errorf("failed to clean PVCs of pod %s: %w", workloadPod, err)
+					}
+					return result, nil
+				}
+
+				var result *plugin.CleanResult
+				var err error
+				if len(args) == 1 {
+					result, err = plugin.CleanMountPoint(ctx, args[0], opts)
+				} else {
+					result, err = plugin.Clean(ctx, args[0], args[1], args[2], opts)
+				}
+				if err != nil {
+					return result, fmt.Errorf("failed to clean PVC: %w", err)
+				}
+				return result, nil
+			}
+
+			if output == "json" {
+				// What was removed is printed even if the clean failed halfway
+				return plugin.WithStdoutToStderr(func(stdout io.Writer) error {
+					result, err := clean()
+					encoder := json.NewEncoder(stdout)
+					encoder.SetIndent("", "  ")
+					if encodeErr := encoder.Encode(result); encodeErr != nil && err == nil {
+						return encodeErr
+					}
+					return err
+				})
+			}
+
+			result, err := clean()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Cleaned: %s\n", result.Summary())
 			return nil
 		},
 	}
+
+	cmd.Flags().Int64Var(&gracePeriod, "grace-period", 0, "Seconds given to the pods to terminate gracefully before they are deleted")
+	cmd.Flags().BoolVar(&force, "force", false, "Lazily unmount a stale mount point left behind by a dead pod or port-forward")
+	cmd.Flags().BoolVar(&ignoreNotFound, "ignore-not-found", false, "Succeed if the pods of the mount are gone already, for idempotent teardown scripts")
+	cmd.Flags().BoolVar(&skipUnmount, "skip-unmount", false, "Leave the local mount point alone, for mounts gone already after a reboot")
+	cmd.Flags().DurationVar(&waitDeleted, "wait-deleted", 0, "Wait up to this long for the pods to be gone, failing if they are stuck terminating, e.g. 1m")
+	cmd.Flags().BoolVar(&all, "all", false, "Clean every mount in the namespace, or in all namespaces if none is given")
+	cmd.Flags().StringVar(&workloadPod, "pod", "", "Clean every mount from ephemeral containers in this pod, e.g. those of mount --pod")
+	cmd.Flags().StringVar(&selector, "selector", "", "Label selector narrowing down the mounts cleaned by --all or --pod, e.g. team=a")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "Only clean the mounts older than this with --all or --pod, e.g. 2h")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Print what was removed as json, progress messages go to stderr then")
 	return cmd
 }
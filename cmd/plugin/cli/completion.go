@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/fenio/pv-mounter/pkg/plugin"
+	"github.com/spf13/cobra"
+)
+
+// completeMountArgs completes <namespace> <pvc-name> <local-mount-point>.
+func completeMountArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0, 1:
+		clientset, err := plugin.BuildKubeClient()
+		if err != nil {
+			cobra.CompDebugln(err.Error(), false)
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		var completions []string
+		if len(args) == 0 {
+			completions, err = plugin.CompleteNamespaces(context.Background(), clientset, toComplete)
+		} else {
+			completions, err = plugin.CompletePVCs(context.Background(), clientset, args[0], toComplete)
+		}
+		if err != nil {
+			cobra.CompDebugln(err.Error(), false)
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	case 2:
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeExecArgs completes <namespace> <pvc-name>, the command is left to the user.
+func completeExecArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) < 2 {
+		return completeMountArgs(cmd, args, toComplete)
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
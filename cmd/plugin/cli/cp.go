@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fenio/pv-mounter/pkg/plugin"
+	"github.com/spf13/cobra"
+)
+
+func cpCmd() *cobra.Command {
+	var needsRoot bool
+	var recursive bool
+	var rsync bool
+	var compression bool
+	var waitReadyTimeout time.Duration
+	var apiRetries int
+
+	cmd := &cobra.Command{
+		Use:   "cp [-r] [--rsync] <namespace> <src> <dst>",
+		Short: "Copy files from or to a PVC without mounting it",
+		Long: `Copy files from or to a PVC without mounting it.
+
+Exactly one of <src> and <dst> is a path in the PVC, written as <pvc-name>:<path>
+with the path relative to the root of the volume. The other one is a local path,
+which must start with . or / if it contains a colon. The pod created for the copy
+is removed once it's done.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if apiRetries < 0 {
+				return fmt.Errorf("--api-retries must not be negative")
+			}
+			if waitReadyTimeout <= 0 {
+				return fmt.Errorf("--wait-ready-timeout must be positive")
+			}
+
+			spec, err := plugin.ParseCopySpec(args[1], args[2])
+			if err != nil {
+				return err
+			}
+			spec.Recursive = recursive
+			spec.Rsync = rsync
+
+			// Canceled on ctrl-C, so the mount cleans up after itself
+			ctx, stop := signalContext()
+			defer stop()
+
+			opts := plugin.MountOptions{
+				NeedsRoot:        needsRoot,
+				APIRetries:       apiRetries,
+				WaitReadyTimeout: waitReadyTimeout,
+				Compression:      compression,
+			}
+			if err := plugin.Copy(ctx, args[0], spec, opts); err != nil {
+				return fmt.Errorf("failed to copy: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&needsRoot, "needs-root", false, "Copy using the root account")
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Copy directories with their contents")
+	cmd.Flags().BoolVar(&rsync, "rsync", false, "Copy with rsync instead of scp, requires rsync in the image")
+	cmd.Flags().BoolVar(&compression, "compression", false, "Enable SSH compression, useful on slow links")
+	cmd.Flags().DurationVar(&waitReadyTimeout, "wait-ready-timeout", plugin.DefaultWaitReadyTimeout, "How long to wait for the pod to become ready")
+	cmd.Flags().IntVar(&apiRetries, "api-retries", plugin.DefaultAPIRetries, "Number of times to retry transient Kubernetes API errors")
+	return cmd
+}
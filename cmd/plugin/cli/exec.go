@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/fenio/pv-mounter/pkg/plugin"
+	"github.com/spf13/cobra"
+)
+
+func execCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec <namespace> <pvc-name> [-- <command> [<args>...]]",
+		Short: "Run a shell or command in a mounted PVC",
+		Long: `Run a shell, or the given command, in the volume of a mounted PVC.
+
+It runs in the container of the pod created for the mount, or in the ephemeral
+container of the pod using an RWO PVC, starting in the directory the volume is
+mounted at. A terminal is allocated if stdin is one.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if dash := cmd.ArgsLenAtDash(); dash != -1 && dash != 2 {
+				return fmt.Errorf("expected <namespace> <pvc-name> before --, got %d arg(s)", dash)
+			}
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+		ValidArgsFunction: completeExecArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Canceled on ctrl-C, which the shell only sees without a terminal
+			ctx, stop := signalContext()
+			defer stop()
+
+			if err := plugin.Exec(ctx, args[0], args[1], args[2:]); err != nil {
+				return fmt.Errorf("failed to exec in PVC %s: %w", args[1], err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fenio/pv-mounter/pkg/plugin"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+func listCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [<namespace>]",
+		Aliases: []string{"ls"},
+		Short:   "List the mounted PVCs",
+		Long: `List the PVCs mounted by pv-mounter in the namespace, or in all namespaces
+if none is given.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var namespace string
+			if len(args) == 1 {
+				namespace = args[0]
+			}
+
+			// Create a context
+			ctx := context.Background()
+
+			clientset, err := plugin.BuildKubeClient()
+			if err != nil {
+				return err
+			}
+			mounts, err := plugin.ListMounts(ctx, clientset, namespace)
+			if err != nil {
+				return fmt.Errorf("failed to list mounts: %w", err)
+			}
+			if len(mounts) == 0 {
+				fmt.Println("No mounts found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NAMESPACE\tPVC\tPOD\tBACKEND\tVIA\tPORT\tMOUNT POINT\tSTATUS\tAGE")
+			for _, mount := range mounts {
+				mountPoint := mount.LocalMountPoint
+				if mountPoint == "" {
+					mountPoint = "<unknown>"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+					mount.Namespace, mount.PVCName, mount.PodName, mount.Backend, mount.Via,
+					mount.LocalPort, mountPoint, mount.Phase, duration.HumanDuration(mount.Age))
+			}
+			return w.Flush()
+		},
+	}
+	return cmd
+}
@@ -1,23 +1,127 @@
 package cli
 
 import (
-	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fenio/pv-mounter/pkg/plugin"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func mountCmd() *cobra.Command {
 	var needsRoot bool
 	var debug bool
+	var dryRun bool
+	var apiRetries int
+	var readOnly bool
+	var sshPort int
+	var assumeRWX bool
+	var noEphemeralFallback bool
+	var waitReadyTimeout time.Duration
+	var pullTimeout time.Duration
+	var keepAliveInterval int
+	var allowOther bool
+	var compression bool
+	var chownMountPoint bool
+	var allowNonEmpty bool
+	var offline bool
+	var ownerRef string
+	var podNamePrefix string
+	var idMapMode string
+	var uid int
+	var gid int
+	var allowWritableRootFS bool
+	var seccompProfile string
+	var appArmorProfile string
+	var fsGroup int64
+	var fsGroupChangePolicy string
+	var envVars []string
+	var timings bool
+	var via string
+	var serviceType string
+	var maxAge time.Duration
+	var ttl time.Duration
+	var keepKey string
+	var remoteMountPath string
+	var address string
+	var sftp bool
+	var sftpBatch string
+	var autoAdjustResources bool
+	var cpuLimit string
+	var automountToken bool
+	var priorityClass string
+	var memoryLimit string
+	var sshfsPath string
+	var sshfsOptions []string
+	var concurrency int
+	var pvcs []string
+	var mountPoint string
+	var namespaceAll bool
+	var pv string
+	var output string
+	var watch bool
+	var backend string
+	var snapshot string
+	var annotatePV bool
+	var imageDigest string
+	var strict bool
+	var imageSecrets []string
+	var skipImageSecretCheck bool
+	var wait bool
+	var hostAliases []string
+	var dnsServers []string
+	var dnsSearches []string
+	var workloadPod string
+	var inheritPVCLabels bool
 
 	cmd := &cobra.Command{
-		Use:   "mount [--needs-root] [--debug] <namespace> <pvc-name> <local-mount-point>",
+		Use:   "mount [--needs-root] [--debug] [--dry-run] [--read-only] <namespace> <pvc-name> <local-mount-point> | <namespace> <pvc-name>:<local-mount-point>... | [<namespace>] --pvc <pvc-name> --mount-point <local-mount-point> | --snapshot <snapshot-name> <namespace> <local-mount-point> | --pod <pod-name> <namespace> <local-base-dir>",
 		Short: "Mount a PVC to a local directory",
-		Args:  cobra.ExactArgs(3),
+		Long: `Mount a PVC to a local directory.
+
+Several PVCs from the same namespace can be mounted at once by passing
+<pvc-name>:<local-mount-point> pairs instead of a single PVC and mount point.
+
+For scripts, the namespace, PVC and mount point can be given with --namespace,
+--pvc and --mount-point instead. --pvc can be repeated as --pvc <pvc-name>:<local-mount-point>.
+Values given both as arguments and with flags must match. With --namespace-all,
+the namespace is looked up from the PVCs given with --pvc. --pv mounts the PVC
+bound to a PV.
+
+Where FUSE isn't available, --sftp opens an sftp session to <namespace> <pvc-name>
+instead of mounting it.
+
+--snapshot mounts a VolumeSnapshot instead, restored into a temporary PVC that
+clean deletes.
+
+--pod mounts every PVC used by a running pod, each into a subdirectory of
+<local-base-dir> named after the PVC, from ephemeral containers in the pod.
+clean --pod cleans them all up again.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if snapshot != "" || workloadPod != "" {
+				return cobra.ExactArgs(2)(cmd, args)
+			}
+			if pv != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			if len(pvcs) > 0 {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			if sftp {
+				return cobra.ExactArgs(2)(cmd, args)
+			}
+			if plugin.IsBatchMount(args) {
+				return nil
+			}
+			return cobra.ExactArgs(3)(cmd, args)
+		},
+		ValidArgsFunction: completeMountArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Check for NEEDS_ROOT environment variable
 			if needsRootEnv, exists := os.LookupEnv("NEEDS_ROOT"); exists {
@@ -39,14 +143,293 @@ func mountCmd() *cobra.Command {
 				}
 			}
 
-			namespace := args[0]
-			pvcName := args[1]
-			localMountPoint := args[2]
+			// A namespace from the environment or config file is only a default, positional
+			// args and --namespace-all override it
+			var namespaceFlag, defaultNamespace string
+			if flag := cmd.Flag("namespace"); flag != nil && flag.Changed {
+				namespaceFlag = flag.Value.String()
+			} else if flag != nil && configuredFlags["namespace"] {
+				defaultNamespace = flag.Value.String()
+			}
+
+			// Canceled on ctrl-C, so the mount cleans up after itself
+			ctx, stop := signalContext()
+			defer stop()
+
+			// stdout only carries the result with --output json
+			var progress io.Writer = os.Stdout
+			if output == "json" {
+				progress = os.Stderr
+			}
+
+			if snapshot != "" && (pv != "" || len(pvcs) > 0 || namespaceAll || mountPoint != "" || sftp || watch || output != "") {
+				return fmt.Errorf("--snapshot can't be used with --pv, --pvc, --namespace-all, --mount-point, --sftp, --watch or --output")
+			}
+
+			if workloadPod != "" && (snapshot != "" || pv != "" || len(pvcs) > 0 || namespaceAll || mountPoint != "" || sftp || watch || output != "" || !wait) {
+				return fmt.Errorf("--pod can't be used with --snapshot, --pv, --pvc, --namespace-all, --mount-point, --sftp, --watch, --output or --wait=false")
+			}
+
+			if pv != "" {
+				if len(pvcs) > 0 || namespaceAll {
+					return fmt.Errorf("--pv can't be used with --pvc or --namespace-all")
+				}
+				clientset, err := plugin.BuildKubeClient()
+				if err != nil {
+					return err
+				}
+				pvNamespace, pvcName, err := plugin.FindPVClaim(ctx, clientset, pv)
+				if err != nil {
+					return err
+				}
+				if namespaceFlag != "" && namespaceFlag != pvNamespace {
+					return fmt.Errorf("PV %s is claimed by PVC %s in namespace %s, not %s", pv, pvcName, pvNamespace, namespaceFlag)
+				}
+				fmt.Fprintf(progress, "Mounting PVC %s/%s of PV %s\n", pvNamespace, pvcName, pv)
+				namespaceFlag, pvcs = pvNamespace, []string{pvcName}
+			}
+
+			if namespaceAll {
+				if namespaceFlag != "" || len(args) > 0 {
+					return fmt.Errorf("--namespace-all can't be used with a namespace")
+				}
+				if len(pvcs) == 0 {
+					return fmt.Errorf("--namespace-all requires the PVCs to be given with --pvc")
+				}
+				clientset, err := plugin.BuildKubeClient()
+				if err != nil {
+					return err
+				}
+				pvcNames := make([]string, 0, len(pvcs))
+				for _, pvc := range pvcs {
+					pvcName, _, _ := strings.Cut(pvc, ":")
+					pvcNames = append(pvcNames, pvcName)
+				}
+				namespaceFlag, err = plugin.FindPVCNamespace(ctx, clientset, pvcNames)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(progress, "Using namespace %s of PVC %s\n", namespaceFlag, strings.Join(pvcNames, ", "))
+			}
 
-			// Create a context
-			ctx := context.Background()
+			var resolved plugin.MountArgs
+			var err error
+			if snapshot != "" || workloadPod != "" {
+				if namespaceFlag != "" && namespaceFlag != args[0] {
+					return fmt.Errorf("namespace given both as argument %q and with --namespace %q", args[0], namespaceFlag)
+				}
+				resolved = plugin.MountArgs{Namespace: args[0], Targets: []plugin.MountTarget{{LocalMountPoint: args[1]}}}
+			} else if resolved, err = plugin.ResolveMountArgs(args, namespaceFlag, defaultNamespace, pvcs, mountPoint, sftp); err != nil {
+				return err
+			}
+			namespace := resolved.Namespace
 
-			if err := plugin.Mount(ctx, namespace, pvcName, localMountPoint, needsRoot, debug); err != nil {
+			if apiRetries < 0 {
+				return fmt.Errorf("--api-retries must not be negative")
+			}
+			if sshPort < 1 || sshPort > 65535 {
+				return fmt.Errorf("--ssh-port must be between 1 and 65535")
+			}
+			if waitReadyTimeout <= 0 {
+				return fmt.Errorf("--wait-ready-timeout must be positive")
+			}
+			if pullTimeout <= 0 {
+				return fmt.Errorf("--pull-timeout must be positive")
+			}
+			if concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+			if keepAliveInterval < 0 {
+				return fmt.Errorf("--keepalive-interval must not be negative")
+			}
+			if backend != "" && backend != plugin.BackendSSHFS && sftp {
+				return fmt.Errorf("--backend can't be used with --sftp")
+			}
+			selected, err := plugin.SelectBackend(backend, sshfsPath)
+			if err != nil {
+				return err
+			}
+			if selected == plugin.BackendSFTP && !sftp {
+				if snapshot != "" {
+					return fmt.Errorf("FUSE isn't available here, and snapshots can only be mounted")
+				}
+				if workloadPod != "" {
+					return fmt.Errorf("FUSE isn't available here, and the PVCs of a pod can only be mounted")
+				}
+				if len(resolved.Targets) > 1 {
+					return fmt.Errorf("FUSE isn't available here, and sftp sessions can only be opened to a single PVC")
+				}
+				fmt.Fprintf(progress, "FUSE isn't available here, opening an sftp session to PVC %s instead of mounting it\n", resolved.Targets[0].PVCName)
+				sftp = true
+			}
+			if sftpBatch != "" && !sftp {
+				return fmt.Errorf("--sftp-batch can only be used with --sftp")
+			}
+			if watch && (sftp || dryRun || output != "" || len(resolved.Targets) > 1) {
+				return fmt.Errorf("--watch only works for mounting a single PVC, without --output")
+			}
+			if !wait && (sftp || watch) {
+				return fmt.Errorf("--wait=false can't be used with --sftp or --watch")
+			}
+			if output != "" && output != "json" {
+				return fmt.Errorf("--output must be json")
+			}
+			if output == "json" && (sftp || dryRun || len(resolved.Targets) > 1) {
+				return fmt.Errorf("--output json only works for mounting a single PVC")
+			}
+
+			opts := plugin.MountOptions{
+				NeedsRoot:                    needsRoot,
+				Debug:                        debug,
+				DryRun:                       dryRun,
+				APIRetries:                   apiRetries,
+				ReadOnly:                     readOnly,
+				SSHPort:                      sshPort,
+				AssumeRWX:                    assumeRWX,
+				NoEphemeralFallback:          noEphemeralFallback,
+				WaitReadyTimeout:             waitReadyTimeout,
+				PullTimeout:                  pullTimeout,
+				AllowWritableRootFS:          allowWritableRootFS,
+				KeepAliveInterval:            keepAliveInterval,
+				AllowOther:                   allowOther,
+				Compression:                  compression,
+				ChownMountPoint:              chownMountPoint,
+				IDMapMode:                    idMapMode,
+				AllowNonEmpty:                allowNonEmpty,
+				Offline:                      offline,
+				OwnerRef:                     ownerRef,
+				PodNamePrefix:                podNamePrefix,
+				Timings:                      timings,
+				Via:                          via,
+				ServiceType:                  corev1.ServiceType(serviceType),
+				MaxAge:                       maxAge,
+				TTL:                          ttl,
+				KeepKey:                      keepKey,
+				RemoteMountPath:              remoteMountPath,
+				Address:                      address,
+				AutoAdjustResources:          autoAdjustResources,
+				AutomountServiceAccountToken: automountToken,
+				PriorityClass:                priorityClass,
+				AnnotatePV:                   annotatePV,
+				InheritPVCLabels:             inheritPVCLabels,
+				ImageDigest:                  imageDigest,
+				Strict:                       strict,
+				ImagePullSecrets:             imageSecrets,
+				SkipImagePullSecretCheck:     skipImageSecretCheck,
+				NoWait:                       !wait,
+				DNSServers:                   dnsServers,
+				DNSSearches:                  dnsSearches,
+				SSHFSPath:                    sshfsPath,
+				SSHFSOptions:                 sshfsOptions,
+				Concurrency:                  concurrency,
+			}
+			if allowOther {
+				fmt.Println("Warning: --allow-other requires user_allow_other to be enabled in /etc/fuse.conf")
+			}
+			if keepAliveInterval == 0 {
+				// Unset options fall back to the default, negative ones disable keep-alives
+				opts.KeepAliveInterval = -1
+			}
+
+			for _, limit := range []struct {
+				flag  string
+				value string
+				dest  **resource.Quantity
+			}{{"--cpu-limit", cpuLimit, &opts.CPULimit}, {"--memory-limit", memoryLimit, &opts.MemoryLimit}} {
+				if limit.value == "" {
+					continue
+				}
+				quantity, err := plugin.ParseResourceLimit(limit.value)
+				if err != nil {
+					return fmt.Errorf("invalid %s: %v", limit.flag, err)
+				}
+				*limit.dest = quantity
+			}
+
+			if seccompProfile != "" {
+				profile, err := plugin.ParseSeccompProfile(seccompProfile)
+				if err != nil {
+					return err
+				}
+				opts.SeccompProfile = profile
+			}
+			if appArmorProfile != "" {
+				profile, err := plugin.ParseAppArmorProfile(appArmorProfile)
+				if err != nil {
+					return err
+				}
+				opts.AppArmorProfile = profile
+			}
+
+			for _, alias := range hostAliases {
+				hostAlias, err := plugin.ParseHostAlias(alias)
+				if err != nil {
+					return err
+				}
+				opts.HostAliases = append(opts.HostAliases, hostAlias)
+			}
+
+			for _, env := range envVars {
+				envVar, err := plugin.ParseEnvVar(env)
+				if err != nil {
+					return err
+				}
+				opts.Env = append(opts.Env, envVar)
+			}
+
+			if flagGiven(cmd, "uid") {
+				opts.UID = &uid
+			}
+			if flagGiven(cmd, "gid") {
+				opts.GID = &gid
+			}
+			if flagGiven(cmd, "fsgroup") {
+				opts.FSGroup = &fsGroup
+			}
+			if fsGroupChangePolicy != "" {
+				policy := corev1.PodFSGroupChangePolicy(fsGroupChangePolicy)
+				opts.FSGroupChangePolicy = &policy
+			}
+
+			if snapshot != "" {
+				if err := plugin.MountSnapshot(ctx, namespace, snapshot, resolved.Targets[0].LocalMountPoint, opts); err != nil {
+					return fmt.Errorf("failed to mount snapshot: %w", err)
+				}
+				return nil
+			}
+
+			if workloadPod != "" {
+				if err := plugin.MountPod(ctx, namespace, workloadPod, resolved.Targets[0].LocalMountPoint, opts); err != nil {
+					return fmt.Errorf("failed to mount PVCs of pod %s: %w", workloadPod, err)
+				}
+				return nil
+			}
+
+			if sftp {
+				if err := plugin.SFTP(ctx, namespace, resolved.Targets[0].PVCName, sftpBatch, opts); err != nil {
+					return fmt.Errorf("failed to open sftp session: %w", err)
+				}
+				return nil
+			}
+
+			if len(resolved.Targets) > 1 {
+				return plugin.MountBatch(ctx, namespace, resolved.Targets, opts)
+			}
+
+			target := resolved.Targets[0]
+			if watch {
+				if err := plugin.MountWatch(ctx, namespace, target.PVCName, target.LocalMountPoint, opts); err != nil {
+					return fmt.Errorf("failed to mount PVC: %w", err)
+				}
+				return nil
+			}
+			if output == "json" {
+				if err := plugin.MountJSON(ctx, namespace, target.PVCName, target.LocalMountPoint, opts); err != nil {
+					return fmt.Errorf("failed to mount PVC: %w", err)
+				}
+				return nil
+			}
+			if err := plugin.Mount(ctx, namespace, target.PVCName, target.LocalMountPoint, opts); err != nil {
 				return fmt.Errorf("failed to mount PVC: %w", err)
 			}
 			return nil
@@ -55,5 +438,67 @@ func mountCmd() *cobra.Command {
 
 	cmd.Flags().BoolVar(&needsRoot, "needs-root", false, "Mount the filesystem using the root account")
 	cmd.Flags().BoolVar(&debug, "debug", false, "Enable debug mode to print additional information")
+	cmd.Flags().StringArrayVar(&pvcs, "pvc", nil, "PVC to mount instead of the positional args, <pvc-name> with --mount-point or <pvc-name>:<local-mount-point>, can be repeated")
+	cmd.Flags().StringVar(&mountPoint, "mount-point", "", "Local mount point of the single PVC given with --pvc")
+	cmd.Flags().StringVar(&pv, "pv", "", "Mount the PVC bound to this PV instead of giving the namespace and PVC, with --mount-point")
+	cmd.Flags().BoolVar(&namespaceAll, "namespace-all", false, "Look up the namespace of the PVCs given with --pvc in all namespaces instead of passing it")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Print the result of the mount as json, progress messages go to stderr then")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Stay in the foreground and reconnect the mount whenever it drops, until interrupted")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the resources and commands that would be used without creating anything")
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Mount the volume read-only, required for ReadOnlyMany volumes")
+	cmd.Flags().BoolVar(&assumeRWX, "assume-rwx", false, "Mount RWO volumes from a new pod even if they are in use, only safe if the storage supports concurrent access")
+	cmd.Flags().BoolVar(&noEphemeralFallback, "no-ephemeral-fallback", false, "Fail for RWO volumes in use instead of mounting them from an ephemeral container in the pod using them")
+	cmd.Flags().IntVar(&sshPort, "ssh-port", plugin.DefaultSSHPort, "Container port of the SSH server in the pod mounting the volume")
+	cmd.Flags().StringVar(&podNamePrefix, "pod-name-prefix", plugin.DefaultPodNamePrefix, "What the names of the created pods start with")
+	cmd.Flags().StringVar(&ownerRef, "owner-ref", "", "Make the created pod owned by \"workload\" (the pod using an RWO PVC) or <kind>/<name>, so it's garbage collected with it")
+	cmd.Flags().BoolVar(&offline, "offline", false, "Only pull the image if it's missing on the node, for disconnected clusters")
+	cmd.Flags().BoolVar(&allowNonEmpty, "allow-nonempty", false, "Mount even if the local mount point isn't empty, hiding its contents until unmounted")
+	cmd.Flags().BoolVar(&chownMountPoint, "chown-mountpoint", false, "Make the local user the owner of the mount point after mounting")
+	cmd.Flags().BoolVar(&compression, "compression", false, "Enable SSH compression, useful on slow links")
+	cmd.Flags().StringVar(&idMapMode, "idmap-mode", "", "How SSHFS maps the owners of the files: ignore, none or user (default owned by --uid and --gid)")
+	cmd.Flags().IntVar(&uid, "uid", 0, "Local user the mounted files appear to be owned by (default current user)")
+	cmd.Flags().IntVar(&gid, "gid", 0, "Local group the mounted files appear to be owned by (default current group)")
+	cmd.Flags().BoolVar(&allowOther, "allow-other", false, "Allow other local users to access the mount (requires user_allow_other in /etc/fuse.conf)")
+	cmd.Flags().IntVar(&keepAliveInterval, "keepalive-interval", plugin.DefaultKeepAliveInterval, "Seconds between SSH keep-alive messages, 0 disables keep-alives and reconnects")
+	cmd.Flags().DurationVar(&waitReadyTimeout, "wait-ready-timeout", plugin.DefaultWaitReadyTimeout, "How long to wait for the pod to become ready")
+	cmd.Flags().DurationVar(&pullTimeout, "pull-timeout", plugin.DefaultPullTimeout, "How long the image may fail to pull before giving up, instead of waiting for --wait-ready-timeout")
+	cmd.Flags().BoolVar(&allowWritableRootFS, "allow-writable-rootfs", false, "Make the root filesystem of the containers writable for troubleshooting, less secure")
+	cmd.Flags().StringVar(&seccompProfile, "seccomp-profile", "", "Seccomp profile of the containers: runtime/default, unconfined or localhost/<profile> (default runtime/default)")
+	cmd.Flags().StringVar(&appArmorProfile, "apparmor-profile", "", "AppArmor profile of the containers: runtime/default, unconfined or localhost/<profile>")
+	cmd.Flags().Int64Var(&fsGroup, "fsgroup", 0, "Supplemental group that owns the volume in the pod")
+	cmd.Flags().StringVar(&fsGroupChangePolicy, "fsgroup-change-policy", "", "When to change the volume ownership to the fsgroup: OnRootMismatch or Always")
+	cmd.Flags().StringArrayVar(&hostAliases, "host-alias", nil, "Add ip=host1,host2 to /etc/hosts of the pod, can be repeated")
+	cmd.Flags().StringArrayVar(&dnsServers, "dns-server", nil, "DNS server the pod uses in addition to the cluster DNS, can be repeated")
+	cmd.Flags().StringArrayVar(&dnsSearches, "dns-search", nil, "DNS search domain of the pod in addition to those of the cluster, can be repeated")
+	cmd.Flags().StringArrayVar(&envVars, "env", nil, "Extra environment variable KEY=VALUE of the container exposing the volume, can be repeated")
+	cmd.Flags().StringVar(&via, "via", plugin.ViaPortForward, "How to reach the pod: port-forward, or service for where port-forwards are disabled")
+	cmd.Flags().StringVar(&serviceType, "service-type", "", "Type of the Service used with --via service: ClusterIP, NodePort or LoadBalancer (default ClusterIP)")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "How long the mount is meant to live, honored by clean --all --max-age")
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "Stop the pod after this long even if clean isn't run, which breaks the mount")
+	cmd.Flags().StringVar(&remoteMountPath, "remote-mount-path", plugin.DefaultRemoteMountPath, "Absolute path the volume is mounted at in the pod and mounted from by SSHFS")
+	cmd.Flags().StringVar(&address, "address", "", "Address the port-forward binds to and SSHFS connects to (default localhost)")
+	cmd.Flags().IntVar(&concurrency, "concurrency", plugin.DefaultBatchConcurrency, "Number of PVCs mounted at the same time when mounting several at once")
+	cmd.Flags().StringVar(&sshfsPath, "sshfs-path", "", "Path of the sshfs binary to run (default sshfs from the PATH)")
+	cmd.Flags().StringArrayVar(&sshfsOptions, "sshfs-opt", nil, "Additional SSHFS option passed as -o, can be repeated")
+	cmd.Flags().BoolVar(&autoAdjustResources, "auto-adjust-resources", false, "Raise or lower the resources of the pod into the range the LimitRanges of the namespace allow")
+	cmd.Flags().BoolVar(&automountToken, "automount-service-account-token", false, "Mount the token of the service account into the pod, only needed by custom images talking to the API server")
+	cmd.Flags().StringVar(&priorityClass, "priority-class", "", "Priority class of the pod, so it isn't preempted during long transfers")
+	cmd.Flags().StringVar(&cpuLimit, "cpu-limit", "", "CPU limit of the pod, e.g. 500m (default none)")
+	cmd.Flags().StringVar(&memoryLimit, "memory-limit", "", "Memory limit of the pod, e.g. 256Mi (default "+plugin.MemoryLimit+")")
+	cmd.Flags().StringVar(&imageDigest, "image-digest", "", "Pin the image of the pod to this digest, e.g. sha256:...")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail if the containers don't run the image with --image-digest once started")
+	cmd.Flags().StringArrayVar(&imageSecrets, "image-secret", nil, "Image pull secret of the pod, can be repeated")
+	cmd.Flags().BoolVar(&skipImageSecretCheck, "skip-image-secret-check", false, "Create the pod even if the --image-secret secrets don't exist yet")
+	cmd.Flags().BoolVar(&inheritPVCLabels, "inherit-pvc-labels", false, "Copy the labels of the PVC onto the pod, except those pv-mounter uses itself")
+	cmd.Flags().BoolVar(&annotatePV, "annotate-pv", false, "Annotate the PV with who mounts it, since when and through which pod, until clean")
+	cmd.Flags().StringVar(&workloadPod, "pod", "", "Mount every PVC used by this pod into subdirectories of the local mount point")
+	cmd.Flags().StringVar(&snapshot, "snapshot", "", "Mount this VolumeSnapshot, restored into a temporary PVC, instead of a PVC")
+	cmd.Flags().StringVar(&backend, "backend", plugin.BackendSSHFS, "How to access the PVC: sshfs, or auto to fall back to an sftp session where sshfs or FUSE isn't available")
+	cmd.Flags().BoolVar(&sftp, "sftp", false, "Open an sftp session to the PVC instead of mounting it, for where FUSE isn't available")
+	cmd.Flags().StringVar(&sftpBatch, "sftp-batch", "", "Run the sftp commands of this file instead of an interactive session, requires --sftp")
+	cmd.Flags().BoolVar(&wait, "wait", true, "Mount the PVC, with --wait=false only create the pod and port-forward and print how to mount it")
+	cmd.Flags().StringVar(&keepKey, "keep-key", "", "Write the generated private key to this file and print how to ssh into the pod with it")
+	cmd.Flags().BoolVar(&timings, "timings", false, "Print how long each phase of the mount took")
+	cmd.Flags().IntVar(&apiRetries, "api-retries", plugin.DefaultAPIRetries, "Number of times to retry transient Kubernetes API errors")
 	return cmd
 }
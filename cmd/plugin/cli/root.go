@@ -1,18 +1,24 @@
 package cli
 
 import (
+	"context"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/fenio/pv-mounter/pkg/plugin"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 var (
 	KubernetesConfigFlags *genericclioptions.ConfigFlags
 	rootCmd               *cobra.Command
+	configFile            string
+	// configuredFlags are the flags set from the environment or the config file.
+	configuredFlags map[string]bool
 )
 
 func init() {
@@ -21,6 +27,7 @@ func init() {
 		Short: "A tool to mount and unmount PVs",
 		Long:  `A tool to mount and unmount PVs using SSHFS.`,
 	}
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file with defaults for the flags, keyed by flag name (default ~/"+plugin.ConfigFileName+")")
 
 	if strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-") {
 		rootCmd.Annotations = map[string]string{
@@ -30,6 +37,51 @@ func init() {
 
 	rootCmd.AddCommand(mountCmd())
 	rootCmd.AddCommand(cleanCmd())
+	rootCmd.AddCommand(cpCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(execCmd())
+	rootCmd.AddCommand(attachCmd())
+
+	for _, cmd := range rootCmd.Commands() {
+		withConfig(cmd)
+	}
+}
+
+// withConfig applies the config to the flags of the command before its args are validated.
+// Cobra validates args before running PersistentPreRunE, and which args mount and clean
+// accept depends on their flags.
+func withConfig(cmd *cobra.Command) {
+	validateArgs := cmd.Args
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		config, err := plugin.LoadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if configuredFlags, err = config.Apply(cmd.Flags()); err != nil {
+			return err
+		}
+		if validateArgs == nil {
+			return nil
+		}
+		return validateArgs(cmd, args)
+	}
+}
+
+// signalContext returns a context canceled by the first SIGINT or SIGTERM, so an interrupted
+// mount removes what it created. A second signal terminates right away as usual.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// flagGiven reports whether the flag was given on the command line, in the environment or in
+// the config file.
+func flagGiven(cmd *cobra.Command, name string) bool {
+	return cmd.Flags().Changed(name) || configuredFlags[name]
 }
 
 func RootCmd() *cobra.Command {
@@ -40,9 +92,6 @@ func InitAndExecute() {
 	KubernetesConfigFlags = genericclioptions.NewConfigFlags(true)
 	KubernetesConfigFlags.AddFlags(RootCmd().PersistentFlags())
 
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
-
 	if err := RootCmd().Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// localIdentity returns who mounts, as user@host.
+var localIdentity = func() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+// annotatePV leaves on the PV who mounts it, since when and through which pod. It's only a
+// breadcrumb for other operators, so failing to leave it doesn't fail the mount.
+func annotatePV(ctx context.Context, clientset kubernetes.Interface, pvName, namespace, podName string) {
+	err := patchPVAnnotations(ctx, clientset, pvName, map[string]interface{}{
+		MountedByAnnotation:  localIdentity(),
+		MountedAtAnnotation:  clock().UTC().Format(time.RFC3339),
+		MountedPodAnnotation: namespace + "/" + podName,
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to annotate PV %s with the mount: %v\n", pvName, err)
+		return
+	}
+	fmt.Printf("Annotated PV %s with the mount\n", pvName)
+}
+
+// clearPVAnnotations removes the annotations of the mount through the pod from the PV. They
+// are left alone if another mount of the PV annotated it since. Like annotatePV, it only warns
+// on failures, clean goes on regardless.
+func clearPVAnnotations(ctx context.Context, clientset kubernetes.Interface, pvName, namespace, podName string) {
+	pv, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		fmt.Printf("Warning: failed to remove the annotations of the mount from PV %s: %v\n", pvName, err)
+		return
+	}
+	if pv.Annotations[MountedPodAnnotation] != namespace+"/"+podName {
+		return
+	}
+
+	err = patchPVAnnotations(ctx, clientset, pvName, map[string]interface{}{
+		MountedByAnnotation:  nil,
+		MountedAtAnnotation:  nil,
+		MountedPodAnnotation: nil,
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to remove the annotations of the mount from PV %s: %v\n", pvName, err)
+		return
+	}
+	fmt.Printf("Removed the annotations of the mount from PV %s\n", pvName)
+}
+
+// patchPVAnnotations sets the annotations of the PV, removing those set to nil.
+func patchPVAnnotations(ctx context.Context, clientset kubernetes.Interface, pvName string, annotations map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
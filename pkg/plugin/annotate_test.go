@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func useLocalIdentity(t *testing.T, identity string) {
+	t.Helper()
+	original := localIdentity
+	localIdentity = func() string { return identity }
+	t.Cleanup(func() { localIdentity = original })
+}
+
+func getPVAnnotations(t *testing.T, clientset *fake.Clientset, pvName string) map[string]string {
+	t.Helper()
+	pv, err := clientset.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get PV: %v", err)
+	}
+	return pv.Annotations
+}
+
+func TestAnnotatePVRoundTrip(t *testing.T) {
+	useLocalIdentity(t, "alice@laptop")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = original })
+
+	pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "test-pv", Annotations: map[string]string{"team": "a"}}}
+	clientset := fake.NewSimpleClientset(pv)
+	ctx := context.Background()
+
+	captureStdout(t, func() { annotatePV(ctx, clientset, "test-pv", "default", "volume-exposer-abcde") })
+	annotations := getPVAnnotations(t, clientset, "test-pv")
+	for key, expected := range map[string]string{
+		MountedByAnnotation:  "alice@laptop",
+		MountedAtAnnotation:  "2024-01-01T12:00:00Z",
+		MountedPodAnnotation: "default/volume-exposer-abcde",
+		"team":               "a",
+	} {
+		if annotations[key] != expected {
+			t.Errorf("Expected %s to be %q, got %q", key, expected, annotations[key])
+		}
+	}
+
+	// Another mount annotated the PV since, its annotations stay
+	captureStdout(t, func() { clearPVAnnotations(ctx, clientset, "test-pv", "default", "volume-exposer-other") })
+	if getPVAnnotations(t, clientset, "test-pv")[MountedPodAnnotation] == "" {
+		t.Error("Expected the annotations of another mount to be left alone")
+	}
+
+	captureStdout(t, func() { clearPVAnnotations(ctx, clientset, "test-pv", "default", "volume-exposer-abcde") })
+	annotations = getPVAnnotations(t, clientset, "test-pv")
+	for _, key := range []string{MountedByAnnotation, MountedAtAnnotation, MountedPodAnnotation} {
+		if _, ok := annotations[key]; ok {
+			t.Errorf("Expected %s to be removed, got %v", key, annotations)
+		}
+	}
+	if annotations["team"] != "a" {
+		t.Errorf("Expected other annotations to be kept, got %v", annotations)
+	}
+}
+
+func TestAnnotatePVBestEffort(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	out := captureStdout(t, func() {
+		annotatePV(context.Background(), clientset, "missing-pv", "default", "volume-exposer-abcde")
+		clearPVAnnotations(context.Background(), clientset, "missing-pv", "default", "volume-exposer-abcde")
+	})
+	if strings.Count(out, "Warning:") != 2 {
+		t.Errorf("Expected warnings for the missing PV, got %q", out)
+	}
+}
+
+func TestMountAnnotatePVRecordedOnPod(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	for _, annotate := range []bool{false, true} {
+		clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+		var err error
+		out := captureStdout(t, func() {
+			err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, AnnotatePV: annotate})
+		})
+		if err != nil {
+			t.Fatalf("mount() returned an error: %v", err)
+		}
+		if recorded := strings.Contains(out, AnnotatedPVAnnotation+": test-pv"); recorded != annotate {
+			t.Errorf("Expected the annotated PV to be recorded on the pod only with AnnotatePV (%v), got:\n%s", annotate, out)
+		}
+		assertNoWrites(t, clientset)
+	}
+}
+
+func TestCleanPodClearsPVAnnotations(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	pod.Annotations = map[string]string{AnnotatedPVAnnotation: "test-pv"}
+	pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "test-pv", Annotations: map[string]string{
+		MountedByAnnotation:  "alice@laptop",
+		MountedPodAnnotation: "default/volume-exposer-abcde",
+	}}}
+	clientset := fake.NewSimpleClientset(pod, pv)
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), clientset, pod, CleanOptions{}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanPod() returned an error: %v", err)
+	}
+	if annotations := getPVAnnotations(t, clientset, "test-pv"); len(annotations) != 0 {
+		t.Errorf("Expected the annotations of the mount to be removed, got %v", annotations)
+	}
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AttachOptions holds the optional settings of an attach.
+type AttachOptions struct {
+	// PodName is the exposer pod to attach to, looked up by the PVC and mount point if unset.
+	PodName string
+	// KeyFile is the private key reaching the pod. The key kept by mount --wait=false is
+	// used if unset.
+	KeyFile string
+	// Mount holds the settings of SSHFS. NeedsRoot is taken from the pod.
+	Mount MountOptions
+}
+
+// Attach mounts the PVC from an exposer pod created earlier, e.g. with mount --wait=false,
+// possibly from another shell. The port-forward of the pod is reused if it's still running
+// on this machine, otherwise a new one is started.
+func Attach(ctx context.Context, namespace, pvcName, localMountPoint string, opts AttachOptions) error {
+	if err := checkSupportedOS(runtime.GOOS); err != nil {
+		return err
+	}
+	if err := validateMountOptions(opts.Mount); err != nil {
+		return err
+	}
+	if err := checkSSHFS(opts.Mount.SSHFSPath); err != nil {
+		return err
+	}
+	if err := validateMountPoint(localMountPoint); err != nil {
+		return err
+	}
+	if !opts.Mount.AllowNonEmpty {
+		if err := checkMountPointEmpty(localMountPoint); err != nil {
+			return err
+		}
+	}
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return err
+	}
+	return attach(ctx, clientset, namespace, pvcName, localMountPoint, opts)
+}
+
+func attach(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts AttachOptions) (err error) {
+	pod, err := findAttachPod(ctx, clientset, namespace, pvcName, localMountPoint, opts.PodName)
+	if err != nil {
+		return err
+	}
+	// clean finds the pod by the mount point it was created for
+	if recorded := pod.Annotations[MountPointAnnotation]; recorded != "" && recorded != absMountPoint(localMountPoint) {
+		return fmt.Errorf("pod %s was created for mount point %s, attach it there", pod.Name, recorded)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod %s is %s, not running", pod.Name, pod.Status.Phase)
+	}
+
+	keyFile := opts.KeyFile
+	if keyFile == "" {
+		if keyFile, err = storedKeyPath(namespace, pod.Name); err != nil {
+			return err
+		}
+	}
+	privateKey, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) && opts.KeyFile == "" {
+		return fmt.Errorf("no private key kept for pod %s, mount with --wait=false or pass the key of --keep-key with --key", pod.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %v", err)
+	}
+
+	mountOpts := opts.Mount
+	mountOpts.NeedsRoot = podNeedsRoot(pod)
+	port, remotePort, err := podForwardPorts(pod)
+	if err != nil {
+		return err
+	}
+
+	var portForward *exec.Cmd
+	if pod.Annotations[ViaAnnotation] == ViaService {
+		service, err := clientset.CoreV1().Services(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get service %s: %v", pod.Name, err)
+		}
+		mountOpts.sshfsHost, port, err = serviceAddress(service, pod, remotePort)
+		if err != nil {
+			return err
+		}
+	} else if runningPortForward(pod) {
+		fmt.Printf("Reusing the port-forward of pod %s on port %d\n", pod.Name, port)
+		mountOpts.sshfsHost = forwardHost(mountOpts.Address)
+	} else {
+		portForward, err = setupPortForwarding(namespace, pod.Name, port, remotePort, mountOpts.Address)
+		if err != nil {
+			return err
+		}
+		mountOpts.sshfsHost = forwardHost(mountOpts.Address)
+		defer func() {
+			if err != nil {
+				_ = cleanupPortForward(pod.Name, portForward)()
+			}
+		}()
+	}
+
+	if err := mountPVCOverSSH(port, localMountPoint, pvcName, string(privateKey), mountOpts); err != nil {
+		return err
+	}
+	newMountSession(clientset, namespace, pvcName, localMountPoint, pod.Name, pod.Labels["originalPodName"], portForward, nil).record(port)
+	return nil
+}
+
+// findAttachPod finds the exposer pod created for mounting the PVC at the mount point.
+func findAttachPod(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint, podName string) (*corev1.Pod, error) {
+	if podName != "" {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pod %s: %v", podName, err)
+		}
+		if pod.Labels["app"] != "volume-exposer" || pod.Labels["pvcName"] != pvcName {
+			return nil, fmt.Errorf("pod %s doesn't expose PVC %s", podName, pvcName)
+		}
+		return pod, nil
+	}
+
+	selector := labels.Set{"app": "volume-exposer", "pvcName": pvcName, "mountPointHash": mountPointHash(localMountPoint)}
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	pods := podList.Items
+
+	switch len(pods) {
+	case 0:
+		return nil, fmt.Errorf("%w: no pod exposing PVC %s for mount point %s in namespace %s", ErrPodNotFound, pvcName, localMountPoint, namespace)
+	case 1:
+		return &pods[0], nil
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return nil, fmt.Errorf("multiple pods found for PVC %s: %s, please specify one with --pod", pvcName, strings.Join(names, ", "))
+}
+
+// podNeedsRoot reports whether the pod serves the volume as root, which decides the SSH user.
+func podNeedsRoot(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "NEEDS_ROOT" {
+				needsRoot, _ := strconv.ParseBool(env.Value)
+				return needsRoot
+			}
+		}
+	}
+	return false
+}
+
+// podForwardPorts returns the local port recorded on the pod and the pod port its
+// port-forward targets.
+func podForwardPorts(pod *corev1.Pod) (int, int, error) {
+	port, err := strconv.Atoi(pod.Labels["portNumber"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("pod %s has no valid local port label: %v", pod.Name, err)
+	}
+	remotePort := DefaultSSHPort
+	if value, ok := pod.Labels["sshPort"]; ok {
+		if remotePort, err = strconv.Atoi(value); err != nil {
+			return 0, 0, fmt.Errorf("pod %s has no valid SSH port label: %v", pod.Name, err)
+		}
+	}
+	return port, remotePort, nil
+}
+
+// runningPortForward reports whether the port-forward recorded for the pod still runs.
+func runningPortForward(pod *corev1.Pod) bool {
+	store, err := defaultStateStore()
+	if err != nil {
+		return false
+	}
+	record, err := store.Get(pod.Namespace, pod.Name)
+	if err != nil || record == nil || record.PortForwardPID == 0 {
+		return false
+	}
+	commandLine, err := processCommandLine(record.PortForwardPID)
+	return err == nil && strings.Contains(commandLine, portForwardPattern(pod))
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newAttachPod(podName, localMountPoint string, needsRoot bool) *corev1.Pod {
+	pod := newExposerPod("default", podName, "data", localMountPoint)
+	pod.Annotations = buildPodAnnotations(localMountPoint, 12345)
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "volume-exposer",
+		Env:  []corev1.EnvVar{{Name: "NEEDS_ROOT", Value: fmt.Sprintf("%v", needsRoot)}},
+	}}
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+// storeKey writes a private key where mount --wait=false keeps it.
+func storeKey(t *testing.T, podName string) {
+	t.Helper()
+	path, err := storedKeyPath("default", podName)
+	if err != nil {
+		t.Fatalf("storedKeyPath() returned an error: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("Failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("private-key"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func TestFindAttachPod(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(
+		newAttachPod("volume-exposer-first", "/mnt/first", false),
+		newAttachPod("volume-exposer-second", "/mnt/second", false),
+		newAttachPod("volume-exposer-third", "/mnt/second", false),
+	)
+
+	pod, err := findAttachPod(ctx, clientset, "default", "data", "/mnt/first", "")
+	if err != nil || pod.Name != "volume-exposer-first" {
+		t.Errorf("Expected the pod of the mount point, got %v (%v)", pod, err)
+	}
+	if _, err := findAttachPod(ctx, clientset, "default", "data", "/mnt/other", ""); !errors.Is(err, ErrPodNotFound) {
+		t.Errorf("Expected ErrPodNotFound for another mount point, got %v", err)
+	}
+	if _, err := findAttachPod(ctx, clientset, "default", "data", "/mnt/second", ""); err == nil || !strings.Contains(err.Error(), "--pod") {
+		t.Errorf("Expected the pods of the mount point to be ambiguous, got %v", err)
+	}
+	if pod, err := findAttachPod(ctx, clientset, "default", "data", "/mnt/second", "volume-exposer-third"); err != nil || pod.Name != "volume-exposer-third" {
+		t.Errorf("Expected the given pod, got %v (%v)", pod, err)
+	}
+	if _, err := findAttachPod(ctx, clientset, "default", "logs", "/mnt/second", "volume-exposer-third"); err == nil {
+		t.Error("Expected a pod of another PVC to be rejected")
+	}
+}
+
+func TestAttachStartsPortForward(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("root@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	pod := newAttachPod("volume-exposer-abcde", mountPoint, true)
+	storeKey(t, pod.Name)
+	clientset := fake.NewSimpleClientset(pod)
+
+	var err error
+	captureStdout(t, func() {
+		err = attach(context.Background(), clientset, "default", "data", mountPoint, AttachOptions{})
+	})
+	if err != nil {
+		t.Fatalf("attach() returned an error: %v", err)
+	}
+
+	expectedForward := []string{"kubectl", "port-forward", "pod/volume-exposer-abcde", fmt.Sprintf("12345:%d", DefaultSSHPort), "-n", "default"}
+	if len(r.started) != 1 || strings.Join(r.started[0], " ") != strings.Join(expectedForward, " ") {
+		t.Errorf("Expected the port-forward %v, got %v", expectedForward, r.started)
+	}
+	if len(r.run) != 1 || r.run[0][0] != "sshfs" {
+		t.Fatalf("Expected sshfs to be run, got %v", r.run)
+	}
+	sshfs := strings.Join(r.run[0], " ")
+	for _, expected := range []string{"-p 12345", "root@localhost:", mountPoint} {
+		if !strings.Contains(sshfs, expected) {
+			t.Errorf("Expected %q in the SSHFS command, got %s", expected, sshfs)
+		}
+	}
+}
+
+func TestAttachReusesPortForward(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	pod := newAttachPod("volume-exposer-abcde", mountPoint, false)
+	storeKey(t, pod.Name)
+	store, err := defaultStateStore()
+	if err != nil {
+		t.Fatalf("defaultStateStore() returned an error: %v", err)
+	}
+	if err := store.Add(mountRecord{Namespace: "default", PodName: pod.Name, PortForwardPID: 4242, LocalPort: 12345, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	oldProcessCommandLine := processCommandLine
+	t.Cleanup(func() { processCommandLine = oldProcessCommandLine })
+	processCommandLine = func(int) (string, error) {
+		return portForwardPattern(pod) + " -n default", nil
+	}
+
+	captureStdout(t, func() {
+		err = attach(context.Background(), fake.NewSimpleClientset(pod), "default", "data", mountPoint, AttachOptions{})
+	})
+	if err != nil {
+		t.Fatalf("attach() returned an error: %v", err)
+	}
+	if len(r.started) != 0 {
+		t.Errorf("Expected the running port-forward to be reused, started %v", r.started)
+	}
+	if len(r.run) != 1 || !strings.Contains(strings.Join(r.run[0], " "), "ve@localhost:") {
+		t.Errorf("Expected sshfs to mount as the unprivileged user, got %v", r.run)
+	}
+}
+
+func TestAttachRequiresKey(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+
+	pod := newAttachPod("volume-exposer-abcde", "/mnt/data", false)
+	err := attach(context.Background(), fake.NewSimpleClientset(pod), "default", "data", "/mnt/data", AttachOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no private key kept") {
+		t.Errorf("Expected the missing key to be reported, got %v", err)
+	}
+	if len(r.started) != 0 || len(r.run) != 0 {
+		t.Errorf("Expected nothing to run without a key, got %v and %v", r.started, r.run)
+	}
+}
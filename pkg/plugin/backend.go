@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Backends a PVC is accessed with, see SelectBackend.
+const (
+	// BackendSSHFS mounts the PVC with SSHFS, which needs FUSE.
+	BackendSSHFS = "sshfs"
+	// BackendSFTP opens an sftp session to the PVC instead of mounting it.
+	BackendSFTP = "sftp"
+	// BackendAuto picks SSHFS where it works locally and sftp otherwise.
+	BackendAuto = "auto"
+)
+
+// backendProbes check what the local environment offers to access a PVC.
+type backendProbes struct {
+	sshfs func() bool
+	fuse  func() bool
+	sftp  func() bool
+}
+
+// fuseDevices are what FUSE implementations install, /dev/fuse on Linux, macFUSE and
+// FUSE-T on macOS.
+var fuseDevices = map[string][]string{
+	"linux":  {"/dev/fuse"},
+	"darwin": {"/Library/Filesystems/macfuse.fs", "/Library/Filesystems/osxfuse.fs", "/Library/Application Support/fuse-t"},
+}
+
+func localBackendProbes(sshfsPath string) backendProbes {
+	if sshfsPath == "" {
+		sshfsPath = "sshfs"
+	}
+	return backendProbes{
+		sshfs: func() bool {
+			_, err := exec.LookPath(sshfsPath)
+			return err == nil
+		},
+		fuse: func() bool {
+			for _, device := range fuseDevices[runtime.GOOS] {
+				if _, err := os.Stat(device); err == nil {
+					return true
+				}
+			}
+			return false
+		},
+		sftp: func() bool {
+			_, err := exec.LookPath("sftp")
+			return err == nil
+		},
+	}
+}
+
+// SelectBackend resolves the backend given with --backend to BackendSSHFS or BackendSFTP.
+// BackendAuto prefers SSHFS if it and FUSE are available locally, and falls back to sftp.
+func SelectBackend(backend, sshfsPath string) (string, error) {
+	return selectBackend(backend, localBackendProbes(sshfsPath))
+}
+
+func selectBackend(backend string, probes backendProbes) (string, error) {
+	switch backend {
+	case "", BackendSSHFS:
+		return BackendSSHFS, nil
+	case BackendAuto:
+	default:
+		return "", fmt.Errorf("invalid backend %q, expected %s or %s", backend, BackendSSHFS, BackendAuto)
+	}
+
+	hasSSHFS, hasFUSE := probes.sshfs(), probes.fuse()
+	if hasSSHFS && hasFUSE {
+		return BackendSSHFS, nil
+	}
+	if probes.sftp() {
+		return BackendSFTP, nil
+	}
+
+	missing := "sshfs"
+	if hasSSHFS {
+		missing = "FUSE"
+	}
+	return "", fmt.Errorf("neither %s to mount the PVC nor sftp is available, install sshfs with FUSE (macFUSE or FUSE-T on macOS) or an sftp client", missing)
+}
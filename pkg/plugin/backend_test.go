@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectBackend(t *testing.T) {
+	probe := func(available bool) func() bool {
+		return func() bool { return available }
+	}
+
+	tests := []struct {
+		name     string
+		backend  string
+		sshfs    bool
+		fuse     bool
+		sftp     bool
+		expected string
+		errMsg   string
+	}{
+		{"Default", "", false, false, false, BackendSSHFS, ""},
+		{"Explicit sshfs", BackendSSHFS, false, false, true, BackendSSHFS, ""},
+		{"Auto with sshfs and FUSE", BackendAuto, true, true, true, BackendSSHFS, ""},
+		{"Auto without FUSE", BackendAuto, true, false, true, BackendSFTP, ""},
+		{"Auto without sshfs", BackendAuto, false, true, true, BackendSFTP, ""},
+		{"Auto without FUSE and sftp", BackendAuto, true, false, false, "", "neither FUSE to mount the PVC nor sftp"},
+		{"Auto without anything", BackendAuto, false, false, false, "", "neither sshfs to mount the PVC nor sftp"},
+		{"Unknown", "nfs", true, true, true, "", `invalid backend "nfs"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := selectBackend(tt.backend, backendProbes{sshfs: probe(tt.sshfs), fuse: probe(tt.fuse), sftp: probe(tt.sftp)})
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("Expected an error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectBackend() returned an error: %v", err)
+			}
+			if backend != tt.expected {
+				t.Errorf("Expected backend %s, got %s", tt.expected, backend)
+			}
+		})
+	}
+}
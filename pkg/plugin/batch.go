@@ -0,0 +1,258 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultBatchConcurrency is the number of PVCs mounted at the same time in batch mode.
+const DefaultBatchConcurrency = 4
+
+// MountTarget is a single PVC to mount as part of a batch.
+type MountTarget struct {
+	PVCName         string
+	LocalMountPoint string
+}
+
+// ParseMountTarget parses a <pvc-name>:<local-mount-point> pair.
+func ParseMountTarget(arg string) (MountTarget, error) {
+	// PVC names can't contain colons, so everything after the first one is the mount point.
+	pvcName, localMountPoint, found := strings.Cut(arg, ":")
+	if !found || pvcName == "" || localMountPoint == "" {
+		return MountTarget{}, fmt.Errorf("invalid mount target %q, expected <pvc-name>:<local-mount-point>", arg)
+	}
+	return MountTarget{PVCName: pvcName, LocalMountPoint: localMountPoint}, nil
+}
+
+// MountArgs are the namespace and the PVCs a mount command works on.
+type MountArgs struct {
+	Namespace string
+	Targets   []MountTarget
+}
+
+// IsBatchMount reports whether positional args use the <pvc-name>:<local-mount-point> form.
+func IsBatchMount(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	for _, arg := range args[1:] {
+		if !strings.Contains(arg, ":") {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveMountArgs combines the positional args with the --namespace, --pvc and --mount-point
+// flags. Once --pvc is given, the only positional arg allowed is the namespace. Values given both
+// ways are an error rather than one silently overriding the other, unless they are the same.
+// defaultNamespace, e.g. from the config file, is only used if neither gives a namespace.
+// With sftp, PVCs come without a mount point.
+func ResolveMountArgs(args []string, namespace, defaultNamespace string, pvcs []string, mountPoint string, sftp bool) (MountArgs, error) {
+	if len(args) > 0 {
+		if namespace != "" && namespace != args[0] {
+			return MountArgs{}, fmt.Errorf("namespace given both as argument %q and with --namespace %q", args[0], namespace)
+		}
+		namespace = args[0]
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	if namespace == "" {
+		return MountArgs{}, fmt.Errorf("namespace is required, as the first argument or with --namespace")
+	}
+	resolved := MountArgs{Namespace: namespace}
+
+	if len(pvcs) == 0 {
+		if mountPoint != "" {
+			return MountArgs{}, fmt.Errorf("--mount-point can only be used with --pvc")
+		}
+		switch {
+		case sftp && len(args) == 2:
+			resolved.Targets = []MountTarget{{PVCName: args[1]}}
+		case !sftp && IsBatchMount(args):
+			for _, arg := range args[1:] {
+				target, err := ParseMountTarget(arg)
+				if err != nil {
+					return MountArgs{}, err
+				}
+				resolved.Targets = append(resolved.Targets, target)
+			}
+		case !sftp && len(args) == 3:
+			resolved.Targets = []MountTarget{{PVCName: args[1], LocalMountPoint: args[2]}}
+		default:
+			return MountArgs{}, fmt.Errorf("PVC and local mount point are required, as arguments or with --pvc")
+		}
+		return resolved, nil
+	}
+
+	if len(args) > 1 {
+		return MountArgs{}, fmt.Errorf("PVCs can't be given both as arguments and with --pvc")
+	}
+	if mountPoint != "" || sftp {
+		if len(pvcs) != 1 {
+			return MountArgs{}, fmt.Errorf("a single --pvc is required, use --pvc <pvc-name>:<local-mount-point> for several")
+		}
+		if sftp && mountPoint != "" {
+			return MountArgs{}, fmt.Errorf("--mount-point can't be used with --sftp")
+		}
+		resolved.Targets = []MountTarget{{PVCName: pvcs[0], LocalMountPoint: mountPoint}}
+		return resolved, nil
+	}
+	for _, pvc := range pvcs {
+		target, err := ParseMountTarget(pvc)
+		if err != nil {
+			return MountArgs{}, fmt.Errorf("%w, or --pvc <pvc-name> --mount-point <local-mount-point>", err)
+		}
+		resolved.Targets = append(resolved.Targets, target)
+	}
+	return resolved, nil
+}
+
+// FindPVCNamespace looks up the namespace of the PVCs in all namespaces, for when only their
+// names are known. All of them have to be in a single namespace, PVCs with the same name in
+// several namespaces are an error listing the candidates.
+func FindPVCNamespace(ctx context.Context, clientset kubernetes.Interface, pvcNames []string) (string, error) {
+	listOptions := metav1.ListOptions{}
+	if len(pvcNames) == 1 {
+		listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", pvcNames[0]).String()
+	}
+	pvcList, err := clientset.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to list PVCs in all namespaces: %v", err)
+	}
+
+	var namespace string
+	for _, pvcName := range pvcNames {
+		var candidates []string
+		for _, pvc := range pvcList.Items {
+			if pvc.Name == pvcName {
+				candidates = append(candidates, pvc.Namespace)
+			}
+		}
+		sort.Strings(candidates)
+		switch {
+		case len(candidates) == 0:
+			return "", fmt.Errorf("%w: no PVC named %s in any namespace", ErrPVCNotFound, pvcName)
+		case len(candidates) > 1:
+			return "", fmt.Errorf("PVC %s exists in namespaces %s, use --namespace to pick one", pvcName, strings.Join(candidates, ", "))
+		case namespace != "" && candidates[0] != namespace:
+			return "", fmt.Errorf("PVC %s is in namespace %s, but %s is in %s, PVCs mounted at once have to share a namespace", pvcName, candidates[0], pvcNames[0], namespace)
+		}
+		namespace = candidates[0]
+	}
+	return namespace, nil
+}
+
+// FindPVClaim returns the namespace and name of the PVC bound to the PV, for admins who only
+// know the PV. PVs without a PVC, e.g. Available ones or Released ones whose PVC was deleted,
+// can't be mounted.
+func FindPVClaim(ctx context.Context, clientset kubernetes.Interface, pvName string) (string, string, error) {
+	pv, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get PV %s: %w", pvName, err)
+	}
+	claim := pv.Spec.ClaimRef
+	if claim == nil {
+		return "", "", fmt.Errorf("%w: PV %s is %s and not claimed by any PVC, create a PVC with volumeName %s to mount it", ErrPVCNotFound, pvName, pv.Status.Phase, pvName)
+	}
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && pvc.Spec.VolumeName != pvName) {
+		return "", "", fmt.Errorf("%w: PVC %s/%s of PV %s was deleted or uses another PV, the PV is %s; remove its claimRef and create a PVC with volumeName %s to mount it", ErrPVCNotFound, claim.Namespace, claim.Name, pvName, pv.Status.Phase, pvName)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get PVC %s/%s of PV %s: %w", claim.Namespace, claim.Name, pvName, err)
+	}
+	return claim.Namespace, claim.Name, nil
+}
+
+// MountBatch mounts several PVCs from one namespace concurrently. Every PVC gets its own pod,
+// port and key pair. A failed mount cleans up after itself and doesn't stop the others.
+func MountBatch(ctx context.Context, namespace string, targets []MountTarget, opts MountOptions) error {
+	if err := checkSupportedOS(runtime.GOOS); err != nil {
+		return err
+	}
+
+	if err := validateMountOptions(opts); err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		if err := checkSSHFS(opts.SSHFSPath); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		if err := validateMountPoint(target.LocalMountPoint); err != nil {
+			return err
+		}
+		if !opts.AllowNonEmpty {
+			if err := checkMountPointEmpty(target.LocalMountPoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return err
+	}
+
+	return mountBatch(ctx, targets, opts.concurrency(), func(ctx context.Context, target MountTarget) error {
+		return mount(ctx, clientset, namespace, target.PVCName, target.LocalMountPoint, opts)
+	})
+}
+
+// mountBatch mounts the targets with at most concurrency mounts at a time. Once the context is
+// canceled, no further mounts are started, the ones in progress clean up after themselves.
+func mountBatch(ctx context.Context, targets []MountTarget, concurrency int, mountFn func(context.Context, MountTarget) error) error {
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		// A free slot must not win over the cancellation
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			errs[i] = fmt.Errorf("PVC %s: not mounted: %w", target.PVCName, err)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target MountTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := mountFn(ctx, target); err != nil {
+				errs[i] = fmt.Errorf("PVC %s: %w", target.PVCName, err)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to mount %d of %d PVCs:\n%w", len(failed), len(targets), errors.Join(failed...))
+	}
+	return nil
+}
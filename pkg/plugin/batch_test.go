@@ -0,0 +1,368 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseMountTarget(t *testing.T) {
+	target, err := ParseMountTarget("data:/mnt/data")
+	if err != nil {
+		t.Fatalf("ParseMountTarget returned an error: %v", err)
+	}
+	if target.PVCName != "data" || target.LocalMountPoint != "/mnt/data" {
+		t.Errorf("Unexpected target %+v", target)
+	}
+
+	for _, arg := range []string{"data", ":/mnt/data", "data:"} {
+		if _, err := ParseMountTarget(arg); err == nil {
+			t.Errorf("ParseMountTarget(%q) should have returned an error", arg)
+		}
+	}
+}
+
+func TestResolveMountArgs(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		namespace        string
+		defaultNamespace string
+		pvcs             []string
+		mountPoint       string
+		sftp             bool
+		expected         MountArgs
+		expectErr        bool
+	}{
+		{
+			name:     "Positional only",
+			args:     []string{"ns", "data", "/mnt/data"},
+			expected: MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+		{
+			name: "Positional batch",
+			args: []string{"ns", "data:/mnt/data", "logs:/mnt/logs"},
+			expected: MountArgs{Namespace: "ns", Targets: []MountTarget{
+				{PVCName: "data", LocalMountPoint: "/mnt/data"},
+				{PVCName: "logs", LocalMountPoint: "/mnt/logs"},
+			}},
+		},
+		{
+			name:     "Positional sftp",
+			args:     []string{"ns", "data"},
+			sftp:     true,
+			expected: MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data"}}},
+		},
+		{
+			name:       "Flags only",
+			namespace:  "ns",
+			pvcs:       []string{"data"},
+			mountPoint: "/mnt/data",
+			expected:   MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+		{
+			name:      "Repeated --pvc",
+			namespace: "ns",
+			pvcs:      []string{"data:/mnt/data", "logs:/mnt/logs"},
+			expected: MountArgs{Namespace: "ns", Targets: []MountTarget{
+				{PVCName: "data", LocalMountPoint: "/mnt/data"},
+				{PVCName: "logs", LocalMountPoint: "/mnt/logs"},
+			}},
+		},
+		{
+			name:       "Namespace as argument with --pvc",
+			args:       []string{"ns"},
+			pvcs:       []string{"data"},
+			mountPoint: "/mnt/data",
+			expected:   MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+		{
+			name:      "Matching --namespace and argument",
+			args:      []string{"ns", "data", "/mnt/data"},
+			namespace: "ns",
+			expected:  MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+		{
+			name:      "Flags with sftp",
+			namespace: "ns",
+			pvcs:      []string{"data"},
+			sftp:      true,
+			expected:  MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data"}}},
+		},
+		{
+			name:      "Conflicting namespaces",
+			args:      []string{"ns", "data", "/mnt/data"},
+			namespace: "other",
+			expectErr: true,
+		},
+		{
+			name:       "PVC both as argument and flag",
+			args:       []string{"ns", "data", "/mnt/data"},
+			pvcs:       []string{"data"},
+			mountPoint: "/mnt/data",
+			expectErr:  true,
+		},
+		{
+			name:      "Missing namespace",
+			pvcs:      []string{"data:/mnt/data"},
+			expectErr: true,
+		},
+		{
+			name:       "--mount-point without --pvc",
+			args:       []string{"ns", "data", "/mnt/data"},
+			mountPoint: "/mnt/data",
+			expectErr:  true,
+		},
+		{
+			name:       "--mount-point with several --pvc",
+			namespace:  "ns",
+			pvcs:       []string{"data", "logs"},
+			mountPoint: "/mnt/data",
+			expectErr:  true,
+		},
+		{
+			name:      "--pvc without a mount point",
+			namespace: "ns",
+			pvcs:      []string{"data"},
+			expectErr: true,
+		},
+		{
+			name:             "Positional namespace overrides the config",
+			args:             []string{"ns", "data", "/mnt/data"},
+			defaultNamespace: "from-config",
+			expected:         MountArgs{Namespace: "ns", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+		{
+			name:             "Namespace from the config",
+			defaultNamespace: "from-config",
+			pvcs:             []string{"data"},
+			mountPoint:       "/mnt/data",
+			expected:         MountArgs{Namespace: "from-config", Targets: []MountTarget{{PVCName: "data", LocalMountPoint: "/mnt/data"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := ResolveMountArgs(tt.args, tt.namespace, tt.defaultNamespace, tt.pvcs, tt.mountPoint, tt.sftp)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveMountArgs returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(resolved, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, resolved)
+			}
+		})
+	}
+}
+
+func TestFindPVCNamespace(t *testing.T) {
+	newPVC := func(namespace, name string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	}
+	clientset := fake.NewSimpleClientset(
+		newPVC("team-a", "data"),
+		newPVC("team-a", "logs"),
+		newPVC("team-b", "logs"),
+		newPVC("team-b", "cache"),
+	)
+
+	tests := []struct {
+		name     string
+		pvcNames []string
+		expected string
+		errMsg   string
+		notFound bool
+	}{
+		{"Unique match", []string{"data"}, "team-a", "", false},
+		{"Several PVCs in one namespace", []string{"cache", "data"}, "", "PVC data is in namespace team-a, but cache is in team-b", false},
+		{"Multiple matches", []string{"logs"}, "", "PVC logs exists in namespaces team-a, team-b, use --namespace", false},
+		{"No match", []string{"missing"}, "", "no PVC named missing in any namespace", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace, err := FindPVCNamespace(context.Background(), clientset, tt.pvcNames)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("FindPVCNamespace() returned an error: %v", err)
+				}
+				if namespace != tt.expected {
+					t.Errorf("Expected namespace %s, got %s", tt.expected, namespace)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("Expected an error containing %q, got: %v", tt.errMsg, err)
+			}
+			if errors.Is(err, ErrPVCNotFound) != tt.notFound {
+				t.Errorf("Expected ErrPVCNotFound to be %v, got %v", tt.notFound, err)
+			}
+		})
+	}
+}
+
+func TestFindPVClaim(t *testing.T) {
+	newPV := func(name string, phase corev1.PersistentVolumePhase, claim *corev1.ObjectReference) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: claim},
+			Status:     corev1.PersistentVolumeStatus{Phase: phase},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		newPV("bound-pv", corev1.VolumeBound, &corev1.ObjectReference{Namespace: "team-a", Name: "data"}),
+		newPV("released-pv", corev1.VolumeReleased, &corev1.ObjectReference{Namespace: "team-a", Name: "deleted"}),
+		newPV("available-pv", corev1.VolumeAvailable, nil),
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "bound-pv"},
+		},
+	)
+
+	t.Run("Bound", func(t *testing.T) {
+		namespace, pvcName, err := FindPVClaim(context.Background(), clientset, "bound-pv")
+		if err != nil {
+			t.Fatalf("FindPVClaim() returned an error: %v", err)
+		}
+		if namespace != "team-a" || pvcName != "data" {
+			t.Errorf("Expected PVC team-a/data, got %s/%s", namespace, pvcName)
+		}
+	})
+
+	for _, tt := range []struct {
+		pvName string
+		errMsg string
+	}{
+		{"available-pv", "PV available-pv is Available and not claimed by any PVC"},
+		{"released-pv", "PVC team-a/deleted of PV released-pv was deleted or uses another PV, the PV is Released"},
+	} {
+		t.Run(tt.pvName, func(t *testing.T) {
+			_, _, err := FindPVClaim(context.Background(), clientset, tt.pvName)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("Expected an error containing %q, got: %v", tt.errMsg, err)
+			}
+			if !errors.Is(err, ErrPVCNotFound) {
+				t.Errorf("Expected ErrPVCNotFound, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Missing PV", func(t *testing.T) {
+		if _, _, err := FindPVClaim(context.Background(), clientset, "missing-pv"); err == nil || !strings.Contains(err.Error(), "failed to get PV missing-pv") {
+			t.Errorf("Expected an error about the missing PV, got: %v", err)
+		}
+	})
+}
+
+func TestMountBatch(t *testing.T) {
+	targets := []MountTarget{
+		{PVCName: "pvc-1", LocalMountPoint: "/mnt/1"},
+		{PVCName: "pvc-2", LocalMountPoint: "/mnt/2"},
+		{PVCName: "pvc-3", LocalMountPoint: "/mnt/3"},
+	}
+
+	t.Run("All mounts succeed concurrently", func(t *testing.T) {
+		var running, maxRunning int32
+		var mu sync.Mutex
+		mounted := map[string]bool{}
+
+		err := mountBatch(context.Background(), targets, 2, func(ctx context.Context, target MountTarget) error {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				prev := atomic.LoadInt32(&maxRunning)
+				if current <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			mounted[target.PVCName] = true
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("mountBatch returned an error: %v", err)
+		}
+		if len(mounted) != len(targets) {
+			t.Errorf("Expected %d mounts, got %d", len(targets), len(mounted))
+		}
+		if maxRunning > 2 {
+			t.Errorf("Expected at most 2 concurrent mounts, got %d", maxRunning)
+		}
+	})
+
+	t.Run("Partial failure", func(t *testing.T) {
+		var calls int32
+		err := mountBatch(context.Background(), targets, 2, func(ctx context.Context, target MountTarget) error {
+			atomic.AddInt32(&calls, 1)
+			if target.PVCName == "pvc-2" {
+				return fmt.Errorf("PVC pvc-2 is not bound")
+			}
+			return nil
+		})
+		if err == nil {
+			t.Fatal("mountBatch should have returned an error")
+		}
+		if calls != int32(len(targets)) {
+			t.Errorf("Expected all %d mounts to be attempted, got %d", len(targets), calls)
+		}
+		if !strings.Contains(err.Error(), "failed to mount 1 of 3 PVCs") || !strings.Contains(err.Error(), "pvc-2") {
+			t.Errorf("Unexpected error message: %v", err)
+		}
+	})
+
+	t.Run("Cancellation stops launching mounts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var started []string
+		var mu sync.Mutex
+
+		err := mountBatch(ctx, targets, 1, func(ctx context.Context, target MountTarget) error {
+			mu.Lock()
+			started = append(started, target.PVCName)
+			mu.Unlock()
+			// Like a ctrl-C while the first mount waits for its pod
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected the cancellation to be reported, got %v", err)
+		}
+		if len(started) != 1 {
+			t.Errorf("Expected no mounts to be started after the cancellation, got %v", started)
+		}
+		if !strings.Contains(err.Error(), "failed to mount 3 of 3 PVCs") || !strings.Contains(err.Error(), "PVC pvc-3: not mounted") {
+			t.Errorf("Unexpected error message: %v", err)
+		}
+	})
+
+	t.Run("Aggregate error report", func(t *testing.T) {
+		err := mountBatch(context.Background(), targets, 2, func(ctx context.Context, target MountTarget) error {
+			return fmt.Errorf("boom")
+		})
+		if err == nil {
+			t.Fatal("mountBatch should have returned an error")
+		}
+		for _, target := range targets {
+			if !strings.Contains(err.Error(), "PVC "+target.PVCName+": boom") {
+				t.Errorf("Expected error report to mention %s, got: %v", target.PVCName, err)
+			}
+		}
+	})
+}
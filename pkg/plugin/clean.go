@@ -1,104 +1,749 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
-func Clean(ctx context.Context, namespace, pvcName, localMountPoint string) error {
-	// Unmount the local mount point
-	var umountCmd *exec.Cmd
-	if runtime.GOOS == "darwin" {
-		umountCmd = exec.Command("umount", localMountPoint)
-	} else {
-		umountCmd = exec.Command("fusermount", "-u", localMountPoint)
+// CleanOptions holds the optional settings of a clean.
+type CleanOptions struct {
+	// GracePeriodSeconds is passed on when deleting pods. Exposer pods have nothing
+	// to flush, so the default of 0 deletes them immediately.
+	GracePeriodSeconds int64
+	// Force lazily unmounts stale mount points left behind by a dead exposer pod.
+	Force bool
+	// Selector narrows down the pods cleaned by CleanAll with a label selector.
+	Selector string
+	// IgnoreNotFound treats a mount whose pods are gone already as cleaned, like
+	// kubectl delete --ignore-not-found, so teardown scripts can clean unconditionally.
+	IgnoreNotFound bool
+	// MaxAge makes CleanAll clean only the pods older than it, or older than the max
+	// age recorded on the pod at mount time. All pods are cleaned if unset.
+	MaxAge time.Duration
+	// SkipUnmount leaves the local mount point alone and only cleans up the port-forward and
+	// the cluster, for sessions whose mount is gone already, e.g. after a reboot.
+	SkipUnmount bool
+	// WaitDeleted waits up to this long for deleted pods to be gone, rather than only asking
+	// for them to be deleted. Pods kept terminating, e.g. by finalizers, fail the clean then.
+	WaitDeleted time.Duration
+}
+
+// CleanResult records what a clean removed, so callers can tell what was actually done. Mount
+// points that weren't mounted and port-forwards that had exited aren't listed. Pods and
+// Services are given as namespace/name.
+type CleanResult struct {
+	// Unmounted lists the local mount points that were unmounted.
+	Unmounted []string `json:"unmounted,omitempty"`
+	// PortForwardsKilled lists the pods whose port-forward process was killed.
+	PortForwardsKilled []string `json:"portForwardsKilled,omitempty"`
+	// ServicesDeleted lists the Services of mounts made through a Service.
+	ServicesDeleted []string `json:"servicesDeleted,omitempty"`
+	// EphemeralProcessesKilled lists the ephemeral containers whose tunnel was stopped, as
+	// namespace/pod/container.
+	EphemeralProcessesKilled []string `json:"ephemeralProcessesKilled,omitempty"`
+	// PodsDeleted lists the deleted exposer and proxy pods.
+	PodsDeleted []string `json:"podsDeleted,omitempty"`
+	// PVCsDeleted lists the temporary PVCs restored from snapshots for mounts.
+	PVCsDeleted []string `json:"pvcsDeleted,omitempty"`
+}
+
+// Summary describes the result in one line.
+func (r *CleanResult) Summary() string {
+	summary := fmt.Sprintf("%d mount point(s) unmounted, %d port-forward(s) killed, %d Service(s) deleted, %d ephemeral container process(es) killed, %d pod(s) deleted",
+		len(r.Unmounted), len(r.PortForwardsKilled), len(r.ServicesDeleted), len(r.EphemeralProcessesKilled), len(r.PodsDeleted))
+	if len(r.PVCsDeleted) > 0 {
+		summary += fmt.Sprintf(", %d PVC(s) restored from snapshots deleted", len(r.PVCsDeleted))
 	}
-	umountCmd.Stdout = os.Stdout
-	umountCmd.Stderr = os.Stderr
-	if err := umountCmd.Run(); err != nil {
-		return fmt.Errorf("failed to unmount SSHFS: %v", err)
+	return summary
+}
+
+// unmount unmounts the local mount point, unless SkipUnmount is set, and records it if it was
+// mounted.
+func (r *CleanResult) unmount(localMountPoint string, opts CleanOptions) error {
+	if opts.SkipUnmount {
+		fmt.Printf("Skipping the unmount of %s\n", localMountPoint)
+		return nil
+	}
+	unmounted, err := unmountLocal(localMountPoint, opts.Force)
+	if unmounted {
+		r.Unmounted = append(r.Unmounted, localMountPoint)
+	}
+	return err
+}
+
+// Clean unmounts the mount point and removes what was created for mounting the PVC. The
+// result lists what was removed, also when an error stopped the clean halfway.
+func Clean(ctx context.Context, namespace, pvcName, localMountPoint string, opts CleanOptions) (*CleanResult, error) {
+	result := &CleanResult{}
+
+	// Unmount the local mount point
+	if err := result.unmount(localMountPoint, opts); err != nil {
+		return result, err
 	}
-	fmt.Printf("Unmounted %s successfully\n", localMountPoint)
 
 	// Build Kubernetes client
 	clientset, err := BuildKubeClient()
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	// List the pod with the PVC name label
-	podClient := clientset.CoreV1().Pods(namespace)
-	podList, err := podClient.List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("pvcName=%s", pvcName),
+	return result, cleanPVC(ctx, clientset, namespace, pvcName, localMountPoint, opts, result)
+}
+
+// cleanPVC cleans the pods created for mounting the PVC. The same PVC may be mounted more than
+// once, so the pods are narrowed down to those of the mount point if it's known. Without it,
+// several pods can't be told apart and nothing is cleaned.
+func cleanPVC(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts CleanOptions, result *CleanResult) error {
+	selector := labels.Set{"pvcName": pvcName}
+	if localMountPoint != "" {
+		selector["mountPointHash"] = mountPointHash(localMountPoint)
+	}
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to list pods: %v", err)
 	}
 
 	if len(podList.Items) == 0 {
-		return fmt.Errorf("no pod found with PVC name label %s", pvcName)
+		if localMountPoint != "" {
+			return ignoreNotFound(fmt.Errorf("%w: no pod with PVC name label %s for mount point %s", ErrPodNotFound, pvcName, localMountPoint), opts)
+		}
+		return ignoreNotFound(fmt.Errorf("%w: no pod with PVC name label %s", ErrPodNotFound, pvcName), opts)
 	}
 
-	podName := podList.Items[0].Name
-	port := podList.Items[0].Labels["portNumber"]
+	if len(podList.Items) > 1 && localMountPoint == "" {
+		var pods []string
+		for _, pod := range podList.Items {
+			pods = append(pods, pod.Name)
+		}
+		return fmt.Errorf("multiple pods found for PVC %s: %s, please specify the mount point", pvcName, strings.Join(pods, ", "))
+	}
 
-	// Kill the port-forward process
-	pkillCmd := exec.Command("pkill", "-f", fmt.Sprintf("kubectl port-forward pod/%s %s:2137", podName, port))
-	pkillCmd.Stdout = os.Stdout
-	pkillCmd.Stderr = os.Stderr
-	if err := pkillCmd.Run(); err != nil {
-		return fmt.Errorf("failed to kill port-forward process: %v", err)
+	// Pods of the same mount point are leftovers of earlier mounts, they all go
+	var errs []error
+	for i := range podList.Items {
+		if err := cleanPod(ctx, clientset, &podList.Items[i], opts, result); err != nil {
+			errs = append(errs, fmt.Errorf("pod %s: %w", podList.Items[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ignoreNotFound turns a missing pod into a warning if IgnoreNotFound is set.
+func ignoreNotFound(err error, opts CleanOptions) error {
+	if opts.IgnoreNotFound && (errors.Is(err, ErrPodNotFound) || apierrors.IsNotFound(err)) {
+		fmt.Printf("Warning: %v, nothing to clean\n", err)
+		return nil
+	}
+	return err
+}
+
+// cleanPod stops what was started for an exposer pod and deletes it, recording it in result.
+func cleanPod(ctx context.Context, clientset kubernetes.Interface, pod *corev1.Pod, opts CleanOptions, result *CleanResult) error {
+	namespace := pod.Namespace
+	podName := pod.Name
+
+	if pod.Annotations[ViaAnnotation] == ViaService {
+		// Mounted through a Service, there is no port-forward to kill
+		if err := deleteService(ctx, clientset, namespace, podName); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s deleted successfully\n", podName)
+		result.ServicesDeleted = append(result.ServicesDeleted, namespace+"/"+podName)
+	} else {
+		// Kill the port-forward process
+		killed, err := stopPortForward(pod)
+		if err != nil {
+			return err
+		}
+		if killed {
+			fmt.Printf("Port-forward process for pod %s killed successfully\n", podName)
+			result.PortForwardsKilled = append(result.PortForwardsKilled, namespace+"/"+podName)
+		}
 	}
-	fmt.Printf("Port-forward process for pod %s killed successfully\n", podName)
 
 	// Check for original pod
-	originalPodName := podList.Items[0].Labels["originalPodName"]
+	originalPodName := pod.Labels["originalPodName"]
 	if originalPodName != "" {
-		err = killProcessInEphemeralContainer(ctx, clientset, namespace, originalPodName)
+		containerName := pod.Annotations[EphemeralContainerAnnotation]
+		err := killProcessInEphemeralContainer(ctx, clientset, namespace, originalPodName, containerName)
 		if err != nil {
-			return fmt.Errorf("failed to kill process in ephemeral container: %v", err)
+			if err := ignoreNotFound(err, opts); err != nil {
+				return fmt.Errorf("failed to kill process in ephemeral container: %v", err)
+			}
+		} else {
+			fmt.Printf("Process in ephemeral container killed successfully in pod %s\n", originalPodName)
+			result.EphemeralProcessesKilled = append(result.EphemeralProcessesKilled, fmt.Sprintf("%s/%s/%s", namespace, originalPodName, containerName))
+		}
+	}
+
+	if pvName := pod.Annotations[AnnotatedPVAnnotation]; pvName != "" {
+		clearPVAnnotations(ctx, clientset, pvName, namespace, podName)
+	}
+
+	// The PVC restored for the mount is only deleted once the pod is gone, Kubernetes holds
+	// it back while it's in use
+	if snapshot := pod.Annotations[SnapshotAnnotation]; snapshot != "" {
+		pvcName := pod.Labels["pvcName"]
+		if err := deletePVC(ctx, clientset, namespace, pvcName); err != nil {
+			return err
 		}
-		fmt.Printf("Process in ephemeral container killed successfully in pod %s\n", originalPodName)
+		fmt.Printf("PVC %s restored from snapshot %s deleted successfully\n", pvcName, snapshot)
+		result.PVCsDeleted = append(result.PVCsDeleted, namespace+"/"+pvcName)
 	}
 
+	removeStoredKey(namespace, podName)
+	removeMountKey(pod.Labels["mountPointHash"])
+
 	// Delete the proxy pod
-	err = podClient.Delete(ctx, podName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to delete pod: %v", err)
+	if err := deletePod(ctx, clientset, namespace, podName, opts.GracePeriodSeconds); err != nil {
+		if err := ignoreNotFound(err, opts); err != nil {
+			return fmt.Errorf("failed to delete pod: %v", err)
+		}
+		return nil
 	}
 	fmt.Printf("Proxy pod %s deleted successfully\n", podName)
+	result.PodsDeleted = append(result.PodsDeleted, namespace+"/"+podName)
 
+	if opts.WaitDeleted > 0 {
+		return waitForPodDeleted(ctx, clientset, namespace, podName, opts.WaitDeleted)
+	}
 	return nil
 }
 
-func killProcessInEphemeralContainer(ctx context.Context, clientset *kubernetes.Clientset, namespace, podName string) error {
-	// Retrieve the existing pod to get the ephemeral container name
+// CleanAll cleans every mount in the namespace, in all namespaces if it's empty. Mount points
+// of the pods are only unmounted if they are mounted on this machine. A failure to clean
+// one mount doesn't stop the others.
+func CleanAll(ctx context.Context, namespace string, opts CleanOptions) (*CleanResult, error) {
+	result := &CleanResult{}
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return result, err
+	}
+	return result, cleanAll(ctx, clientset, namespace, opts, result)
+}
+
+func cleanAll(ctx context.Context, clientset kubernetes.Interface, namespace string, opts CleanOptions, result *CleanResult) error {
+	selector, err := exposerSelector(opts.Selector)
+	if err != nil {
+		return err
+	}
+
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %v", err)
+	}
+	if len(podList.Items) == 0 {
+		fmt.Printf("No pods matching %s found\n", selector)
+		return nil
+	}
+
+	now := clock()
+	var errs []error
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if opts.MaxAge > 0 && !podExpired(pod, opts.MaxAge, now) {
+			continue
+		}
+		if err := unmountPodMountPoint(pod, opts, result); err != nil {
+			errs = append(errs, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		if err := cleanPod(ctx, clientset, pod, opts, result); err != nil {
+			errs = append(errs, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// podExpired reports whether the pod outlived its max age, the one recorded at mount
+// time if there is one, maxAge otherwise.
+func podExpired(pod *corev1.Pod, maxAge time.Duration, now time.Time) bool {
+	if value, ok := pod.Annotations[MaxAgeAnnotation]; ok {
+		podMaxAge, err := time.ParseDuration(value)
+		if err == nil {
+			maxAge = podMaxAge
+		} else {
+			fmt.Printf("Warning: ignoring invalid max age %q of pod %s\n", value, pod.Name)
+		}
+	}
+	return now.Sub(pod.CreationTimestamp.Time) > maxAge
+}
+
+// exposerSelector returns the selector of the exposer pods, narrowed down by the extra selector.
+func exposerSelector(extra string) (string, error) {
+	selector := labels.SelectorFromSet(labels.Set{"app": "volume-exposer"})
+	if extra != "" {
+		parsed, err := labels.Parse(extra)
+		if err != nil {
+			return "", fmt.Errorf("invalid selector %q: %v", extra, err)
+		}
+		requirements, _ := parsed.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	return selector.String(), nil
+}
+
+// unmountPodMountPoint unmounts the mount point recorded on the pod, if it's mounted on this
+// machine. Pods of mounts made elsewhere have nothing to unmount here.
+func unmountPodMountPoint(pod *corev1.Pod, opts CleanOptions, result *CleanResult) error {
+	localMountPoint := pod.Annotations[MountPointAnnotation]
+	if localMountPoint == "" || opts.SkipUnmount {
+		return nil
+	}
+	table, err := readMountTable(runtime.GOOS)
+	if err != nil {
+		return err
+	}
+	if !isFUSEMount(runtime.GOOS, table, localMountPoint) {
+		return nil
+	}
+	return result.unmount(localMountPoint, opts)
+}
+
+func deletePod(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, gracePeriodSeconds int64) error {
+	return clientset.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+	})
+}
+
+// waitForPodDeleted waits for the deleted pod to be gone. Deleting is asynchronous, finalizers
+// or an unreachable node can keep the pod terminating, and its resources counted against the
+// quota of the namespace.
+func waitForPodDeleted(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastPod *corev1.Pod
+	err := podReadyBackoff.DelayFunc().Until(waitCtx, true, false, func(ctx context.Context) (bool, error) {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		lastPod = pod
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		reason := ""
+		if lastPod != nil && len(lastPod.Finalizers) > 0 {
+			reason = fmt.Sprintf(", held back by finalizers %s", strings.Join(lastPod.Finalizers, ", "))
+		}
+		return fmt.Errorf("pod %s is still terminating after %s%s", podName, timeout, reason)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check that pod %s is gone: %v", podName, err)
+	}
+	fmt.Printf("Pod %s is gone\n", podName)
+	return nil
+}
+
+// stopPortForward kills the port-forward of the pod and reports whether one was running. It uses
+// the process recorded at mount time and only falls back to pkill for mounts without one, e.g.
+// those made on another machine.
+func stopPortForward(pod *corev1.Pod) (bool, error) {
+	store, err := defaultStateStore()
+	if err != nil {
+		fmt.Printf("Warning: %v, looking for the port-forward process by its command line\n", err)
+		return pkillPortForward(pod)
+	}
+	return stopRecordedPortForward(store, pod)
+}
+
+func stopRecordedPortForward(store *stateStore, pod *corev1.Pod) (bool, error) {
+	record, err := store.Get(pod.Namespace, pod.Name)
+	if err != nil {
+		return false, err
+	}
+	if record == nil || record.PortForwardPID == 0 {
+		return pkillPortForward(pod)
+	}
+
+	// After a reboot or once the port-forward exited, its PID may belong to an unrelated process
+	commandLine, err := processCommandLine(record.PortForwardPID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check port-forward process %d: %v", record.PortForwardPID, err)
+	}
+	if commandLine == "" {
+		fmt.Printf("Port-forward process %d for pod %s exited already\n", record.PortForwardPID, pod.Name)
+		return false, store.Remove(pod.Namespace, pod.Name)
+	}
+	if !strings.Contains(commandLine, portForwardPattern(pod)) {
+		fmt.Printf("Warning: process %d is no longer the port-forward for pod %s, not killing it\n", record.PortForwardPID, pod.Name)
+		return false, store.Remove(pod.Namespace, pod.Name)
+	}
+
+	reportThroughput(pod.Name, record.PortForwardPID, record.CreatedAt)
+	process, err := os.FindProcess(record.PortForwardPID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find port-forward process %d: %v", record.PortForwardPID, err)
+	}
+	if err := process.Kill(); err != nil {
+		if !errors.Is(err, os.ErrProcessDone) {
+			return false, fmt.Errorf("failed to kill port-forward process %d: %v", record.PortForwardPID, err)
+		}
+		return false, store.Remove(pod.Namespace, pod.Name)
+	}
+	return true, store.Remove(pod.Namespace, pod.Name)
+}
+
+// processCommandLine returns the command line of a running process, empty if there is none.
+var processCommandLine = func(pid int) (string, error) {
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// ps exits with 1 when no process matched
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func pkillPortForward(pod *corev1.Pod) (bool, error) {
+	pkillCmd := exec.Command("pkill", "-f", portForwardPattern(pod))
+	pkillCmd.Stdout = os.Stdout
+	pkillCmd.Stderr = os.Stderr
+	if err := runner.Run(pkillCmd); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// Nothing matched, the port-forward died already or ran on another machine
+			fmt.Printf("No port-forward process found for pod %s\n", pod.Name)
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to kill port-forward process: %v", err)
+	}
+	return true, nil
+}
+
+// portForwardPattern returns the command line of the port-forward started for the pod.
+func portForwardPattern(pod *corev1.Pod) string {
+	sshPort := pod.Labels["sshPort"]
+	if sshPort == "" {
+		// Pods created before the SSH port was recorded always used the default one
+		sshPort = fmt.Sprintf("%d", DefaultSSHPort)
+	}
+	return fmt.Sprintf("kubectl port-forward pod/%s %s:%s", pod.Name, pod.Labels["portNumber"], sshPort)
+}
+
+// CleanMountPoint cleans a mount knowing only its local mount point. The pod cleaned is the
+// one recorded for the mount point on this machine, or else the one that was labeled with
+// the mount point when it was mounted.
+func CleanMountPoint(ctx context.Context, localMountPoint string, opts CleanOptions) (*CleanResult, error) {
+	result := &CleanResult{}
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return result, err
+	}
+
+	pod, err := findMountPointPod(ctx, clientset, localMountPoint)
+	if err != nil {
+		if err := ignoreNotFound(err, opts); err != nil {
+			return result, err
+		}
+		// Nothing left in the cluster, but the mount point may still be mounted
+		return result, result.unmount(localMountPoint, opts)
+	}
+
+	if err := result.unmount(localMountPoint, opts); err != nil {
+		return result, err
+	}
+	return result, cleanPod(ctx, clientset, pod, opts, result)
+}
+
+// findMountPointPod looks up the pod of the mount point in the local records first, which
+// saves listing the pods of all namespaces. Mounts made elsewhere or by older versions
+// are only found by the label.
+func findMountPointPod(ctx context.Context, clientset kubernetes.Interface, localMountPoint string) (*corev1.Pod, error) {
+	store, err := defaultStateStore()
+	var record *mountRecord
+	if err == nil {
+		record, err = store.FindByMountPoint(localMountPoint)
+	}
+	if err != nil {
+		fmt.Printf("Warning: %v, looking for the pod by its mount point label\n", err)
+	}
+	if record != nil {
+		pod, err := clientset.CoreV1().Pods(record.Namespace).Get(ctx, record.PodName, metav1.GetOptions{})
+		if err == nil {
+			return pod, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get pod %s: %v", record.PodName, err)
+		}
+	}
+	return findPodByMountPoint(ctx, clientset, localMountPoint)
+}
+
+func findPodByMountPoint(ctx context.Context, clientset kubernetes.Interface, localMountPoint string) (*corev1.Pod, error) {
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=volume-exposer,mountPointHash=%s", mountPointHash(localMountPoint)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	switch len(podList.Items) {
+	case 0:
+		return nil, fmt.Errorf("%w: no pod for mount point %s", ErrPodNotFound, localMountPoint)
+	case 1:
+		return &podList.Items[0], nil
+	}
+
+	var pods []string
+	for _, pod := range podList.Items {
+		pods = append(pods, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+	}
+	return nil, fmt.Errorf("multiple pods found for mount point %s: %s, please specify namespace and PVC name", localMountPoint, strings.Join(pods, ", "))
+}
+
+// killProcessInEphemeralContainer stops the tunnel in the named ephemeral container of the pod.
+// The pod may have ephemeral containers of other mounts, so only proxy pods created before the
+// container was recorded on them fall back to the newest one.
+func killProcessInEphemeralContainer(ctx context.Context, clientset kubernetes.Interface, namespace, podName, ephemeralContainerName string) error {
 	existingPod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to get existing pod: %v", err)
+		return fmt.Errorf("failed to get existing pod: %w", err)
 	}
 
-	if len(existingPod.Spec.EphemeralContainers) == 0 {
+	containers := existingPod.Spec.EphemeralContainers
+	if len(containers) == 0 {
 		return fmt.Errorf("no ephemeral containers found in pod %s", podName)
 	}
 
-	ephemeralContainerName := existingPod.Spec.EphemeralContainers[0].Name
-	fmt.Printf("Ephemeral container name is %s\n", ephemeralContainerName)
+	if ephemeralContainerName == "" {
+		ephemeralContainerName = containers[len(containers)-1].Name
+		fmt.Printf("Warning: no ephemeral container recorded for the mount, using the newest one %s of pod %s\n", ephemeralContainerName, podName)
+	} else if !hasEphemeralContainer(existingPod, ephemeralContainerName) {
+		return fmt.Errorf("no ephemeral container %s found in pod %s", ephemeralContainerName, podName)
+	}
 
 	// Command to kill the process (adjust the process name or ID as necessary)
 	killCmd := []string{"pkill", "-f", "tail"} // Replace "tail" with the actual process name or use a specific PID
 
-	cmd := exec.Command("kubectl", append([]string{"exec", podName, "-n", namespace, "-c", ephemeralContainerName, "--"}, killCmd...)...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	backoff := ephemeralExecBackoff
+	var stderr string
+	for {
+		stderr, err = execInContainer(ctx, clientset, namespace, podName, ephemeralContainerName, killCmd)
+		if err == nil || isNoProcessFoundError(err, stderr) || isContainerTerminatedError(err, stderr) || backoff.Steps <= 1 {
+			break
+		}
+		// A container added moments ago, e.g. by a mount just before, may not take execs yet
+		fmt.Printf("Failed to exec into container %s of pod %s, retrying: %v\n", ephemeralContainerName, podName, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+	if err != nil {
+		if isNoProcessFoundError(err, stderr) {
+			fmt.Printf("No process left to kill in container %s of pod %s\n", ephemeralContainerName, podName)
+			return nil
+		}
+		if isContainerTerminatedError(err, stderr) || isContainerNotFoundError(err, stderr) {
+			// The process went away together with the container
+			fmt.Printf("Container %s of pod %s isn't running anymore, no process left to kill\n", ephemeralContainerName, podName)
+			return nil
+		}
+		if stderr = strings.TrimSpace(stderr); stderr != "" {
+			return fmt.Errorf("failed to kill process in container %s of pod %s: %v: %s", ephemeralContainerName, podName, err, stderr)
+		}
 		return fmt.Errorf("failed to kill process in container %s of pod %s: %v", ephemeralContainerName, podName, err)
 	}
 	return nil
 }
+
+func hasEphemeralContainer(pod *corev1.Pod, name string) bool {
+	for _, container := range pod.Spec.EphemeralContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// execInContainer runs a command in a container of a pod and returns what it wrote to stderr.
+var execInContainer = func(ctx context.Context, clientset kubernetes.Interface, namespace, podName, container string, command []string) (string, error) {
+	config, err := buildKubeConfig()
+	if err != nil {
+		return "", err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", fmt.Errorf("failed to create executor: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: os.Stdout,
+		Stderr: io.MultiWriter(os.Stderr, &stderr),
+	})
+	return stderr.String(), err
+}
+
+// ephemeralExecBackoff spaces the attempts to exec into an ephemeral container. Its Steps are
+// the number of attempts.
+var ephemeralExecBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+// isContainerTerminatedError reports whether the exec failed because the container exited.
+func isContainerTerminatedError(err error, stderr string) bool {
+	message := strings.ToLower(err.Error() + "\n" + stderr)
+	return strings.Contains(message, "already terminated") || strings.Contains(message, "cannot exec in a stopped")
+}
+
+// isContainerNotFoundError reports whether the runtime doesn't know the container. That's
+// also the case for a container that didn't start yet, so it only counts once retries ran out.
+func isContainerNotFoundError(err error, stderr string) bool {
+	return strings.Contains(strings.ToLower(err.Error()+"\n"+stderr), "container not found")
+}
+
+// isNoProcessFoundError reports whether pkill only failed because there was nothing to kill,
+// e.g. the process already exited together with the SSH connection.
+func isNoProcessFoundError(err error, stderr string) bool {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		// pkill exits with 1 when no process matched
+		return true
+	}
+	return strings.Contains(strings.ToLower(stderr), "no process found")
+}
+
+// unmountLocal unmounts the mount point and reports whether it was mounted.
+func unmountLocal(localMountPoint string, force bool) (bool, error) {
+	umountCmd, err := buildUnmountCommand(runtime.GOOS, localMountPoint)
+	if err != nil {
+		return false, err
+	}
+	var umountStderr bytes.Buffer
+	umountCmd.Stdout = os.Stdout
+	umountCmd.Stderr = io.MultiWriter(os.Stderr, &umountStderr)
+	err = umountCmd.Run()
+	if err == nil {
+		fmt.Printf("Unmounted %s successfully\n", localMountPoint)
+		return true, nil
+	}
+	if isNotMountedError(err, umountStderr.String()) {
+		fmt.Printf("Warning: %s is not mounted, continuing with cleanup\n", localMountPoint)
+		return false, nil
+	}
+	if !isStaleMountError(umountStderr.String()) {
+		return false, fmt.Errorf("failed to unmount SSHFS: %v", err)
+	}
+	if !force {
+		return false, fmt.Errorf("failed to unmount SSHFS: %s is a stale mount point, use --force to unmount it lazily: %v", localMountPoint, err)
+	}
+
+	fmt.Printf("%s is a stale mount point, unmounting it lazily\n", localMountPoint)
+	forceCmd, err := buildForceUnmountCommand(runtime.GOOS, localMountPoint)
+	if err != nil {
+		return false, err
+	}
+	forceCmd.Stdout = os.Stdout
+	forceCmd.Stderr = os.Stderr
+	if err := forceCmd.Run(); err != nil {
+		return false, fmt.Errorf("failed to force unmount SSHFS: %v", err)
+	}
+	fmt.Printf("Unmounted %s successfully\n", localMountPoint)
+	return true, nil
+}
+
+// buildUnmountCommand returns the command used to unmount an SSHFS mount point on the given OS.
+func buildUnmountCommand(goos, localMountPoint string) (*exec.Cmd, error) {
+	if err := checkSupportedOS(goos); err != nil {
+		return nil, err
+	}
+	if goos == "darwin" {
+		return exec.Command("umount", localMountPoint), nil
+	}
+	return exec.Command("fusermount", "-u", localMountPoint), nil
+}
+
+// isNotMountedError reports whether a failed unmount only failed because nothing was mounted,
+// e.g. the SSHFS process already died. Such failures shouldn't block cleaning up the cluster side.
+func isNotMountedError(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	stderr = strings.ToLower(stderr)
+	for _, msg := range []string{
+		"not mounted",            // umount on Linux
+		"not currently mounted",  // umount on macOS
+		"not found in /etc/mtab", // fusermount
+	} {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildForceUnmountCommand returns the command used to detach a stale SSHFS mount point on the
+// given OS, which the regular unmount can't handle once the SSHFS connection is gone.
+func buildForceUnmountCommand(goos, localMountPoint string) (*exec.Cmd, error) {
+	if err := checkSupportedOS(goos); err != nil {
+		return nil, err
+	}
+	if goos == "darwin" {
+		return exec.Command("umount", "-f", localMountPoint), nil
+	}
+	return exec.Command("fusermount", "-uz", localMountPoint), nil
+}
+
+// isStaleMountError reports whether an unmount failed because the mount point is stale,
+// i.e. the exposer pod or the port-forward died while the volume was still mounted.
+func isStaleMountError(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, msg := range []string{
+		"transport endpoint is not connected", // Linux
+		"socket is not connected",             // macOS
+		"device not configured",               // macOS
+	} {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+	return false
+}
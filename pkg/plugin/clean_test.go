@@ -0,0 +1,906 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestBuildUnmountCommand(t *testing.T) {
+	tests := []struct {
+		goos     string
+		expected string
+		wantErr  bool
+	}{
+		{goos: "linux", expected: "fusermount -u /mnt/data"},
+		{goos: "darwin", expected: "umount /mnt/data"},
+		{goos: "windows", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			cmd, err := buildUnmountCommand(tt.goos, "/mnt/data")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("buildUnmountCommand(%s) should have returned an error", tt.goos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildUnmountCommand(%s) returned an unexpected error: %v", tt.goos, err)
+			}
+			if got := strings.Join(cmd.Args, " "); got != tt.expected {
+				t.Errorf("Expected command '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBuildForceUnmountCommand(t *testing.T) {
+	tests := []struct {
+		goos     string
+		expected string
+		wantErr  bool
+	}{
+		{goos: "linux", expected: "fusermount -uz /mnt/data"},
+		{goos: "darwin", expected: "umount -f /mnt/data"},
+		{goos: "windows", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			cmd, err := buildForceUnmountCommand(tt.goos, "/mnt/data")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("buildForceUnmountCommand(%s) should have returned an error", tt.goos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildForceUnmountCommand(%s) returned an unexpected error: %v", tt.goos, err)
+			}
+			if got := strings.Join(cmd.Args, " "); got != tt.expected {
+				t.Errorf("Expected command '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsStaleMountError(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected bool
+	}{
+		{"Linux stale mount", "fusermount: failed to unmount /mnt/data: Transport endpoint is not connected", true},
+		{"macOS stale mount", "umount(/mnt/data): Socket is not connected", true},
+		{"macOS dead device", "umount: /mnt/data: Device not configured", true},
+		{"device busy", "fusermount: failed to unmount /mnt/data: Device or resource busy", false},
+		{"not mounted", "umount: /mnt/data: not mounted.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStaleMountError(tt.stderr); got != tt.expected {
+				t.Errorf("isStaleMountError() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsNotMountedError(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 1").Run()
+	if exitErr == nil {
+		t.Fatal("Expected the command to fail")
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		stderr   string
+		expected bool
+	}{
+		{"fusermount not mounted", exitErr, "fusermount: entry for /mnt/data not found in /etc/mtab", true},
+		{"Linux umount not mounted", exitErr, "umount: /mnt/data: not mounted.", true},
+		{"macOS umount not mounted", exitErr, "umount: /mnt/data: not currently mounted", true},
+		{"device busy", exitErr, "fusermount: failed to unmount /mnt/data: Device or resource busy", false},
+		{"command not found", errors.New("exec: \"fusermount\": executable file not found in $PATH"), "not mounted", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotMountedError(tt.err, tt.stderr); got != tt.expected {
+				t.Errorf("isNotMountedError() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func newExposerPod(namespace, podName, pvcName, localMountPoint string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: namespace,
+			Labels:    buildPodLabels(pvcName, localMountPoint, 12345, DefaultSSHPort, ""),
+		},
+	}
+}
+
+func TestFindPodByMountPoint(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Single pod", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			newExposerPod("team-a", "volume-exposer-abcde", "data", "/mnt/data"),
+			newExposerPod("team-b", "volume-exposer-fghij", "logs", "/mnt/logs"),
+		)
+
+		pod, err := findPodByMountPoint(ctx, clientset, "/mnt/data")
+		if err != nil {
+			t.Fatalf("findPodByMountPoint() returned an error: %v", err)
+		}
+		if pod.Namespace != "team-a" || pod.Labels["pvcName"] != "data" {
+			t.Errorf("Unexpected pod %s/%s for PVC %s", pod.Namespace, pod.Name, pod.Labels["pvcName"])
+		}
+	})
+
+	t.Run("No pod", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newExposerPod("team-a", "volume-exposer-abcde", "data", "/mnt/data"))
+		if _, err := findPodByMountPoint(ctx, clientset, "/mnt/other"); err == nil {
+			t.Error("findPodByMountPoint() should have returned an error")
+		}
+	})
+
+	t.Run("Multiple pods", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(
+			newExposerPod("team-a", "volume-exposer-abcde", "data", "/mnt/data"),
+			newExposerPod("team-b", "volume-exposer-fghij", "data", "/mnt/data"),
+		)
+		_, err := findPodByMountPoint(ctx, clientset, "/mnt/data")
+		if err == nil || !strings.Contains(err.Error(), "team-a/volume-exposer-abcde") {
+			t.Errorf("Expected an error listing the matching pods, got: %v", err)
+		}
+	})
+}
+
+func TestPortForwardPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected string
+	}{
+		{
+			name:     "Proxy pod forwards to the tunnel port",
+			pod:      createPodSpec("volume-exposer-proxy-abcde", 12345, "test-pvc", "/mnt/data", "publicKey", "proxy", ProxySSHPort, "workload", MountOptions{}),
+			expected: "kubectl port-forward pod/volume-exposer-proxy-abcde 12345:2137",
+		},
+		{
+			name:     "Standalone pod with custom SSH port",
+			pod:      createPodSpec("volume-exposer-abcde", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 2222, "", MountOptions{}),
+			expected: "kubectl port-forward pod/volume-exposer-abcde 12345:2222",
+		},
+		{
+			name: "Pod without SSH port label",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:   "volume-exposer-abcde",
+				Labels: map[string]string{"portNumber": "12345"},
+			}},
+			expected: "kubectl port-forward pod/volume-exposer-abcde 12345:2137",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portForwardPattern(tt.pod); got != tt.expected {
+				t.Errorf("portForwardPattern() = '%s'; want '%s'", got, tt.expected)
+			}
+			// The pattern must match the command line actually started for the pod
+			remotePort := tt.pod.Labels["sshPort"]
+			if remotePort != "" {
+				cmd := buildPortForwardCommand("default", tt.pod.Name, 12345, mustAtoi(t, remotePort), "0.0.0.0")
+				if !strings.HasPrefix(strings.Join(cmd.Args, " "), tt.expected) {
+					t.Errorf("Port-forward command %v doesn't match pattern '%s'", cmd.Args, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", s, err)
+	}
+	return n
+}
+
+func TestDeletePodGracePeriod(t *testing.T) {
+	for _, gracePeriod := range []int64{0, 30} {
+		clientset := fake.NewSimpleClientset(newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data"))
+		var deleteOptions metav1.DeleteOptions
+		clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deleteOptions = action.(k8stesting.DeleteActionImpl).DeleteOptions
+			return false, nil, nil
+		})
+
+		if err := deletePod(context.Background(), clientset, "default", "volume-exposer-abcde", gracePeriod); err != nil {
+			t.Fatalf("deletePod() returned an error: %v", err)
+		}
+		if deleteOptions.GracePeriodSeconds == nil || *deleteOptions.GracePeriodSeconds != gracePeriod {
+			t.Errorf("Expected grace period %d, got %v", gracePeriod, deleteOptions.GracePeriodSeconds)
+		}
+	}
+}
+
+// keepDeletedPods makes deleted pods stay until they were looked up terminating the given
+// number of times, like pods held back by finalizers for a while.
+func keepDeletedPods(clientset *fake.Clientset, terminatingGets int) {
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	gets := 0
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if terminatingGets >= 0 && gets > terminatingGets {
+			return true, nil, apierrors.NewNotFound(corev1.Resource("pods"), action.(k8stesting.GetAction).GetName())
+		}
+		return false, nil, nil
+	})
+}
+
+func TestWaitForPodDeleted(t *testing.T) {
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	clientset := fake.NewSimpleClientset(pod)
+	keepDeletedPods(clientset, 1)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = waitForPodDeleted(context.Background(), clientset, "default", pod.Name, 5*time.Second)
+	})
+	if err != nil {
+		t.Fatalf("waitForPodDeleted() returned an error: %v", err)
+	}
+	if !strings.Contains(out, "Pod volume-exposer-abcde is gone") {
+		t.Errorf("Expected the pod to be reported gone, got %q", out)
+	}
+
+	// Stuck terminating
+	pod.Finalizers = []string{"example.com/protect"}
+	clientset = fake.NewSimpleClientset(pod)
+	keepDeletedPods(clientset, -1)
+	err = waitForPodDeleted(context.Background(), clientset, "default", pod.Name, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "still terminating") || !strings.Contains(err.Error(), "example.com/protect") {
+		t.Errorf("Expected an error naming the finalizer of the terminating pod, got %v", err)
+	}
+}
+
+func TestCleanPodWaitDeleted(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	for _, wait := range []time.Duration{0, 5 * time.Second} {
+		clientset := fake.NewSimpleClientset(pod)
+		keepDeletedPods(clientset, 1)
+
+		var err error
+		out := captureStdout(t, func() {
+			err = cleanPod(context.Background(), clientset, pod, CleanOptions{WaitDeleted: wait}, &CleanResult{})
+		})
+		if err != nil {
+			t.Fatalf("cleanPod() returned an error: %v", err)
+		}
+		if waited := strings.Contains(out, "is gone"); waited != (wait > 0) {
+			t.Errorf("Expected the pod to be waited for only with WaitDeleted (%s), got %q", wait, out)
+		}
+	}
+}
+
+func TestExposerSelector(t *testing.T) {
+	tests := []struct {
+		extra    string
+		expected string
+		wantErr  bool
+	}{
+		{"", "app=volume-exposer", false},
+		{"team=a", "app=volume-exposer,team=a", false},
+		{"team in (a,b),!debug", "app=volume-exposer,!debug,team in (a,b)", false},
+		{"team in (a", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := exposerSelector(tt.extra)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("exposerSelector(%q) error = %v; wantErr %v", tt.extra, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("exposerSelector(%q) = %q; want %q", tt.extra, got, tt.expected)
+		}
+	}
+}
+
+func TestCleanAllSelector(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+	useMountTable(t, "")
+
+	teamA := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	teamA.Labels["team"] = "a"
+	teamB := newExposerPod("default", "volume-exposer-fghij", "logs", "/mnt/logs")
+	teamB.Labels["team"] = "b"
+	clientset := fake.NewSimpleClientset(teamA, teamB)
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanAll(context.Background(), clientset, "default", CleanOptions{Selector: "team=a"}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanAll() returned an error: %v", err)
+	}
+
+	var listSelector string
+	for _, action := range clientset.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "pods" {
+			listSelector = list.GetListRestrictions().Labels.String()
+		}
+	}
+	if listSelector != "app=volume-exposer,team=a" {
+		t.Errorf("Expected the pods to be listed with the combined selector, got %q", listSelector)
+	}
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != teamB.Name {
+		t.Errorf("Expected only the pod of team b to be left, got %v", pods.Items)
+	}
+}
+
+func TestCleanAllInvalidSelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	if err := cleanAll(context.Background(), clientset, "", CleanOptions{Selector: "team in (a"}, &CleanResult{}); err == nil {
+		t.Error("cleanAll() should have returned an error for an invalid selector")
+	}
+	if len(clientset.Actions()) != 0 {
+		t.Errorf("Expected no API calls with an invalid selector, got %v", clientset.Actions())
+	}
+}
+
+func TestCleanAllMaxAge(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+	useMountTable(t, "")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = original })
+
+	newPod := func(name string, age time.Duration, maxAge string) *corev1.Pod {
+		pod := newExposerPod("default", name, "data-"+name, "/mnt/"+name)
+		pod.CreationTimestamp = metav1.NewTime(now.Add(-age))
+		if maxAge != "" {
+			pod.Annotations = map[string]string{MaxAgeAnnotation: maxAge}
+		}
+		return pod
+	}
+	clientset := fake.NewSimpleClientset(
+		newPod("old", 3*time.Hour, ""),
+		newPod("young", 30*time.Minute, ""),
+		newPod("long-lived", 3*time.Hour, "8h"),
+		newPod("short-lived", 30*time.Minute, "10m"),
+	)
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanAll(context.Background(), clientset, "default", CleanOptions{MaxAge: 2 * time.Hour}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanAll() returned an error: %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	var left []string
+	for _, pod := range pods.Items {
+		left = append(left, pod.Name)
+	}
+	sort.Strings(left)
+	if strings.Join(left, ",") != "long-lived,young" {
+		t.Errorf("Expected only the pods within their max age to be left, got %v", left)
+	}
+}
+
+func TestFindMountPointPod(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	ctx := context.Background()
+
+	// Labeled for another mount point, so only the local record leads to it
+	recorded := newExposerPod("team-a", "volume-exposer-abcde", "data", "/mnt/elsewhere")
+	labeled := newExposerPod("team-b", "volume-exposer-fghij", "logs", "/mnt/logs")
+	clientset := fake.NewSimpleClientset(recorded, labeled)
+
+	store, err := defaultStateStore()
+	if err != nil {
+		t.Fatalf("defaultStateStore() returned an error: %v", err)
+	}
+	record := newTestRecord("team-a", "volume-exposer-abcde", 4242)
+	record.LocalMountPoint = "/mnt/data"
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	pod, err := findMountPointPod(ctx, clientset, "/mnt/data")
+	if err != nil {
+		t.Fatalf("findMountPointPod() returned an error: %v", err)
+	}
+	if pod.Name != "volume-exposer-abcde" {
+		t.Errorf("Expected the recorded pod, got %s", pod.Name)
+	}
+
+	pod, err = findMountPointPod(ctx, clientset, "/mnt/logs")
+	if err != nil {
+		t.Fatalf("findMountPointPod() returned an error: %v", err)
+	}
+	if pod.Name != "volume-exposer-fghij" {
+		t.Errorf("Expected the labeled pod without a record, got %s", pod.Name)
+	}
+
+	// The recorded pod is gone, the label is all that's left to go by
+	record = newTestRecord("team-b", "volume-exposer-gone", 4242)
+	record.LocalMountPoint = "/mnt/logs"
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	pod, err = findMountPointPod(ctx, clientset, "/mnt/logs")
+	if err != nil {
+		t.Fatalf("findMountPointPod() returned an error: %v", err)
+	}
+	if pod.Name != "volume-exposer-fghij" {
+		t.Errorf("Expected the labeled pod for a stale record, got %s", pod.Name)
+	}
+}
+
+func TestCleanPVCNotFound(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	err := cleanPVC(context.Background(), clientset, "default", "missing-pvc", "", CleanOptions{}, &CleanResult{})
+	if !errors.Is(err, ErrPodNotFound) {
+		t.Errorf("Expected ErrPodNotFound without --ignore-not-found, got %v", err)
+	}
+
+	captureStdout(t, func() {
+		err = cleanPVC(context.Background(), clientset, "default", "missing-pvc", "", CleanOptions{IgnoreNotFound: true}, &CleanResult{})
+	})
+	if err != nil {
+		t.Errorf("Expected success with --ignore-not-found, got %v", err)
+	}
+}
+
+func TestCleanPVCMountedTwice(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	clientset := fake.NewSimpleClientset(
+		newExposerPod("default", "volume-exposer-first", "data", "/mnt/first"),
+		newExposerPod("default", "volume-exposer-second", "data", "/mnt/second"),
+	)
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanPVC(context.Background(), clientset, "default", "data", "/mnt/second", CleanOptions{}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanPVC() returned an unexpected error: %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "volume-exposer-first" {
+		t.Errorf("Expected only the pod of the other mount point to be left, got %v", pods.Items)
+	}
+}
+
+func TestCleanPVCLeftoverPods(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	clientset := fake.NewSimpleClientset(
+		newExposerPod("default", "volume-exposer-first", "data", "/mnt/data"),
+		newExposerPod("default", "volume-exposer-second", "data", "/mnt/data"),
+	)
+
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanPVC(context.Background(), clientset, "default", "data", "/mnt/data", CleanOptions{}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanPVC() returned an unexpected error: %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("Expected all pods of the mount point to be deleted, got %v", pods.Items)
+	}
+	if len(result.PodsDeleted) != 2 {
+		t.Errorf("Expected both pods in the result, got %v", result.PodsDeleted)
+	}
+}
+
+func TestCleanPVCAmbiguous(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newExposerPod("default", "volume-exposer-first", "data", "/mnt/first"),
+		newExposerPod("default", "volume-exposer-second", "data", "/mnt/second"),
+	)
+
+	err := cleanPVC(context.Background(), clientset, "default", "data", "", CleanOptions{}, &CleanResult{})
+	if err == nil || !strings.Contains(err.Error(), "multiple pods found") {
+		t.Errorf("Expected the pods to be ambiguous without a mount point, got %v", err)
+	}
+	assertNoWrites(t, clientset)
+}
+
+func TestCleanPodIgnoreNotFound(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	// The workload pod of the ephemeral container is gone already
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	pod.Labels["originalPodName"] = "workload"
+	clientset := fake.NewSimpleClientset(pod)
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), clientset, pod, CleanOptions{}, &CleanResult{})
+	})
+	if err == nil {
+		t.Error("Expected an error for the missing workload pod without --ignore-not-found")
+	}
+
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), clientset, pod, CleanOptions{IgnoreNotFound: true}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("Expected success with --ignore-not-found, got %v", err)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), pod.Name, metav1.GetOptions{}); err == nil {
+		t.Error("Expected the exposer pod to be deleted")
+	}
+}
+
+// useFastExecBackoff makes retried execs into ephemeral containers not wait for the duration
+// of the test.
+func useFastExecBackoff(t *testing.T) {
+	t.Helper()
+	original := ephemeralExecBackoff
+	ephemeralExecBackoff.Duration = time.Millisecond
+	t.Cleanup(func() { ephemeralExecBackoff = original })
+}
+
+func TestKillProcessInEphemeralContainerRetries(t *testing.T) {
+	useFastExecBackoff(t)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "default"},
+		Spec: corev1.PodSpec{EphemeralContainers: []corev1.EphemeralContainer{
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-abcde"}},
+		}},
+	}
+	oldExecInContainer := execInContainer
+	t.Cleanup(func() { execInContainer = oldExecInContainer })
+
+	t.Run("Fails once", func(t *testing.T) {
+		attempts := 0
+		execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, _ string, _ []string) (string, error) {
+			attempts++
+			if attempts == 1 {
+				return "", errors.New("unable to upgrade connection: container volume-exposer-ephemeral-abcde is waiting to start")
+			}
+			return "", nil
+		}
+		var err error
+		captureStdout(t, func() {
+			err = killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), "default", "workload", "volume-exposer-ephemeral-abcde")
+		})
+		if err != nil {
+			t.Fatalf("killProcessInEphemeralContainer() returned an error: %v", err)
+		}
+		if attempts != 2 {
+			t.Errorf("Expected the exec to be retried once, got %d attempts", attempts)
+		}
+	})
+
+	t.Run("Keeps failing", func(t *testing.T) {
+		attempts := 0
+		execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, _ string, _ []string) (string, error) {
+			attempts++
+			return "", errors.New("connection refused")
+		}
+		var err error
+		captureStdout(t, func() {
+			err = killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), "default", "workload", "volume-exposer-ephemeral-abcde")
+		})
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("Expected the last error once the attempts ran out, got %v", err)
+		}
+		if attempts != ephemeralExecBackoff.Steps {
+			t.Errorf("Expected %d attempts, got %d", ephemeralExecBackoff.Steps, attempts)
+		}
+	})
+
+	t.Run("Terminated container isn't retried", func(t *testing.T) {
+		attempts := 0
+		execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, _ string, _ []string) (string, error) {
+			attempts++
+			return "", errors.New("cannot exec in a stopped container")
+		}
+		var err error
+		captureStdout(t, func() {
+			err = killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), "default", "workload", "volume-exposer-ephemeral-abcde")
+		})
+		if err != nil {
+			t.Fatalf("Expected a stopped container to count as killed, got %v", err)
+		}
+		if attempts != 1 {
+			t.Errorf("Expected a single attempt, got %d", attempts)
+		}
+	})
+}
+
+func TestKillProcessInEphemeralContainer(t *testing.T) {
+	namespace := "default"
+	podName := "workload"
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Spec: corev1.PodSpec{
+			// The oldest one belongs to another mount of the same pod
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-older"}},
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-abcde"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		stderr      string
+		err         error
+		wantErr     bool
+		errContains string
+	}{
+		{name: "Process killed"},
+		{name: "No process left", err: utilexec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1}},
+		{name: "No process found message", stderr: "pkill: no process found", err: errors.New("command terminated with exit code 2")},
+		{name: "Container not found", stderr: "error: container not found (\"volume-exposer-ephemeral-abcde\")\n", err: utilexec.CodeExitError{Err: errors.New("command terminated with exit code 126"), Code: 126}},
+		{name: "Container terminated", err: errors.New("container volume-exposer-ephemeral-abcde is already terminated")},
+		{
+			name:        "Exec failure",
+			stderr:      "OCI runtime exec failed: permission denied\n",
+			err:         utilexec.CodeExitError{Err: errors.New("command terminated with exit code 126"), Code: 126},
+			wantErr:     true,
+			errContains: "permission denied",
+		},
+	}
+	useFastExecBackoff(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldExecInContainer := execInContainer
+			t.Cleanup(func() { execInContainer = oldExecInContainer })
+			var container string
+			var command []string
+			execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, c string, cmd []string) (string, error) {
+				container, command = c, cmd
+				return tt.stderr, tt.err
+			}
+
+			err := killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), namespace, podName, "volume-exposer-ephemeral-abcde")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("Expected error containing '%s', got %v", tt.errContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("killProcessInEphemeralContainer() returned an unexpected error: %v", err)
+			}
+			if container != "volume-exposer-ephemeral-abcde" || strings.Join(command, " ") != "pkill -f tail" {
+				t.Errorf("Expected pkill in the ephemeral container, got %v in %s", command, container)
+			}
+		})
+	}
+
+	t.Run("Unknown container", func(t *testing.T) {
+		err := killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), namespace, podName, "volume-exposer-ephemeral-gone")
+		if err == nil || !strings.Contains(err.Error(), "volume-exposer-ephemeral-gone") {
+			t.Errorf("Expected an error for the unknown container, got %v", err)
+		}
+	})
+
+	t.Run("Unrecorded container falls back to the newest", func(t *testing.T) {
+		oldExecInContainer := execInContainer
+		t.Cleanup(func() { execInContainer = oldExecInContainer })
+		var container string
+		execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, c string, _ []string) (string, error) {
+			container = c
+			return "", nil
+		}
+
+		var err error
+		captureStdout(t, func() {
+			err = killProcessInEphemeralContainer(context.Background(), fake.NewSimpleClientset(pod), namespace, podName, "")
+		})
+		if err != nil {
+			t.Fatalf("killProcessInEphemeralContainer() returned an unexpected error: %v", err)
+		}
+		if container != "volume-exposer-ephemeral-abcde" {
+			t.Errorf("Expected the newest ephemeral container, got %s", container)
+		}
+	})
+}
+
+func TestCleanAllResult(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+	useMountTable(t, "")
+
+	workload := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-abcde"}},
+			},
+		},
+	}
+	proxy := newExposerPod("default", "volume-exposer-proxy-abcde", "data", "/mnt/data")
+	proxy.Labels["originalPodName"] = "workload"
+	proxy.Annotations = map[string]string{EphemeralContainerAnnotation: "volume-exposer-ephemeral-abcde"}
+	viaService := newExposerPod("default", "volume-exposer-fghij", "logs", "/mnt/logs")
+	viaService.Annotations = map[string]string{ViaAnnotation: ViaService}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: viaService.Name, Namespace: "default"}}
+
+	oldExecInContainer := execInContainer
+	t.Cleanup(func() { execInContainer = oldExecInContainer })
+	execInContainer = func(context.Context, kubernetes.Interface, string, string, string, []string) (string, error) {
+		return "", nil
+	}
+
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanAll(context.Background(), fake.NewSimpleClientset(workload, proxy, viaService, service), "default", CleanOptions{}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanAll() returned an error: %v", err)
+	}
+
+	expected := &CleanResult{
+		PortForwardsKilled:       []string{"default/volume-exposer-proxy-abcde"},
+		ServicesDeleted:          []string{"default/volume-exposer-fghij"},
+		EphemeralProcessesKilled: []string{"default/workload/volume-exposer-ephemeral-abcde"},
+		PodsDeleted:              []string{"default/volume-exposer-fghij", "default/volume-exposer-proxy-abcde"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected result %+v, got %+v", expected, result)
+	}
+	if summary := result.Summary(); !strings.Contains(summary, "0 mount point(s) unmounted") || !strings.Contains(summary, "2 pod(s) deleted") {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestCleanAllSkipUnmount(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	useMountTable(t, "ve@localhost:/volume /mnt/data fuse.sshfs rw,nosuid,nodev 0 0\n")
+
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	pod.Annotations = map[string]string{MountPointAnnotation: "/mnt/data"}
+	clientset := fake.NewSimpleClientset(pod)
+
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanAll(context.Background(), clientset, "default", CleanOptions{SkipUnmount: true}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanAll() returned an error: %v", err)
+	}
+	for _, args := range r.run {
+		if strings.Contains(strings.Join(args, " "), "/mnt/data") {
+			t.Errorf("Expected the mount point to be left alone, ran %v", args)
+		}
+	}
+	if len(result.Unmounted) != 0 || len(result.PodsDeleted) != 1 {
+		t.Errorf("Expected only the pod to be cleaned, got %+v", result)
+	}
+
+	// Unmounting would fail, the pods are cleaned regardless
+	r.runErr = errors.New("fusermount: failed to unmount /mnt/data: Device or resource busy")
+	result = &CleanResult{}
+	captureStdout(t, func() {
+		err = result.unmount("/mnt/data", CleanOptions{SkipUnmount: true})
+	})
+	if err != nil || len(result.Unmounted) != 0 {
+		t.Errorf("Expected the unmount to be skipped, got %v and %+v", err, result)
+	}
+}
+
+func TestCleanPodResultIgnoresMissingPods(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	pod := newExposerPod("default", "volume-exposer-abcde", "data", "/mnt/data")
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), fake.NewSimpleClientset(), pod, CleanOptions{IgnoreNotFound: true}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanPod() returned an error: %v", err)
+	}
+	if len(result.PodsDeleted) != 0 {
+		t.Errorf("Expected a pod that was gone already not to be listed, got %v", result.PodsDeleted)
+	}
+}
+
+func TestCleanPodKillsRecordedEphemeralContainer(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	workload := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-first"}},
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "volume-exposer-ephemeral-second"}},
+			},
+		},
+	}
+	pod := newExposerPod("default", "volume-exposer-proxy-abcde", "data", "/mnt/data")
+	pod.Labels["originalPodName"] = "workload"
+	pod.Annotations = map[string]string{EphemeralContainerAnnotation: "volume-exposer-ephemeral-first"}
+
+	oldExecInContainer := execInContainer
+	t.Cleanup(func() { execInContainer = oldExecInContainer })
+	var container string
+	execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, c string, _ []string) (string, error) {
+		container = c
+		return "", nil
+	}
+
+	var err error
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), fake.NewSimpleClientset(workload, pod), pod, CleanOptions{}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanPod() returned an unexpected error: %v", err)
+	}
+	if container != "volume-exposer-ephemeral-first" {
+		t.Errorf("Expected the ephemeral container of the mount to be stopped, got %s", container)
+	}
+}
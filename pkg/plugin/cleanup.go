@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// cleanupStack collects how to undo what an operation created so far. A failed or
+// interrupted operation unwinds it, one that succeeded releases it.
+type cleanupStack struct {
+	funcs []func() error
+}
+
+// push registers how to undo the resource that was just created.
+func (s *cleanupStack) push(fn func() error) {
+	s.funcs = append(s.funcs, fn)
+}
+
+// unwind runs the registered cleanups in reverse order, so resources are removed before
+// what they depend on. Failures are only reported, the remaining cleanups still run.
+func (s *cleanupStack) unwind() {
+	for i := len(s.funcs) - 1; i >= 0; i-- {
+		if err := s.funcs[i](); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	s.funcs = nil
+}
+
+// release forgets the registered cleanups, the resources now belong to the caller.
+func (s *cleanupStack) release() {
+	s.funcs = nil
+}
+
+// The cleanups below use their own context, because the operation's context may be
+// the reason it failed.
+
+func cleanupPod(clientset kubernetes.Interface, namespace, podName string) func() error {
+	return func() error {
+		if err := deletePod(context.Background(), clientset, namespace, podName, 0); err != nil {
+			return fmt.Errorf("failed to delete pod %s after failed mount: %v", podName, err)
+		}
+		fmt.Printf("Pod %s deleted after failed mount\n", podName)
+		return nil
+	}
+}
+
+func cleanupService(clientset kubernetes.Interface, namespace, serviceName string) func() error {
+	return func() error {
+		return deleteService(context.Background(), clientset, namespace, serviceName)
+	}
+}
+
+// cleanupEphemeralContainer stops the process of the named ephemeral container in the pod. Ephemeral
+// containers can't be removed, but without their process they stop holding the tunnel.
+func cleanupEphemeralContainer(clientset kubernetes.Interface, namespace, podName, containerName string) func() error {
+	return func() error {
+		if err := killProcessInEphemeralContainer(context.Background(), clientset, namespace, podName, containerName); err != nil {
+			return fmt.Errorf("failed to kill process in ephemeral container of pod %s: %v", podName, err)
+		}
+		return nil
+	}
+}
+
+func cleanupPortForward(podName string, portForward *exec.Cmd) func() error {
+	return func() error {
+		if portForward == nil || portForward.Process == nil {
+			return nil
+		}
+		if err := portForward.Process.Kill(); err != nil {
+			return fmt.Errorf("failed to stop port-forward for pod %s: %v", podName, err)
+		}
+		return nil
+	}
+}
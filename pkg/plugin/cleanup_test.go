@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanupStackUnwind(t *testing.T) {
+	var order []string
+	cleanup := &cleanupStack{}
+	for _, name := range []string{"pod", "service", "port-forward"} {
+		cleanup.push(func() error {
+			order = append(order, name)
+			if name == "service" {
+				return errors.New("service is stuck")
+			}
+			return nil
+		})
+	}
+
+	out := captureStdout(t, cleanup.unwind)
+
+	// A failing cleanup doesn't stop the ones registered before it
+	if want := []string{"port-forward", "service", "pod"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Expected cleanups to run in reverse order %v, got %v", want, order)
+	}
+	if !strings.Contains(out, "Warning: service is stuck") {
+		t.Errorf("Expected a warning about the failed cleanup, got:\n%s", out)
+	}
+
+	order = nil
+	cleanup.unwind()
+	if len(order) != 0 {
+		t.Errorf("Expected cleanups to run only once, got %v", order)
+	}
+}
+
+func TestCleanupStackRelease(t *testing.T) {
+	var ran bool
+	cleanup := &cleanupStack{}
+	cleanup.push(func() error {
+		ran = true
+		return nil
+	})
+
+	cleanup.release()
+	cleanup.unwind()
+	if ran {
+		t.Error("Expected no cleanup to run after the stack was released")
+	}
+}
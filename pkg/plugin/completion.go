@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CompleteNamespaces returns the namespaces starting with toComplete, for shell completion.
+func CompleteNamespaces(ctx context.Context, clientset kubernetes.Interface, toComplete string) ([]string, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+
+	var names []string
+	for _, namespace := range namespaces.Items {
+		names = append(names, namespace.Name)
+	}
+	return filterCompletions(names, toComplete), nil
+}
+
+// CompletePVCs returns the PVCs in the namespace starting with toComplete, for shell completion.
+func CompletePVCs(ctx context.Context, clientset kubernetes.Interface, namespace, toComplete string) ([]string, error) {
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVCs: %v", err)
+	}
+
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	return filterCompletions(names, toComplete), nil
+}
+
+func filterCompletions(names []string, toComplete string) []string {
+	var completions []string
+	for _, name := range names {
+		if strings.HasPrefix(name, toComplete) {
+			completions = append(completions, name)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCompleteNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-public"}},
+	)
+
+	tests := []struct {
+		toComplete string
+		expected   []string
+	}{
+		{"", []string{"default", "kube-public", "kube-system"}},
+		{"kube-", []string{"kube-public", "kube-system"}},
+		{"missing", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := CompleteNamespaces(context.Background(), clientset, tt.toComplete)
+		if err != nil {
+			t.Fatalf("CompleteNamespaces(%q) returned an error: %v", tt.toComplete, err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("CompleteNamespaces(%q) = %v; want %v", tt.toComplete, got, tt.expected)
+		}
+	}
+}
+
+func TestCompletePVCs(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-postgres-0", Namespace: "db"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-postgres-1", Namespace: "db"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "backups", Namespace: "db"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-other", Namespace: "default"}},
+	)
+
+	got, err := CompletePVCs(context.Background(), clientset, "db", "data-")
+	if err != nil {
+		t.Fatalf("CompletePVCs() returned an error: %v", err)
+	}
+	expected := []string{"data-postgres-0", "data-postgres-1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("CompletePVCs() = %v; want %v", got, expected)
+	}
+}
+
+func TestCompletionListError(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	if _, err := CompleteNamespaces(context.Background(), clientset, ""); err == nil {
+		t.Error("CompleteNamespaces() should have returned an error")
+	}
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ConfigFileName is the config file read from the home directory unless another one is given.
+	ConfigFileName = ".pv-mounter.yaml"
+	// ConfigEnvPrefix prefixes the environment variables setting options, e.g. PV_MOUNTER_CPU_LIMIT.
+	ConfigEnvPrefix = "PV_MOUNTER"
+)
+
+// Config holds the values of options set in the environment or the config file, whose keys
+// are the names of the flags. Environment variables take precedence over the config file.
+type Config struct {
+	v *viper.Viper
+}
+
+// LoadConfig reads the config file at path, ~/.pv-mounter.yaml if empty. Only a config file
+// given explicitly has to exist.
+func LoadConfig(path string) (*Config, error) {
+	v := viper.New()
+	v.SetEnvPrefix(ConfigEnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &Config{v: v}, nil
+		}
+		path = filepath.Join(home, ConfigFileName)
+	}
+	// The name doesn't tell viper the format of ~/.pv-mounter.yaml-like files without extension
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return &Config{v: v}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	return &Config{v: v}, nil
+}
+
+// Lookup returns the values of the option name, several for lists in the config file.
+func (c *Config) Lookup(name string) ([]string, bool) {
+	if !c.v.IsSet(name) {
+		return nil, false
+	}
+	if _, ok := c.v.Get(name).([]interface{}); ok {
+		return c.v.GetStringSlice(name), true
+	}
+	return []string{c.v.GetString(name)}, true
+}
+
+// Apply sets the flags not given on the command line to their values from the config and
+// returns the names of the flags it set. Those aren't marked as changed: values from the config
+// are only defaults, which positional args still override.
+func (c *Config) Apply(flags *pflag.FlagSet) (map[string]bool, error) {
+	applied := map[string]bool{}
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "config" || flag.Name == "help" {
+			return
+		}
+		values, ok := c.Lookup(flag.Name)
+		if !ok {
+			return
+		}
+		if slice, isSlice := flag.Value.(pflag.SliceValue); isSlice {
+			err = slice.Replace(values)
+		} else {
+			err = flag.Value.Set(strings.Join(values, ","))
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid value %q for --%s from the environment or config file: %v", strings.Join(values, ","), flag.Name, err)
+			return
+		}
+		applied[flag.Name] = true
+	})
+	return applied, err
+}
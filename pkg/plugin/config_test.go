@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, ConfigFileName)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "cpu-limit: 500m\nread-only: true\nwait-ready-timeout: 1m\nenv:\n  - TZ=UTC\n  - LANG=C\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an error: %v", err)
+	}
+
+	for name, expected := range map[string][]string{
+		"cpu-limit":          {"500m"},
+		"read-only":          {"true"},
+		"wait-ready-timeout": {"1m"},
+		"env":                {"TZ=UTC", "LANG=C"},
+	} {
+		values, ok := config.Lookup(name)
+		if !ok || !reflect.DeepEqual(values, expected) {
+			t.Errorf("Expected %s to be %v, got %v (%v)", name, expected, values, ok)
+		}
+	}
+	if values, ok := config.Lookup("memory-limit"); ok {
+		t.Errorf("Expected memory-limit to be unset, got %v", values)
+	}
+}
+
+func TestLoadConfigEnvironment(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "cpu-limit: 500m\n")
+	t.Setenv("PV_MOUNTER_CPU_LIMIT", "1")
+	t.Setenv("PV_MOUNTER_MEMORY_LIMIT", "256Mi")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an error: %v", err)
+	}
+	if values, _ := config.Lookup("cpu-limit"); !reflect.DeepEqual(values, []string{"1"}) {
+		t.Errorf("Expected the environment to take precedence over the config file, got %v", values)
+	}
+	if values, _ := config.Lookup("memory-limit"); !reflect.DeepEqual(values, []string{"256Mi"}) {
+		t.Errorf("Expected memory-limit from the environment, got %v", values)
+	}
+}
+
+func TestLoadConfigDefaultFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Expected a missing default config file to be ignored, got %v", err)
+	}
+	if _, ok := config.Lookup("cpu-limit"); ok {
+		t.Error("Expected nothing to be set without a config file")
+	}
+
+	writeConfigFile(t, home, "cpu-limit: 250m\n")
+	config, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an error: %v", err)
+	}
+	if values, _ := config.Lookup("cpu-limit"); !reflect.DeepEqual(values, []string{"250m"}) {
+		t.Errorf("Expected cpu-limit from ~/%s, got %v", ConfigFileName, values)
+	}
+
+	if _, err := LoadConfig(filepath.Join(home, "missing.yaml")); err == nil {
+		t.Error("Expected a missing explicit config file to fail")
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	config, err := LoadConfig(writeConfigFile(t, t.TempDir(), "namespace: from-config\ncpu-limit: 500m\nenv:\n  - TZ=UTC\nconcurrency: many\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an error: %v", err)
+	}
+
+	newFlags := func() *pflag.FlagSet {
+		flags := pflag.NewFlagSet("mount", pflag.ContinueOnError)
+		flags.String("namespace", "", "")
+		flags.String("cpu-limit", "", "")
+		flags.StringArray("env", nil, "")
+		flags.String("memory-limit", "", "")
+		return flags
+	}
+
+	flags := newFlags()
+	if err := flags.Parse([]string{"--cpu-limit", "1"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	applied, err := config.Apply(flags)
+	if err != nil {
+		t.Fatalf("Apply() returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(applied, map[string]bool{"namespace": true, "env": true}) {
+		t.Errorf("Expected namespace and env to be applied, got %v", applied)
+	}
+	if value, _ := flags.GetString("cpu-limit"); value != "1" {
+		t.Errorf("Expected the command line to win over the config file, got %s", value)
+	}
+	if env, _ := flags.GetStringArray("env"); !reflect.DeepEqual(env, []string{"TZ=UTC"}) {
+		t.Errorf("Expected env from the config file, got %v", env)
+	}
+	// Values from the config are only defaults, a positional namespace still overrides them
+	if flags.Changed("namespace") {
+		t.Error("Expected the namespace from the config file not to count as given on the command line")
+	}
+	namespace, _ := flags.GetString("namespace")
+	resolved, err := ResolveMountArgs([]string{"ns", "data", "/mnt/data"}, "", namespace, nil, "", false)
+	if err != nil || resolved.Namespace != "ns" {
+		t.Errorf("Expected the positional namespace to override the config file, got %+v (%v)", resolved, err)
+	}
+
+	flags = newFlags()
+	flags.Int("concurrency", 1, "")
+	if _, err := config.Apply(flags); err == nil {
+		t.Error("Expected an invalid value from the config file to be rejected")
+	}
+}
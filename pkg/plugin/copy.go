@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// CopySpec is a one-shot transfer between a local path and a path in a PVC.
+type CopySpec struct {
+	PVCName string
+	// RemotePath is relative to the root of the volume.
+	RemotePath string
+	LocalPath  string
+	// Upload copies LocalPath into the PVC instead of RemotePath out of it.
+	Upload bool
+	// Recursive copies directories with their contents.
+	Recursive bool
+	// Rsync transfers with rsync instead of scp, which requires rsync in the image as well.
+	Rsync bool
+}
+
+// ParseCopySpec parses the source and destination of cp, exactly one of which is a
+// <pvc-name>:<path> in the PVC. Local paths containing a colon must start with . or /.
+func ParseCopySpec(src, dst string) (CopySpec, error) {
+	srcPVC, srcPath, srcRemote := parseCopyPath(src)
+	dstPVC, dstPath, dstRemote := parseCopyPath(dst)
+	switch {
+	case srcRemote && dstRemote:
+		return CopySpec{}, fmt.Errorf("can't copy from %s to %s, one of them must be a local path", src, dst)
+	case srcRemote:
+		return CopySpec{PVCName: srcPVC, RemotePath: srcPath, LocalPath: dst}, nil
+	case dstRemote:
+		return CopySpec{PVCName: dstPVC, RemotePath: dstPath, LocalPath: src, Upload: true}, nil
+	default:
+		return CopySpec{}, fmt.Errorf("can't copy from %s to %s, one of them must be <pvc-name>:<path>", src, dst)
+	}
+}
+
+func parseCopyPath(arg string) (string, string, bool) {
+	if strings.HasPrefix(arg, ".") || strings.HasPrefix(arg, "/") {
+		return "", "", false
+	}
+	// PVC names can't contain colons, so everything after the first one is the path
+	pvcName, remotePath, found := strings.Cut(arg, ":")
+	if !found || pvcName == "" {
+		return "", "", false
+	}
+	return pvcName, remotePath, true
+}
+
+// Copy transfers files between a local path and a PVC without mounting it. The pod and
+// port-forward are removed once the transfer is done.
+func Copy(ctx context.Context, namespace string, spec CopySpec, opts MountOptions) error {
+	command, errNotFound := "scp", ErrSCPNotFound
+	if spec.Rsync {
+		command, errNotFound = "rsync", ErrRsyncNotFound
+	}
+	clientset, err := prepareOneShot(command, errNotFound, opts)
+	if err != nil {
+		return err
+	}
+
+	return copyFiles(ctx, clientset, namespace, spec, opts)
+}
+
+func copyFiles(ctx context.Context, clientset kubernetes.Interface, namespace string, spec CopySpec, opts MountOptions) error {
+	remotePath, err := volumePath(spec.RemotePath, opts)
+	if err != nil {
+		return err
+	}
+
+	return runOneShot(ctx, clientset, namespace, spec.PVCName, opts, func(port int, keyFile string, opts MountOptions) error {
+		copyCmd := buildCopyCommand(keyFile, remotePath, port, spec, opts)
+		copyCmd.Stdout = os.Stdout
+		copyCmd.Stderr = os.Stderr
+
+		if err := runner.Run(copyCmd); err != nil {
+			return fmt.Errorf("failed to copy with %s: %v", copyCmd.Args[0], err)
+		}
+		if spec.Upload {
+			fmt.Printf("Copied %s to PVC %s\n", spec.LocalPath, spec.PVCName)
+		} else {
+			fmt.Printf("Copied %s from PVC %s\n", spec.LocalPath, spec.PVCName)
+		}
+		return nil
+	})
+}
+
+// volumePath returns where a path of the volume is in the pod, making sure it doesn't
+// point outside of the volume.
+func volumePath(remotePath string, opts MountOptions) (string, error) {
+	root := opts.remoteMountPath()
+	joined := path.Join(root, remotePath)
+	if joined != root && !strings.HasPrefix(joined, strings.TrimSuffix(root, "/")+"/") {
+		return "", fmt.Errorf("invalid path %s, must be inside of the volume", remotePath)
+	}
+	return joined, nil
+}
+
+// quoteRsyncArg quotes an argument of the remote shell of rsync, which splits -e at spaces.
+// Paths like the key file may contain spaces, e.g. under $TMPDIR. rsync keeps quoted spaces
+// and reads a doubled quote inside quotes as a quote.
+func quoteRsyncArg(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+}
+
+func buildCopyCommand(keyFile, remotePath string, port int, spec CopySpec, opts MountOptions) *exec.Cmd {
+	remote := opts.remoteTarget(remotePath)
+	src, dst := remote, spec.LocalPath
+	if spec.Upload {
+		src, dst = spec.LocalPath, remote
+	}
+
+	if spec.Rsync {
+		ssh := buildSSHCommand(keyFile, port, opts)
+		// rsync appends the user and host itself
+		var sshArgs []string
+		for _, arg := range ssh.Args[:len(ssh.Args)-1] {
+			sshArgs = append(sshArgs, quoteRsyncArg(arg))
+		}
+		args := []string{"-e", strings.Join(sshArgs, " ")}
+		if spec.Recursive {
+			args = append(args, "-r")
+		}
+		if opts.Compression {
+			args = append(args, "-z")
+		}
+		return exec.Command("rsync", append(args, src, dst)...)
+	}
+
+	args := []string{
+		"-i", keyFile,
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-P", fmt.Sprintf("%d", port),
+	}
+	if spec.Recursive {
+		args = append(args, "-r")
+	}
+	if opts.Compression {
+		args = append(args, "-C")
+	}
+	return exec.Command("scp", append(args, src, dst)...)
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseCopySpec(t *testing.T) {
+	tests := []struct {
+		src, dst  string
+		expected  CopySpec
+		expectErr bool
+	}{
+		{"data:backups/db.tar.gz", "db.tar.gz", CopySpec{PVCName: "data", RemotePath: "backups/db.tar.gz", LocalPath: "db.tar.gz"}, false},
+		{"./config", "data:config", CopySpec{PVCName: "data", RemotePath: "config", LocalPath: "./config", Upload: true}, false},
+		{"data:", "/tmp/a:b", CopySpec{PVCName: "data", LocalPath: "/tmp/a:b"}, false},
+		{"data:a", "other:b", CopySpec{}, true},
+		{"a", "b", CopySpec{}, true},
+		{":a", "b", CopySpec{}, true},
+	}
+
+	for _, tt := range tests {
+		spec, err := ParseCopySpec(tt.src, tt.dst)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("ParseCopySpec(%q, %q) error = %v, expectErr %v", tt.src, tt.dst, err, tt.expectErr)
+			continue
+		}
+		if spec != tt.expected {
+			t.Errorf("ParseCopySpec(%q, %q) = %+v; want %+v", tt.src, tt.dst, spec, tt.expected)
+		}
+	}
+}
+
+func TestVolumePath(t *testing.T) {
+	tests := []struct {
+		remotePath string
+		opts       MountOptions
+		expected   string
+		expectErr  bool
+	}{
+		{"", MountOptions{}, "/volume", false},
+		{"backups/db.tar.gz", MountOptions{}, "/volume/backups/db.tar.gz", false},
+		{"/backups", MountOptions{}, "/volume/backups", false},
+		{"config", MountOptions{RemoteMountPath: "/data"}, "/data/config", false},
+		{"../etc/passwd", MountOptions{}, "", true},
+		{"../volume2", MountOptions{}, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := volumePath(tt.remotePath, tt.opts)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("volumePath(%q) error = %v, expectErr %v", tt.remotePath, err, tt.expectErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("volumePath(%q) = %q; want %q", tt.remotePath, got, tt.expected)
+		}
+	}
+}
+
+func TestBuildCopyCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     CopySpec
+		opts     MountOptions
+		expected []string
+	}{
+		{
+			name: "scp download",
+			spec: CopySpec{LocalPath: "./db.tar.gz"},
+			expected: []string{"scp", "-i", "/tmp/key.pem", "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "-P", "12345",
+				"ve@localhost:/volume/db.tar.gz", "./db.tar.gz"},
+		},
+		{
+			name: "scp recursive upload as root",
+			spec: CopySpec{LocalPath: "./config", Upload: true, Recursive: true},
+			opts: MountOptions{NeedsRoot: true, Compression: true},
+			expected: []string{"scp", "-i", "/tmp/key.pem", "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "-P", "12345", "-r", "-C",
+				"./config", "root@localhost:/volume/db.tar.gz"},
+		},
+		{
+			name: "rsync download",
+			spec: CopySpec{LocalPath: "./logs", Recursive: true, Rsync: true},
+			expected: []string{"rsync", "-e", "'ssh' '-i' '/tmp/key.pem' '-o' 'StrictHostKeyChecking=no' '-o' 'UserKnownHostsFile=/dev/null' '-p' '12345'", "-r",
+				"ve@localhost:/volume/db.tar.gz", "./logs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := buildCopyCommand("/tmp/key.pem", "/volume/db.tar.gz", 12345, tt.spec, tt.opts)
+			if !slices.Equal(cmd.Args, tt.expected) {
+				t.Errorf("buildCopyCommand() = %v; want %v", cmd.Args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildCopyCommandRsyncQuotesKeyFile(t *testing.T) {
+	cmd := buildCopyCommand("/tmp/my dir/it's.pem", "/volume/db.tar.gz", 12345, CopySpec{LocalPath: "./db.tar.gz", Rsync: true}, MountOptions{})
+	if !strings.Contains(cmd.Args[2], "'-i' '/tmp/my dir/it''s.pem' ") {
+		t.Errorf("Expected the key file quoted in the remote shell of rsync, got %q", cmd.Args[2])
+	}
+}
+
+func TestCopyFilesRemovesPod(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+
+	var err error
+	captureStdout(t, func() {
+		err = copyFiles(context.Background(), clientset, namespace, CopySpec{PVCName: pvcName, RemotePath: "db.tar.gz", LocalPath: "./db.tar.gz"}, MountOptions{})
+	})
+	if err != nil {
+		t.Fatalf("copyFiles() returned an error: %v", err)
+	}
+
+	if len(r.run) != 1 || r.run[0][0] != "scp" || !strings.HasSuffix(strings.Join(r.run[0], " "), "ve@localhost:/volume/db.tar.gz ./db.tar.gz") {
+		t.Errorf("Expected scp to copy out of the volume, got %v", r.run)
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("Expected the exposer pod to be deleted after the copy, got %d pods", len(pods.Items))
+	}
+}
+
+func TestCopyFilesInvalidPath(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	err := copyFiles(context.Background(), clientset, "default", CopySpec{PVCName: "test-pvc", RemotePath: "../etc"}, MountOptions{})
+	if err == nil {
+		t.Fatal("copyFiles() should have rejected a path outside of the volume")
+	}
+	if len(clientset.Actions()) != 0 {
+		t.Errorf("Expected nothing to be created for an invalid path, got %v", clientset.Actions())
+	}
+}
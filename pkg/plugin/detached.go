@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storedKeyPath returns where the private key of a mount made with NoWait is kept, in the
+// state directory, so attach finds it later.
+func storedKeyPath(namespace, podName string) (string, error) {
+	store, err := defaultStateStore()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store.dir, "keys", namespace+"_"+podName), nil
+}
+
+// keepKeyFor writes the private key to KeepKey, if set. Mounts made with NoWait need the key
+// to be mounted later, so it's kept in the state directory if KeepKey isn't set.
+func keepKeyFor(namespace, podName, privateKey string, port int, opts *MountOptions) error {
+	if opts.NoWait && opts.KeepKey == "" {
+		path, err := storedKeyPath(namespace, podName)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return fmt.Errorf("failed to create key directory: %v", err)
+		}
+		opts.KeepKey = path
+	}
+	if opts.KeepKey == "" {
+		return nil
+	}
+	return keepPrivateKey(privateKey, port, *opts)
+}
+
+// mountLater stands in for SSHFS with NoWait. It only prints how to mount the PVC, with attach
+// or with SSHFS and the key keepKeyFor wrote.
+func mountLater(port int, localMountPoint, pvcName, _ string, opts MountOptions) (*backgroundCommand, error) {
+	fmt.Printf("PVC %s is reachable on %s:%d, not mounting it. Mount it with kubectl pv-mounter attach, or with:\n", pvcName, opts.host(), port)
+	fmt.Println(strings.Join(buildSSHFSCommand(opts.KeepKey, localMountPoint, port, opts).Args, " "))
+	return nil, nil
+}
+
+// removeStoredKey removes the key kept for a mount made with NoWait, if there is one.
+func removeStoredKey(namespace, podName string) {
+	path, err := storedKeyPath(namespace, podName)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to remove the private key of pod %s: %v\n", podName, err)
+	}
+}
+
+// mountKeyPath returns where the private key of a daemonized SSHFS is kept while it's mounted,
+// by the hash of its mount point.
+func mountKeyPath(hash string) (string, error) {
+	store, err := defaultStateStore()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store.dir, "keys", "mount_"+hash), nil
+}
+
+// writeMountKey writes the private key for SSHFS mounting at the mount point and reports whether
+// it has to be kept once SSHFS daemonized. SSHFS reads the key again whenever it reconnects, so
+// it's kept in the state directory until clean removes it. Without a state directory, the key
+// is temporary and SSHFS doesn't reconnect.
+func writeMountKey(localMountPoint, privateKey string, opts *MountOptions) (string, bool, error) {
+	if opts.keepAliveInterval() > 0 {
+		path, err := mountKeyPath(mountPointHash(localMountPoint))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0o700)
+		}
+		if err == nil {
+			err = os.WriteFile(path, []byte(privateKey), 0o600)
+		}
+		if err == nil {
+			return path, true, nil
+		}
+		fmt.Printf("Warning: failed to keep the private key for reconnects, the mount won't reconnect: %v\n", err)
+		opts.KeepAliveInterval = -1
+	}
+	path, err := writePrivateKey(privateKey)
+	return path, false, err
+}
+
+// removeMountKey removes the key kept for reconnects of the mount at the mount point of the
+// hash, if there is one.
+func removeMountKey(hash string) {
+	if hash == "" {
+		return
+	}
+	path, err := mountKeyPath(hash)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to remove the private key of the mount: %v\n", err)
+	}
+}
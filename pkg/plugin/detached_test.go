@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMountNoWait(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{NoWait: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	if len(r.run) != 0 {
+		t.Errorf("Expected nothing to be mounted, ran %v", r.run)
+	}
+	if len(r.started) != 1 || r.started[0][1] != "port-forward" {
+		t.Errorf("Expected the port-forward to be started, got %v", r.started)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(pods.Items) != 1 {
+		t.Fatalf("Expected the exposer pod to be kept, got %v (%v)", pods, err)
+	}
+	pod := &pods.Items[0]
+	keyFile, err := storedKeyPath(namespace, pod.Name)
+	if err != nil {
+		t.Fatalf("storedKeyPath() returned an error: %v", err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("Expected the private key to be kept: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the private key to be private, got %v", info.Mode().Perm())
+	}
+	if !strings.Contains(out, "sshfs") || !strings.Contains(out, "IdentityFile="+keyFile) || !strings.Contains(out, "/mnt/data") {
+		t.Errorf("Expected the SSHFS command with the kept key, got:\n%s", out)
+	}
+
+	// Cleaning the mount removes the key again
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), clientset, pod, CleanOptions{}, &CleanResult{})
+	})
+	if err != nil {
+		t.Fatalf("cleanPod() returned an error: %v", err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the private key to be removed by clean, got %v", err)
+	}
+}
+
+func TestMountNoWaitKeepKey(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	keyFile := t.TempDir() + "/key"
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{NoWait: true, KeepKey: keyFile})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+	if len(r.run) != 0 {
+		t.Errorf("Expected nothing to be mounted, ran %v", r.run)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Errorf("Expected the private key in the given file: %v", err)
+	}
+	if !strings.Contains(out, "IdentityFile="+keyFile) {
+		t.Errorf("Expected the SSHFS command to use the given key, got:\n%s", out)
+	}
+}
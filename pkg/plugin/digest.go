@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// validateImageDigest checks the digest the image is pinned to.
+func validateImageDigest(opts MountOptions) error {
+	if opts.ImageDigest != "" && !imageDigestPattern.MatchString(opts.ImageDigest) {
+		return fmt.Errorf("invalid image digest %q, expected sha256:<64 hex digits>", opts.ImageDigest)
+	}
+	if opts.Strict && opts.ImageDigest == "" {
+		return fmt.Errorf("--strict requires the expected digest to be given with --image-digest")
+	}
+	return nil
+}
+
+// pinImageDigest replaces the tag of the image by the digest. A registry port isn't a tag.
+func pinImageDigest(image, digest string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + "@" + digest
+}
+
+// imageIDMatches reports whether the image ID of a container status is the image with the
+// digest. Runtimes report repo@digest, some with a docker-pullable:// prefix, or the digest.
+func imageIDMatches(imageID, digest string) bool {
+	return imageID == digest || strings.HasSuffix(imageID, "@"+digest)
+}
+
+// checkContainerDigest checks that the container runs the image with the digest. An empty
+// image ID means the container didn't start yet.
+func checkContainerDigest(podName, container, imageID, digest string) error {
+	if !imageIDMatches(imageID, digest) {
+		return fmt.Errorf("%w: container %s of pod %s runs image %s, expected digest %s", ErrImageDigestMismatch, container, podName, imageID, digest)
+	}
+	return nil
+}
+
+// verifyPodImageDigest checks that the ready pod runs the image with the expected digest.
+func verifyPodImageDigest(ctx context.Context, clientset kubernetes.Interface, namespace, podName, digest string) error {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s: %v", podName, err)
+	}
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return fmt.Errorf("%w: pod %s reports no containers", ErrImageDigestMismatch, podName)
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if err := checkContainerDigest(podName, status.Name, status.ImageID, digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForEphemeralImageDigest waits for the ephemeral container to start and checks that it
+// runs the image with the expected digest.
+func waitForEphemeralImageDigest(ctx context.Context, clientset kubernetes.Interface, namespace, podName, container, digest string, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var imageID string
+	err := podReadyBackoff.DelayFunc().Until(waitCtx, true, false, func(ctx context.Context) (bool, error) {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		imageID = ephemeralContainerImageID(pod, container)
+		return imageID != "", nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("ephemeral container %s of pod %s didn't start in time (%s) to check its image digest", container, podName, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	return checkContainerDigest(podName, container, imageID, digest)
+}
+
+func ephemeralContainerImageID(pod *corev1.Pod, container string) string {
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name == container {
+			return status.ImageID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPinImageDigest(t *testing.T) {
+	for image, expected := range map[string]string{
+		"bfenski/volume-exposer:v0.1.0":           "bfenski/volume-exposer@" + testDigest,
+		"bfenski/volume-exposer":                  "bfenski/volume-exposer@" + testDigest,
+		"registry.local:5000/volume-exposer:v1.0": "registry.local:5000/volume-exposer@" + testDigest,
+		"registry.local:5000/volume-exposer":      "registry.local:5000/volume-exposer@" + testDigest,
+	} {
+		if pinned := pinImageDigest(image, testDigest); pinned != expected {
+			t.Errorf("Expected %s to be pinned as %s, got %s", image, expected, pinned)
+		}
+	}
+}
+
+func TestCreatePodSpecImageDigest(t *testing.T) {
+	for _, role := range []string{"standalone", "proxy"} {
+		podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", MountOptions{ImageDigest: testDigest})
+		image := podSpec.Spec.Containers[0].Image
+		if !strings.HasSuffix(image, "@"+testDigest) || strings.Contains(strings.TrimSuffix(image, "@"+testDigest), ":") {
+			t.Errorf("Expected the image of the %s pod to be pinned by digest only, got %s", role, image)
+		}
+	}
+
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
+	if strings.Contains(podSpec.Spec.Containers[0].Image, "@") {
+		t.Errorf("Expected the image to be referenced by tag by default, got %s", podSpec.Spec.Containers[0].Image)
+	}
+}
+
+func TestValidateImageDigest(t *testing.T) {
+	for _, opts := range []MountOptions{
+		{ImageDigest: "sha256:abc"},
+		{ImageDigest: "v0.1.0"},
+		{Strict: true},
+	} {
+		if err := validateMountOptions(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+	if err := validateMountOptions(MountOptions{ImageDigest: testDigest, Strict: true}); err != nil {
+		t.Errorf("Expected a valid digest to be accepted, got %v", err)
+	}
+}
+
+func newPodWithImageID(imageID string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "volume-exposer-abcde", Namespace: "default"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "volume-exposer", ImageID: imageID},
+		}},
+	}
+}
+
+func TestVerifyPodImageDigest(t *testing.T) {
+	for _, imageID := range []string{
+		"docker.io/bfenski/volume-exposer@" + testDigest,
+		"docker-pullable://bfenski/volume-exposer@" + testDigest,
+		testDigest,
+	} {
+		clientset := fake.NewSimpleClientset(newPodWithImageID(imageID))
+		if err := verifyPodImageDigest(context.Background(), clientset, "default", "volume-exposer-abcde", testDigest); err != nil {
+			t.Errorf("Expected image ID %s to match, got %v", imageID, err)
+		}
+	}
+
+	otherDigest := "sha256:" + strings.Repeat("f", 64)
+	for _, imageID := range []string{"docker.io/bfenski/volume-exposer@" + otherDigest, ""} {
+		clientset := fake.NewSimpleClientset(newPodWithImageID(imageID))
+		err := verifyPodImageDigest(context.Background(), clientset, "default", "volume-exposer-abcde", testDigest)
+		if !errors.Is(err, ErrImageDigestMismatch) {
+			t.Errorf("Expected image ID %q to be a mismatch, got %v", imageID, err)
+		}
+	}
+}
+
+func TestWaitForEphemeralImageDigest(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "default"},
+		Status: corev1.PodStatus{EphemeralContainerStatuses: []corev1.ContainerStatus{
+			{Name: "volume-exposer-ephemeral-abcde", ImageID: "docker.io/bfenski/volume-exposer@" + testDigest},
+		}},
+	}
+	clientset := fake.NewSimpleClientset(pod)
+
+	if err := waitForEphemeralImageDigest(context.Background(), clientset, "default", "workload", "volume-exposer-ephemeral-abcde", testDigest, time.Second); err != nil {
+		t.Errorf("Expected the ephemeral container to match, got %v", err)
+	}
+	otherDigest := "sha256:" + strings.Repeat("f", 64)
+	err := waitForEphemeralImageDigest(context.Background(), clientset, "default", "workload", "volume-exposer-ephemeral-abcde", otherDigest, time.Second)
+	if !errors.Is(err, ErrImageDigestMismatch) {
+		t.Errorf("Expected a mismatch, got %v", err)
+	}
+	// The container never starts
+	err = waitForEphemeralImageDigest(context.Background(), clientset, "default", "workload", "volume-exposer-ephemeral-other", testDigest, 100*time.Millisecond)
+	if err == nil || errors.Is(err, ErrImageDigestMismatch) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
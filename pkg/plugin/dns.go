@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxDNSServers is how many nameservers the dnsConfig of a pod may list.
+const maxDNSServers = 3
+
+// ParseHostAlias parses a host alias given as ip=host1,host2.
+func ParseHostAlias(s string) (corev1.HostAlias, error) {
+	ip, hosts, found := strings.Cut(s, "=")
+	if !found || ip == "" || hosts == "" {
+		return corev1.HostAlias{}, fmt.Errorf("invalid host alias %q, expected ip=host1,host2", s)
+	}
+	return corev1.HostAlias{IP: ip, Hostnames: strings.Split(hosts, ",")}, nil
+}
+
+// validateDNS checks the host aliases and the DNS settings of the pod.
+func validateDNS(opts MountOptions) error {
+	for _, alias := range opts.HostAliases {
+		if net.ParseIP(alias.IP) == nil {
+			return fmt.Errorf("invalid host alias IP %s", alias.IP)
+		}
+		for _, host := range alias.Hostnames {
+			if errs := validation.IsDNS1123Subdomain(host); len(errs) != 0 {
+				return fmt.Errorf("invalid host alias hostname %q: %s", host, strings.Join(errs, ", "))
+			}
+		}
+	}
+	if len(opts.DNSServers) > maxDNSServers {
+		return fmt.Errorf("at most %d DNS servers can be given, got %d", maxDNSServers, len(opts.DNSServers))
+	}
+	for _, server := range opts.DNSServers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server %s, must be an IP address", server)
+		}
+	}
+	for _, search := range opts.DNSSearches {
+		if errs := validation.IsDNS1123Subdomain(search); len(errs) != 0 {
+			return fmt.Errorf("invalid DNS search domain %q: %s", search, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// buildDNSConfig returns the DNS settings the pod gets in addition to those of the cluster,
+// nil if there are none.
+func buildDNSConfig(opts MountOptions) *corev1.PodDNSConfig {
+	if len(opts.DNSServers) == 0 && len(opts.DNSSearches) == 0 {
+		return nil
+	}
+	return &corev1.PodDNSConfig{
+		Nameservers: opts.DNSServers,
+		Searches:    opts.DNSSearches,
+	}
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseHostAlias(t *testing.T) {
+	alias, err := ParseHostAlias("10.0.0.5=nfs.internal,nfs")
+	if err != nil {
+		t.Fatalf("ParseHostAlias() returned an error: %v", err)
+	}
+	expected := corev1.HostAlias{IP: "10.0.0.5", Hostnames: []string{"nfs.internal", "nfs"}}
+	if !reflect.DeepEqual(alias, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, alias)
+	}
+
+	for _, value := range []string{"10.0.0.5", "=nfs", "10.0.0.5=", ""} {
+		if _, err := ParseHostAlias(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestValidateDNS(t *testing.T) {
+	for _, opts := range []MountOptions{
+		{HostAliases: []corev1.HostAlias{{IP: "nfs", Hostnames: []string{"nfs"}}}},
+		{HostAliases: []corev1.HostAlias{{IP: "10.0.0.5", Hostnames: []string{"NFS_server"}}}},
+		{DNSServers: []string{"dns.internal"}},
+		{DNSServers: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+		{DNSSearches: []string{"-internal"}},
+	} {
+		if err := validateMountOptions(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+
+	opts := MountOptions{
+		HostAliases: []corev1.HostAlias{{IP: "fd00::5", Hostnames: []string{"nfs.internal"}}},
+		DNSServers:  []string{"10.0.0.53"},
+		DNSSearches: []string{"corp.example.com"},
+	}
+	if err := validateMountOptions(opts); err != nil {
+		t.Errorf("Expected valid DNS settings to be accepted, got %v", err)
+	}
+}
+
+func TestCreatePodSpecDNS(t *testing.T) {
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
+	if podSpec.Spec.HostAliases != nil || podSpec.Spec.DNSConfig != nil {
+		t.Errorf("Expected no DNS settings by default, got %+v and %+v", podSpec.Spec.HostAliases, podSpec.Spec.DNSConfig)
+	}
+
+	aliases := []corev1.HostAlias{{IP: "10.0.0.5", Hostnames: []string{"nfs.internal"}}}
+	opts := MountOptions{HostAliases: aliases, DNSServers: []string{"10.0.0.53"}, DNSSearches: []string{"corp.example.com"}}
+	for _, role := range []string{"standalone", "proxy"} {
+		podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", opts)
+		if !reflect.DeepEqual(podSpec.Spec.HostAliases, aliases) {
+			t.Errorf("Expected the host aliases on the %s pod, got %+v", role, podSpec.Spec.HostAliases)
+		}
+		expected := &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.53"}, Searches: []string{"corp.example.com"}}
+		if !reflect.DeepEqual(podSpec.Spec.DNSConfig, expected) {
+			t.Errorf("Expected the DNS config %+v on the %s pod, got %+v", expected, role, podSpec.Spec.DNSConfig)
+		}
+		if podSpec.Spec.DNSPolicy != "" && podSpec.Spec.DNSPolicy != corev1.DNSClusterFirst {
+			t.Errorf("Expected the cluster DNS to be kept, got policy %s", podSpec.Spec.DNSPolicy)
+		}
+	}
+}
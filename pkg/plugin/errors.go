@@ -0,0 +1,27 @@
+package plugin
+
+import "errors"
+
+// Errors returned by Mount and Clean, so that programmatic callers can tell
+// failures apart with errors.Is instead of matching error messages.
+var (
+	ErrUnsupportedOS       = errors.New("unsupported operating system")
+	ErrSSHFSNotFound       = errors.New("sshfs not found in PATH")
+	ErrSFTPNotFound        = errors.New("sftp not found in PATH")
+	ErrSCPNotFound         = errors.New("scp not found in PATH")
+	ErrRsyncNotFound       = errors.New("rsync not found in PATH")
+	ErrMountPointMissing   = errors.New("local mount point does not exist")
+	ErrMountPointNotEmpty  = errors.New("local mount point is not empty")
+	ErrPVCNotFound         = errors.New("PVC not found")
+	ErrPVCNotBound         = errors.New("PVC is not bound")
+	ErrAccessModeNotUsable = errors.New("access mode can't be used for this mount")
+	ErrPodNotFound         = errors.New("pod not found")
+	ErrPodSecurity         = errors.New("rejected by Pod Security admission")
+	ErrImagePull           = errors.New("image can't be pulled")
+	ErrImageDigestMismatch = errors.New("image digest doesn't match")
+	// ErrImagePullSecretNotFound is returned for image pull secrets missing from the namespace.
+	ErrImagePullSecretNotFound = errors.New("image pull secret not found")
+	// ErrEphemeralContainersUnsupported is returned for volumes in use on clusters
+	// without ephemeral containers, which mounting those requires.
+	ErrEphemeralContainersUnsupported = errors.New("ephemeral containers are not supported by the cluster")
+)
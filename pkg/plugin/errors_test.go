@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Mount point missing", func(t *testing.T) {
+		err := validateMountPoint("/path/that/does/not/exist")
+		if !errors.Is(err, ErrMountPointMissing) {
+			t.Errorf("Expected ErrMountPointMissing, got: %v", err)
+		}
+	})
+
+	t.Run("PVC not found", func(t *testing.T) {
+		_, err := checkPVCUsage(ctx, fake.NewSimpleClientset(), "default", "missing", 0)
+		if !errors.Is(err, ErrPVCNotFound) {
+			t.Errorf("Expected ErrPVCNotFound, got: %v", err)
+		}
+		var statusErr *apierrors.StatusError
+		if !errors.As(err, &statusErr) || !apierrors.IsNotFound(statusErr) {
+			t.Errorf("Expected the API error to be wrapped, got: %v", err)
+		}
+	})
+
+	t.Run("PVC not bound", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+		})
+		_, err := checkPVCUsage(ctx, clientset, "default", "pending", 0)
+		if !errors.Is(err, ErrPVCNotBound) {
+			t.Errorf("Expected ErrPVCNotBound, got: %v", err)
+		}
+		if errors.Is(err, ErrPVCNotFound) {
+			t.Errorf("Didn't expect ErrPVCNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Access mode not usable", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newTestObjects("default", "test-pvc", corev1.ReadOnlyMany)...)
+		var err error
+		captureStdout(t, func() {
+			err = mount(ctx, clientset, "default", "test-pvc", "/mnt/data", MountOptions{DryRun: true})
+		})
+		if !errors.Is(err, ErrAccessModeNotUsable) {
+			t.Errorf("Expected ErrAccessModeNotUsable, got: %v", err)
+		}
+	})
+
+	t.Run("Pod not found", func(t *testing.T) {
+		_, err := findPodByMountPoint(ctx, fake.NewSimpleClientset(), "/mnt/data")
+		if !errors.Is(err, ErrPodNotFound) {
+			t.Errorf("Expected ErrPodNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Unsupported OS", func(t *testing.T) {
+		if err := checkSupportedOS("windows"); !errors.Is(err, ErrUnsupportedOS) {
+			t.Errorf("Expected ErrUnsupportedOS, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execTarget is the container serving the volume of a mount.
+type execTarget struct {
+	Namespace string
+	PodName   string
+	Container string
+	// MountPath is where the volume is mounted in the container.
+	MountPath string
+}
+
+// execStreams are what an exec is attached to.
+type execStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// TTY allocates a terminal in the container, for interactive shells.
+	TTY bool
+}
+
+// Exec runs a command in the volume of a mounted PVC, an interactive shell if command is
+// empty. It runs in the container the volume is mounted in, the pod created for the mount or
+// the ephemeral container in the pod using an RWO PVC. A terminal is allocated if stdin is one.
+func Exec(ctx context.Context, namespace, pvcName string, command []string) error {
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return err
+	}
+	target, err := findExecTarget(ctx, clientset, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	streams := execStreams{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set up the terminal: %v", err)
+		}
+		defer func() { _ = term.Restore(fd, state) }()
+		streams.TTY = true
+	}
+	return streamExec(ctx, clientset, target, buildExecCommand(target.MountPath, command), streams)
+}
+
+// findExecTarget finds the container serving the PVC. Every running pod of a mount of the PVC
+// serves the same volume, so the first one by name is used.
+func findExecTarget(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string) (execTarget, error) {
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{"app": "volume-exposer", "pvcName": pvcName}.String(),
+	})
+	if err != nil {
+		return execTarget{}, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if originalPodName := pod.Labels["originalPodName"]; originalPodName != "" {
+			return findEphemeralExecTarget(ctx, clientset, namespace, originalPodName, pod.Annotations[EphemeralContainerAnnotation])
+		}
+		for _, container := range pod.Spec.Containers {
+			if len(container.VolumeMounts) > 0 {
+				return execTarget{Namespace: namespace, PodName: pod.Name, Container: container.Name, MountPath: container.VolumeMounts[0].MountPath}, nil
+			}
+		}
+	}
+	return execTarget{}, fmt.Errorf("%w: no running pod for PVC %s in namespace %s, mount it first", ErrPodNotFound, pvcName, namespace)
+}
+
+// findEphemeralExecTarget finds the ephemeral container mounting an RWO PVC in the pod using it.
+func findEphemeralExecTarget(ctx context.Context, clientset kubernetes.Interface, namespace, podName, containerName string) (execTarget, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return execTarget{}, fmt.Errorf("failed to get pod %s: %w", podName, err)
+	}
+
+	containers := pod.Spec.EphemeralContainers
+	for i := len(containers) - 1; i >= 0; i-- {
+		container := containers[i]
+		// Proxy pods created before the container was recorded on them use the newest one
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+		if len(container.VolumeMounts) == 0 {
+			break
+		}
+		return execTarget{Namespace: namespace, PodName: podName, Container: container.Name, MountPath: container.VolumeMounts[0].MountPath}, nil
+	}
+	return execTarget{}, fmt.Errorf("no ephemeral container mounting the PVC found in pod %s", podName)
+}
+
+// buildExecCommand runs the command, a shell if empty, in the mount path. The path and the
+// command are passed as arguments, so they need no quoting.
+func buildExecCommand(mountPath string, command []string) []string {
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+	return append([]string{"sh", "-c", `cd "$0" && exec "$@"`, mountPath}, command...)
+}
+
+// streamExec runs the command in the target container, attached to the streams.
+var streamExec = func(ctx context.Context, clientset kubernetes.Interface, target execTarget, command []string, streams execStreams) error {
+	config, err := buildKubeConfig()
+	if err != nil {
+		return err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(target.Namespace).
+		Name(target.PodName).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: target.Container,
+			Command:   command,
+			Stdin:     streams.Stdin != nil,
+			Stdout:    true,
+			// With a terminal, stderr comes through stdout
+			Stderr: !streams.TTY,
+			TTY:    streams.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %v", err)
+	}
+
+	options := remotecommand.StreamOptions{
+		Stdin:  streams.Stdin,
+		Stdout: streams.Stdout,
+		Tty:    streams.TTY,
+	}
+	if streams.TTY {
+		if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			options.TerminalSizeQueue = &fixedTerminalSize{size: &remotecommand.TerminalSize{Width: uint16(width), Height: uint16(height)}}
+		}
+	} else {
+		options.Stderr = streams.Stderr
+	}
+	return executor.StreamWithContext(ctx, options)
+}
+
+// fixedTerminalSize passes the size of the local terminal once, when the exec starts.
+type fixedTerminalSize struct {
+	size *remotecommand.TerminalSize
+}
+
+func (s *fixedTerminalSize) Next() *remotecommand.TerminalSize {
+	size := s.size
+	s.size = nil
+	return size
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func newRunningExposerPod(podName, pvcName string) *corev1.Pod {
+	pod := newExposerPod("default", podName, pvcName, "/mnt/"+podName)
+	pod.Spec.Containers = []corev1.Container{{
+		Name:         "volume-exposer",
+		VolumeMounts: []corev1.VolumeMount{{Name: "my-pvc", MountPath: DefaultRemoteMountPath}},
+	}}
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+func TestFindExecTarget(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Standalone pod", func(t *testing.T) {
+		pending := newRunningExposerPod("volume-exposer-aaaaa", "data")
+		pending.Status.Phase = corev1.PodPending
+		clientset := fake.NewSimpleClientset(
+			pending,
+			newRunningExposerPod("volume-exposer-ccccc", "data"),
+			newRunningExposerPod("volume-exposer-bbbbb", "data"),
+			newRunningExposerPod("volume-exposer-ddddd", "logs"),
+		)
+
+		target, err := findExecTarget(ctx, clientset, "default", "data")
+		if err != nil {
+			t.Fatalf("findExecTarget() returned an error: %v", err)
+		}
+		expected := execTarget{Namespace: "default", PodName: "volume-exposer-bbbbb", Container: "volume-exposer", MountPath: DefaultRemoteMountPath}
+		if target != expected {
+			t.Errorf("Expected the first running pod %+v, got %+v", expected, target)
+		}
+	})
+
+	t.Run("Ephemeral container", func(t *testing.T) {
+		proxy := newRunningExposerPod("volume-exposer-proxy-abcde", "data")
+		proxy.Spec.Containers[0].VolumeMounts = nil
+		proxy.Labels["originalPodName"] = "workload"
+		proxy.Annotations = map[string]string{EphemeralContainerAnnotation: "volume-exposer-ephemeral-first"}
+		workload := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				EphemeralContainers: []corev1.EphemeralContainer{
+					{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name:         "volume-exposer-ephemeral-first",
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+					}},
+					{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name:         "volume-exposer-ephemeral-second",
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: DefaultRemoteMountPath}},
+					}},
+				},
+			},
+		}
+
+		target, err := findExecTarget(ctx, fake.NewSimpleClientset(proxy, workload), "default", "data")
+		if err != nil {
+			t.Fatalf("findExecTarget() returned an error: %v", err)
+		}
+		expected := execTarget{Namespace: "default", PodName: "workload", Container: "volume-exposer-ephemeral-first", MountPath: "/data"}
+		if target != expected {
+			t.Errorf("Expected the ephemeral container of the mount %+v, got %+v", expected, target)
+		}
+	})
+
+	t.Run("Not mounted", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newRunningExposerPod("volume-exposer-abcde", "logs"))
+		if _, err := findExecTarget(ctx, clientset, "default", "data"); !errors.Is(err, ErrPodNotFound) {
+			t.Errorf("Expected ErrPodNotFound for a PVC that isn't mounted, got %v", err)
+		}
+	})
+}
+
+func TestBuildExecCommand(t *testing.T) {
+	tests := []struct {
+		command  []string
+		expected []string
+	}{
+		{
+			command:  nil,
+			expected: []string{"sh", "-c", `cd "$0" && exec "$@"`, "/volume", "sh"},
+		},
+		{
+			command:  []string{"du", "-sh", "my dir"},
+			expected: []string{"sh", "-c", `cd "$0" && exec "$@"`, "/volume", "du", "-sh", "my dir"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := buildExecCommand("/volume", tt.command); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("buildExecCommand(%q) = %q, expected %q", tt.command, got, tt.expected)
+		}
+	}
+}
+
+func TestFixedTerminalSize(t *testing.T) {
+	queue := &fixedTerminalSize{size: &remotecommand.TerminalSize{Width: 80, Height: 24}}
+	if size := queue.Next(); size == nil || size.Width != 80 || size.Height != 24 {
+		t.Errorf("Expected the terminal size first, got %v", size)
+	}
+	if size := queue.Next(); size != nil {
+		t.Errorf("Expected the size to be passed only once, got %v", size)
+	}
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MountInfo describes a mount, as recorded on the pod created for it.
+type MountInfo struct {
+	Namespace string `json:"namespace"`
+	PVCName   string `json:"pvc"`
+	PodName   string `json:"pod"`
+	// OriginalPodName is the pod using the PVC for mounts through an ephemeral container.
+	OriginalPodName string `json:"originalPod,omitempty"`
+	Backend         string `json:"backend"`
+	// Via is how the pod is reached, ViaPortForward or ViaService.
+	Via       string `json:"via"`
+	LocalPort int    `json:"localPort"`
+	// LocalMountPoint is empty for pods of older versions that didn't record it.
+	LocalMountPoint string          `json:"mountPoint,omitempty"`
+	Phase           corev1.PodPhase `json:"phase,omitempty"`
+	Age             time.Duration   `json:"-"`
+}
+
+// ListMounts returns the mounts in the namespace, in all namespaces if it's empty, sorted
+// by namespace, PVC and pod. What pods of older versions don't record is taken from the
+// mounts recorded on this machine.
+func ListMounts(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]MountInfo, error) {
+	selector, err := exposerSelector("")
+	if err != nil {
+		return nil, err
+	}
+	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	now := clock()
+	records := recordedMounts()
+	mounts := make([]MountInfo, 0, len(podList.Items))
+	for i := range podList.Items {
+		info := mountInfoFromPod(&podList.Items[i], now)
+		completeFromRecords(&info, records)
+		mounts = append(mounts, info)
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		a, b := mounts[i], mounts[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.PVCName != b.PVCName {
+			return a.PVCName < b.PVCName
+		}
+		return a.PodName < b.PodName
+	})
+	return mounts, nil
+}
+
+// completeFromRecords fills in the mount point and port of a mount from its local record.
+func completeFromRecords(info *MountInfo, records []mountRecord) {
+	for _, record := range records {
+		if record.Namespace != info.Namespace || record.PodName != info.PodName {
+			continue
+		}
+		if info.LocalMountPoint == "" {
+			info.LocalMountPoint = record.LocalMountPoint
+		}
+		if info.LocalPort == 0 {
+			info.LocalPort = record.LocalPort
+		}
+		return
+	}
+}
+
+// mountInfoFromPod reads a mount from its pod. Pods of older versions only have labels,
+// they used SSHFS through a port-forward.
+func mountInfoFromPod(pod *corev1.Pod, now time.Time) MountInfo {
+	info := MountInfo{
+		Namespace:       pod.Namespace,
+		PVCName:         pod.Labels["pvcName"],
+		PodName:         pod.Name,
+		OriginalPodName: pod.Labels["originalPodName"],
+		Backend:         pod.Annotations[BackendAnnotation],
+		Via:             pod.Annotations[ViaAnnotation],
+		LocalMountPoint: pod.Annotations[MountPointAnnotation],
+		Phase:           pod.Status.Phase,
+		Age:             now.Sub(pod.CreationTimestamp.Time),
+	}
+	if info.Backend == "" {
+		info.Backend = "sshfs"
+	}
+	if info.Via == "" {
+		info.Via = ViaPortForward
+	}
+	port := pod.Annotations[LocalPortAnnotation]
+	if port == "" {
+		port = pod.Labels["portNumber"]
+	}
+	info.LocalPort, _ = strconv.Atoi(port)
+	return info
+}
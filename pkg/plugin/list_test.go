@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListMounts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = original })
+
+	// A mount of the current version, through a Service
+	viaService := createPodSpec("volume-exposer-abcde", 23456, "data", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{Via: ViaService})
+	viaService.Namespace = "team-b"
+	viaService.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	viaService.Status.Phase = corev1.PodRunning
+
+	// A mount through an ephemeral container of the pod using the PVC
+	proxy := createPodSpec("volume-exposer-proxy-fghij", 34567, "logs", "/mnt/logs", "publicKey", "proxy", ProxySSHPort, "workload", MountOptions{})
+	proxy.Namespace = "team-a"
+	proxy.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+
+	// A mount of an older version, which only had labels
+	legacy := newExposerPod("team-a", "volume-exposer-klmno", "cache", "/mnt/cache")
+	legacy.CreationTimestamp = metav1.NewTime(now.Add(-time.Hour))
+
+	// A mount of an older version made from this machine, its mount point is recorded locally
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	recorded := newExposerPod("team-a", "volume-exposer-pqrst", "archive", "/mnt/archive")
+	recorded.CreationTimestamp = metav1.NewTime(now.Add(-time.Hour))
+	store, err := defaultStateStore()
+	if err != nil {
+		t.Fatalf("defaultStateStore() returned an error: %v", err)
+	}
+	record := newTestRecord("team-a", "volume-exposer-pqrst", 4242)
+	record.LocalMountPoint = "/mnt/archive"
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	unrelated := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: "team-a", Labels: map[string]string{"app": "web"}}}
+
+	clientset := fake.NewSimpleClientset(viaService, proxy, legacy, recorded, unrelated)
+
+	mounts, err := ListMounts(context.Background(), clientset, "")
+	if err != nil {
+		t.Fatalf("ListMounts() returned an error: %v", err)
+	}
+	expected := []MountInfo{
+		{Namespace: "team-a", PVCName: "archive", PodName: "volume-exposer-pqrst", Backend: "sshfs", Via: ViaPortForward, LocalPort: 12345, LocalMountPoint: "/mnt/archive", Age: time.Hour},
+		{Namespace: "team-a", PVCName: "cache", PodName: "volume-exposer-klmno", Backend: "sshfs", Via: ViaPortForward, LocalPort: 12345, Age: time.Hour},
+		{Namespace: "team-a", PVCName: "logs", PodName: "volume-exposer-proxy-fghij", OriginalPodName: "workload", Backend: "sshfs", Via: ViaPortForward, LocalPort: 34567, LocalMountPoint: "/mnt/logs", Age: time.Minute},
+		{Namespace: "team-b", PVCName: "data", PodName: "volume-exposer-abcde", Backend: "sshfs", Via: ViaService, LocalPort: 23456, LocalMountPoint: "/mnt/data", Phase: corev1.PodRunning, Age: 2 * time.Hour},
+	}
+	if len(mounts) != len(expected) {
+		t.Fatalf("Expected %d mounts, got %+v", len(expected), mounts)
+	}
+	for i := range expected {
+		if mounts[i] != expected[i] {
+			t.Errorf("Mount %d = %+v; want %+v", i, mounts[i], expected[i])
+		}
+	}
+
+	mounts, err = ListMounts(context.Background(), clientset, "team-b")
+	if err != nil {
+		t.Fatalf("ListMounts() returned an error: %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].PodName != "volume-exposer-abcde" {
+		t.Errorf("Expected only the mount of team-b, got %+v", mounts)
+	}
+}
@@ -3,19 +3,32 @@ package plugin
 import (
 	"context"
 	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
+	"golang.org/x/crypto/ssh"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -27,319 +40,1705 @@ const (
 	DefaultSSHPort   int   = 2137
 	ProxySSHPort     int   = 6666
 
+	// Annotations recording where and how an exposer pod is mounted locally
+	MountPointAnnotation = "pv-mounter.fenio.dev/mount-point"
+	BackendAnnotation    = "pv-mounter.fenio.dev/backend"
+	LocalPortAnnotation  = "pv-mounter.fenio.dev/local-port"
+	ViaAnnotation        = "pv-mounter.fenio.dev/via"
+	MaxAgeAnnotation     = "pv-mounter.fenio.dev/max-age"
+	// SnapshotAnnotation records the VolumeSnapshot a temporary PVC was restored from, on the
+	// PVC and on the pod mounting it.
+	SnapshotAnnotation = "pv-mounter.fenio.dev/snapshot"
+	// AnnotatedPVAnnotation records on the pod which PV was annotated with the mount.
+	AnnotatedPVAnnotation = "pv-mounter.fenio.dev/annotated-pv"
+	// Annotations left on the PV with --annotate-pv while it's mounted
+	MountedByAnnotation  = "pv-mounter.fenio.dev/mounted-by"
+	MountedAtAnnotation  = "pv-mounter.fenio.dev/mounted-at"
+	MountedPodAnnotation = "pv-mounter.fenio.dev/mounted-pod"
+	// EphemeralContainerAnnotation records on a proxy pod which ephemeral container of the
+	// workload pod holds its tunnel, the workload pod may have several from other mounts.
+	EphemeralContainerAnnotation = "pv-mounter.fenio.dev/ephemeral-container"
+
 	CPURequest              = "10m"
 	MemoryRequest           = "50Mi"
 	MemoryLimit             = "100Mi"
 	EphemeralStorageRequest = "1Mi"
 	EphemeralStorageLimit   = "2Mi"
+
+	DefaultWaitReadyTimeout = 5 * time.Minute
+	// DefaultPullTimeout is how long the image may fail to pull before the mount gives up,
+	// long enough for registries that only fail now and then.
+	DefaultPullTimeout = 90 * time.Second
+
+	// DefaultRemoteMountPath is where the volume is mounted in the pod and served from over SSH.
+	DefaultRemoteMountPath = "/volume"
+
+	// DefaultPodNamePrefix is what the names of the created pods start with.
+	DefaultPodNamePrefix = "volume-exposer"
+
+	// DefaultKeepAliveInterval is the default number of seconds between SSH keep-alive messages.
+	DefaultKeepAliveInterval = 15
+	// KeepAliveCountMax is how many keep-alive messages may go unanswered before SSHFS reconnects.
+	KeepAliveCountMax = 3
+)
+
+// How SSHFS maps the owners of the files, see MountOptions.IDMapMode.
+const (
+	// IDMapIgnore shows the numeric owners of the pod, ignoring those without a mapping.
+	IDMapIgnore = "ignore"
+	// IDMapNone leaves the owners to SSHFS, showing the numeric owners of the pod.
+	IDMapNone = "none"
+	// IDMapUser shows the files of the user of the pod as owned by the local user.
+	IDMapUser = "user"
 )
 
 var DefaultID int64 = 2137
 
-func Mount(ctx context.Context, namespace, pvcName, localMountPoint string, needsRoot, debug bool) error {
+// MountOptions holds the optional settings of a mount.
+type MountOptions struct {
+	NeedsRoot bool
+	Debug     bool
+	// DryRun prints the resources and commands that would be used without creating anything.
+	DryRun bool
+	// APIRetries is how many times transient Kubernetes API errors are retried.
+	APIRetries int
+	// ReadOnly mounts the volume without write access. Required for ReadOnlyMany volumes.
+	ReadOnly bool
+	// SSHPort is the port the SSH server of a standalone pod listens on, DefaultSSHPort if unset.
+	SSHPort int
+	// AllowWritableRootFS makes the root filesystem of the containers writable, for debugging only.
+	AllowWritableRootFS bool
+	// SeccompProfile replaces the RuntimeDefault seccomp profile of the containers, see ParseSeccompProfile.
+	SeccompProfile *corev1.SeccompProfile
+	// AppArmorProfile sets the AppArmor profile of the containers, see ParseAppArmorProfile.
+	AppArmorProfile *corev1.AppArmorProfile
+	// FSGroup is the supplemental group owning the volume in the pod, unset by default.
+	FSGroup *int64
+	// FSGroupChangePolicy controls when the ownership of the volume is changed to FSGroup.
+	FSGroupChangePolicy *corev1.PodFSGroupChangePolicy
+	// AllowOther lets other local users access the mount. FUSE only permits it
+	// with user_allow_other in /etc/fuse.conf.
+	AllowOther bool
+	// Offline never pulls images, for clusters without access to the registry.
+	// The image has to be present on the nodes already.
+	Offline bool
+	// Compression enables SSH compression, which helps on slow links but hurts on fast ones.
+	Compression bool
+	// IDMapMode is how SSHFS maps the owners of the files. If unset, the files are shown as
+	// owned by the local owner, see UID and GID. IDMapIgnore only maps them if UID or GID is set.
+	IDMapMode string
+	// UID and GID are the local owner of the mounted files, the current user and group if unset.
+	UID *int
+	GID *int
+	// AllowNonEmpty mounts over a mount point that isn't empty, hiding its contents until it's unmounted.
+	AllowNonEmpty bool
+	// ChownMountPoint makes the local owner (see UID and GID) the owner of the mount point
+	// after it was mounted, so it can be traversed even if FUSE reports it as owned by root.
+	ChownMountPoint bool
+	// KeepAliveInterval is the number of seconds between SSH keep-alive messages,
+	// DefaultKeepAliveInterval if unset. A negative value disables keep-alives and reconnects.
+	KeepAliveInterval int
+	// WaitReadyTimeout limits how long to wait for the pod to become ready, DefaultWaitReadyTimeout if unset.
+	WaitReadyTimeout time.Duration
+	// PullTimeout is how long the image of the pod may fail to pull before the mount fails,
+	// instead of waiting for WaitReadyTimeout. DefaultPullTimeout if unset.
+	PullTimeout time.Duration
+	// PodNamePrefix replaces DefaultPodNamePrefix in the names of the created pods.
+	PodNamePrefix string
+	// OwnerRef makes the created pod owned by another object, so it's garbage collected
+	// together with it. Either "workload" for the pod using an RWO PVC, or <kind>/<name>
+	// of an object in the namespace of the PVC or of a cluster-scoped one, see resolveOwnerReference.
+	OwnerRef string
+	// AssumeRWX always mounts the PVC from a new standalone pod, even if its PV is RWO and
+	// already used by another pod. Only safe if the storage really supports concurrent access.
+	AssumeRWX bool
+	// NoEphemeralFallback refuses to mount RWO PVCs in use by another pod instead of mounting
+	// them from an ephemeral container in it, for clusters that disable ephemeral containers
+	// or policies that forbid changing running workloads.
+	NoEphemeralFallback bool
+	// Env adds environment variables to the container exposing the volume, for custom images.
+	// The variables pv-mounter sets itself can't be overridden, see ParseEnvVar.
+	Env []corev1.EnvVar
+	// Timings prints how long each phase of the mount took once it's done.
+	Timings bool
+	// Via is how SSHFS reaches the pod: ViaPortForward (the default) through kubectl port-forward,
+	// or ViaService through a Service created for the pod, for where port-forwards are disabled.
+	Via string
+	// ServiceType is the type of the Service used with ViaService, ClusterIP if unset. Cluster IPs
+	// are only reachable from inside the cluster.
+	ServiceType corev1.ServiceType
+	// MaxAge records on the pod how long the mount is meant to live. clean --all --max-age
+	// uses it instead of its own max age for the pod.
+	MaxAge time.Duration
+	// TTL sets the active deadline of the pod, after which Kubernetes stops it even if clean
+	// is never run. The mount stops working when it fires.
+	TTL time.Duration
+	// KeepKey writes the generated private key to this file, so the pod can be reached with
+	// ssh for debugging. Unlike the temporary key of SSHFS, it's never removed.
+	KeepKey string
+	// NoWait creates the pod and the port-forward but doesn't mount the PVC, it only prints
+	// how to. The private key is kept in KeepKey, or in the state directory if unset.
+	NoWait bool
+	// RemoteMountPath is where the volume is mounted in the container and what SSHFS mounts,
+	// DefaultRemoteMountPath if unset. Custom images may serve the volume from elsewhere.
+	RemoteMountPath string
+	// Address is what the port-forward binds to and SSHFS connects to, localhost if unset.
+	// Anything but a loopback address makes the SSH server reachable from other hosts.
+	Address string
+	// AutoAdjustResources moves the resources of the pod into the range the LimitRanges of
+	// the namespace allow, instead of only warning that the pod will likely be rejected.
+	AutoAdjustResources bool
+	// AutomountServiceAccountToken mounts the token of the service account into the pod,
+	// which it doesn't need unless a custom image talks to the API server.
+	AutomountServiceAccountToken bool
+	// PriorityClass is the priority class of the pod, so it isn't preempted during long
+	// transfers on busy clusters. A missing class is rejected when the pod is created.
+	PriorityClass string
+	// HostAliases are added to /etc/hosts of the pod, for custom images resolving names
+	// the cluster DNS doesn't know.
+	HostAliases []corev1.HostAlias
+	// DNSServers and DNSSearches are used by the pod in addition to the cluster DNS.
+	DNSServers  []string
+	DNSSearches []string
+	// CPULimit limits the CPU of the pod, which has no CPU limit if unset. ResourceQuotas on
+	// limits.cpu reject pods without one.
+	CPULimit *resource.Quantity
+	// MemoryLimit replaces the default memory limit of the pod.
+	MemoryLimit *resource.Quantity
+	// ImageDigest pins the image of the pod and ephemeral container to this digest, e.g.
+	// sha256:..., instead of the tag of the release.
+	ImageDigest string
+	// Strict fails the mount if the containers don't run the image with ImageDigest once
+	// they started, e.g. because a mutating webhook replaced it.
+	Strict bool
+	// ImagePullSecrets are the secrets the pod pulls its image with, e.g. from a mirror of
+	// the registry. Missing ones fail the mount before the pod is created.
+	ImagePullSecrets []string
+	// SkipImagePullSecretCheck creates the pod even if ImagePullSecrets don't exist yet, for
+	// secrets created concurrently, e.g. by a controller copying them into new namespaces.
+	SkipImagePullSecretCheck bool
+	// AnnotatePV annotates the PV of the PVC with who mounts it, since when and through which
+	// pod, for operators sharing a cluster. clean removes the annotations. PVCs restored from
+	// snapshots aren't annotated.
+	AnnotatePV bool
+	// InheritPVCLabels copies the labels of the PVC onto the pod, e.g. team or cost-center for
+	// governance tooling. The labels pv-mounter finds its pods by are kept.
+	InheritPVCLabels bool
+	// SSHFSPath is the sshfs binary to run, sshfs from the PATH if unset.
+	SSHFSPath string
+	// SSHFSOptions are passed to SSHFS as additional -o options, for what no other option covers.
+	SSHFSOptions []string
+	// Concurrency is how many PVCs MountBatch mounts at the same time, DefaultBatchConcurrency if unset.
+	Concurrency int
+
+	// fromPod mounts the PVC from an ephemeral container in this pod, see MountPod.
+	fromPod string
+	// ownerReference is OwnerRef resolved against the cluster.
+	ownerReference *metav1.OwnerReference
+	// timer measures the phases of the mount if Timings is set.
+	timer *phaseTimer
+	// ephemeralContainerName is the name of the ephemeral container of an RWO mount, chosen
+	// before the proxy pod is created so the pod can record it.
+	ephemeralContainerName string
+	// sshfsHost is where SSHFS connects to, localhost if unset.
+	sshfsHost string
+	// resources are the resources of the pod checked against the namespace, the defaults if unset.
+	resources *corev1.ResourceRequirements
+	// pvcLabels are the labels of the PVC inherited by the pod if InheritPVCLabels is set.
+	pvcLabels map[string]string
+	// annotatedPV is the PV annotated with the mount if AnnotatePV is set, recorded on the pod
+	// so clean removes the annotations again.
+	annotatedPV string
+	// snapshot is the VolumeSnapshot the mounted PVC was restored from by MountSnapshot. The
+	// PVC was created for the mount, so nothing else uses it, and clean deletes it.
+	snapshot string
+}
 
-	checkSSHFS()
+// sshPort returns the port the SSH server of a standalone pod listens on.
+func (o MountOptions) sshPort() int {
+	if o.SSHPort == 0 {
+		return DefaultSSHPort
+	}
+	return o.SSHPort
+}
 
-	if err := validateMountPoint(localMountPoint); err != nil {
+func (o MountOptions) keepAliveInterval() int {
+	if o.KeepAliveInterval == 0 {
+		return DefaultKeepAliveInterval
+	}
+	return o.KeepAliveInterval
+}
+
+// localOwner returns the uid and gid the mounted files appear to be owned by locally.
+func (o MountOptions) localOwner() (int, int) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if o.UID != nil {
+		uid = *o.UID
+	}
+	if o.GID != nil {
+		gid = *o.GID
+	}
+	return uid, gid
+}
+
+func (o MountOptions) remoteMountPath() string {
+	if o.RemoteMountPath == "" {
+		return DefaultRemoteMountPath
+	}
+	return o.RemoteMountPath
+}
+
+// host returns where SSHFS connects to.
+func (o MountOptions) host() string {
+	if o.sshfsHost == "" {
+		return "localhost"
+	}
+	return o.sshfsHost
+}
+
+// remoteTarget returns the user@host:path of the pod for SSHFS, sftp and scp. IPv6
+// addresses are bracketed, their colons would otherwise be taken for the path separator.
+func (o MountOptions) remoteTarget(remotePath string) string {
+	host := o.host()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return fmt.Sprintf("%s@%s:%s", sshUserFor(o.NeedsRoot), host, remotePath)
+}
+
+func (o MountOptions) concurrency() int {
+	if o.Concurrency == 0 {
+		return DefaultBatchConcurrency
+	}
+	return o.Concurrency
+}
+
+func (o MountOptions) sshfsPath() string {
+	if o.SSHFSPath == "" {
+		return "sshfs"
+	}
+	return o.SSHFSPath
+}
+
+func (o MountOptions) podResources() corev1.ResourceRequirements {
+	if o.resources == nil {
+		return buildResourceRequirements(o)
+	}
+	return *o.resources
+}
+
+func (o MountOptions) serviceType() corev1.ServiceType {
+	if o.ServiceType == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return o.ServiceType
+}
+
+func (o MountOptions) imagePullPolicy() corev1.PullPolicy {
+	if o.Offline {
+		return corev1.PullIfNotPresent
+	}
+	return corev1.PullAlways
+}
+
+func (o MountOptions) pullTimeout() time.Duration {
+	if o.PullTimeout == 0 {
+		return DefaultPullTimeout
+	}
+	return o.PullTimeout
+}
+
+func (o MountOptions) waitReadyTimeout() time.Duration {
+	if o.WaitReadyTimeout == 0 {
+		return DefaultWaitReadyTimeout
+	}
+	return o.WaitReadyTimeout
+}
+
+// validateMountOptions checks the options before anything is created.
+func validateMountOptions(opts MountOptions) error {
+	if opts.SSHPort < 0 || opts.SSHPort > 65535 {
+		return fmt.Errorf("invalid SSH port %d, must be between 1 and 65535", opts.SSHPort)
+	}
+	if err := validateVia(opts); err != nil {
 		return err
 	}
+	if opts.PodNamePrefix != "" {
+		// The longest name is the one of a proxy pod
+		if errs := validation.IsDNS1123Label(opts.PodNamePrefix + "-proxy-abcde"); len(errs) != 0 {
+			return fmt.Errorf("invalid pod name prefix %s: %s", opts.PodNamePrefix, strings.Join(errs, ", "))
+		}
+	}
+	if opts.OwnerRef != "" && opts.OwnerRef != WorkloadOwner {
+		if _, _, err := parseOwnerRef(opts.OwnerRef); err != nil {
+			return err
+		}
+	}
+	if opts.UID != nil && *opts.UID < 0 {
+		return fmt.Errorf("invalid uid %d, must not be negative", *opts.UID)
+	}
+	if opts.GID != nil && *opts.GID < 0 {
+		return fmt.Errorf("invalid gid %d, must not be negative", *opts.GID)
+	}
+	if opts.RemoteMountPath != "" && !path.IsAbs(opts.RemoteMountPath) {
+		return fmt.Errorf("invalid remote mount path %s, must be absolute", opts.RemoteMountPath)
+	}
+	if opts.Address != "" {
+		if opts.Via == ViaService {
+			return errors.New("an address can only be used with --via port-forward")
+		}
+		if opts.Address != "localhost" && net.ParseIP(opts.Address) == nil {
+			return fmt.Errorf("invalid address %s, must be an IP address or localhost", opts.Address)
+		}
+	}
+	for _, option := range opts.SSHFSOptions {
+		// Options are passed as arguments, not through a shell, so only these could break them
+		if option == "" || strings.ContainsAny(option, "\x00\n\r") {
+			return fmt.Errorf("invalid SSHFS option %q, must not be empty or contain line breaks", option)
+		}
+	}
+	if opts.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency %d, must not be negative", opts.Concurrency)
+	}
+	if opts.PullTimeout < 0 {
+		return fmt.Errorf("invalid pull timeout %s, must not be negative", opts.PullTimeout)
+	}
+	if opts.MaxAge < 0 {
+		return fmt.Errorf("invalid max age %s, must not be negative", opts.MaxAge)
+	}
+	if err := validateDNS(opts); err != nil {
+		return err
+	}
+	if err := validateResourceLimits(opts); err != nil {
+		return err
+	}
+	if err := validateImageDigest(opts); err != nil {
+		return err
+	}
+	if err := validateImagePullSecrets(opts); err != nil {
+		return err
+	}
+	if err := validateIDMapMode(opts); err != nil {
+		return err
+	}
+	if opts.TTL < 0 {
+		return fmt.Errorf("invalid TTL %s, must not be negative", opts.TTL)
+	}
+	if opts.FSGroup != nil && *opts.FSGroup < 0 {
+		return fmt.Errorf("invalid fsGroup %d, must not be negative", *opts.FSGroup)
+	}
+	for _, envVar := range opts.Env {
+		if reservedEnvVars[envVar.Name] {
+			return fmt.Errorf("environment variable %s is set by pv-mounter and can't be overridden", envVar.Name)
+		}
+		if errs := validation.IsEnvVarName(envVar.Name); len(errs) != 0 {
+			return fmt.Errorf("invalid environment variable name %s: %s", envVar.Name, strings.Join(errs, ", "))
+		}
+	}
+	if policy := opts.FSGroupChangePolicy; policy != nil && *policy != corev1.FSGroupChangeOnRootMismatch && *policy != corev1.FSGroupChangeAlways {
+		return fmt.Errorf("invalid fsGroup change policy %s, must be %s or %s", *policy, corev1.FSGroupChangeOnRootMismatch, corev1.FSGroupChangeAlways)
+	}
+	return nil
+}
+
+// sshfsMounter runs SSHFS once the pod and the port-forward are ready. It returns the
+// SSHFS process if it keeps running in the background, nil otherwise.
+type sshfsMounter func(port int, localMountPoint, pvcName, privateKey string, opts MountOptions) (*backgroundCommand, error)
 
-	clientset, err := BuildKubeClient()
+func Mount(ctx context.Context, namespace, pvcName, localMountPoint string, opts MountOptions) error {
+	clientset, err := prepareMount(localMountPoint, opts)
 	if err != nil {
 		return err
 	}
 
-	pvc, err := checkPVCUsage(ctx, clientset, namespace, pvcName)
+	return mount(ctx, clientset, namespace, pvcName, localMountPoint, opts)
+}
+
+// MountJSON mounts the PVC like Mount and writes the MountInfo of the mount as JSON to stdout,
+// for scripts. The progress messages go to stderr instead, so stdout only carries the JSON.
+// Dry runs aren't supported.
+func MountJSON(ctx context.Context, namespace, pvcName, localMountPoint string, opts MountOptions) error {
+	if opts.DryRun {
+		return errors.New("dry runs can't be combined with JSON output")
+	}
+
+	return WithStdoutToStderr(func(stdout io.Writer) error {
+		clientset, err := prepareMount(localMountPoint, opts)
+		if err != nil {
+			return err
+		}
+		return mountJSON(ctx, clientset, namespace, pvcName, localMountPoint, opts, stdout)
+	})
+}
+
+// WithStdoutToStderr calls f with what's printed to stdout going to stderr, passing it the
+// real stdout. It keeps the progress messages out of machine-readable output.
+func WithStdoutToStderr(f func(stdout io.Writer) error) error {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+	return f(stdout)
+}
+
+func mountJSON(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts MountOptions, w io.Writer) error {
+	session, err := startMount(ctx, clientset, namespace, pvcName, localMountPoint, opts, mountInForeground)
 	if err != nil {
 		return err
 	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(session.Info())
+}
+
+// prepareMount checks the environment and options of a mount and builds the Kubernetes client.
+func prepareMount(localMountPoint string, opts MountOptions) (kubernetes.Interface, error) {
+	if err := checkSupportedOS(runtime.GOOS); err != nil {
+		return nil, err
+	}
 
-	canBeMounted, podUsingPVC, err := checkPVAccessMode(ctx, clientset, pvc, namespace)
+	if err := validateMountOptions(opts); err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun && !opts.NoWait {
+		if err := checkSSHFS(opts.SSHFSPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateMountPoint(localMountPoint); err != nil {
+		return nil, err
+	}
+	if !opts.AllowNonEmpty {
+		if err := checkMountPointEmpty(localMountPoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Offline {
+		image, _ := getEphemeralContainerSettings(opts)
+		fmt.Printf("Offline mode: image %s is only pulled if it's missing on the node, make sure it's available there\n", image)
+	}
+
+	return BuildKubeClient()
+}
+
+func mount(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts MountOptions) error {
+	_, err := startMount(ctx, clientset, namespace, pvcName, localMountPoint, opts, mountInForeground)
+	return err
+}
+
+// mountInForeground runs SSHFS until it mounted the volume, unlike startSSHFS it doesn't
+// keep track of SSHFS afterwards.
+func mountInForeground(port int, localMountPoint, pvcName, privateKey string, opts MountOptions) (*backgroundCommand, error) {
+	return nil, mountPVCOverSSH(port, localMountPoint, pvcName, privateKey, opts)
+}
+
+func startMount(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts MountOptions, mounter sshfsMounter) (*MountSession, error) {
+	var podUsingPVC string
+	var err error
+	// A PVC restored from a snapshot may only bind once the pod using it is scheduled
+	if opts.snapshot == "" {
+		podUsingPVC, err = checkPVCAccess(ctx, clientset, namespace, pvcName, &opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if podUsingPVC != "" && opts.NoEphemeralFallback {
+		return nil, fmt.Errorf("%w: PVC %s is in use by pod %s and can only be mounted from an ephemeral container in it, which --no-ephemeral-fallback disables; "+
+			"stop pod %s (e.g. scale down its workload) to mount the PVC from a pod of its own, or use --assume-rwx if the storage supports concurrent access",
+			ErrAccessModeNotUsable, pvcName, podUsingPVC, podUsingPVC)
+	}
+
+	// The reverse tunnel from the ephemeral container only listens on the proxy pod's loopback,
+	// so a Service targeting the proxy pod has nothing to reach.
+	if podUsingPVC != "" && opts.Via == ViaService {
+		return nil, fmt.Errorf("--via service can't be used for PVC %s, it's in use by pod %s and mounted through a proxy pod; use the default port-forward, or --assume-rwx if the storage allows mounting it from another pod", pvcName, podUsingPVC)
+	}
+
+	if opts.OwnerRef != "" {
+		opts.ownerReference, err = resolveOwnerReference(ctx, clientset, namespace, opts.OwnerRef, podUsingPVC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkImagePullSecrets(ctx, clientset, namespace, opts); err != nil {
+		return nil, err
+	}
+
+	resources := resolveResources(ctx, clientset, namespace, opts)
+	opts.resources = &resources
+
+	if opts.NoWait {
+		mounter = mountLater
+	}
+
+	if opts.Timings && !opts.DryRun {
+		opts.timer = &phaseTimer{}
+		defer opts.timer.print(pvcName)
+	}
+
+	// ReadWriteOnce and ReadWriteOncePod volumes can be attached to a new pod only while unused,
+	// podUsingPVC is only set for those. Otherwise they are mounted from an ephemeral container
+	// in the pod using them, the proxy pod never attaches the volume, so even ReadWriteOncePod works.
+	var session *MountSession
+	if podUsingPVC == "" {
+		session, err = handleRWX(ctx, clientset, namespace, pvcName, localMountPoint, opts, mounter)
+	} else {
+		session, err = handleRWO(ctx, clientset, namespace, pvcName, localMountPoint, podUsingPVC, opts, mounter)
+	}
+	if err == nil && session != nil && opts.annotatedPV != "" {
+		annotatePV(ctx, clientset, opts.annotatedPV, namespace, session.PodName)
+	}
+	return session, err
+}
+
+// checkPVCAccess checks that the PVC can be mounted and returns the pod using it if it has to
+// be mounted from an ephemeral container in that pod.
+func checkPVCAccess(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string, opts *MountOptions) (string, error) {
+	pvc, err := checkPVCUsage(ctx, clientset, namespace, pvcName, opts.APIRetries)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if opts.AnnotatePV {
+		opts.annotatedPV = pvc.Spec.VolumeName
+	}
+	if opts.InheritPVCLabels {
+		opts.pvcLabels = pvc.Labels
+	}
+
+	if opts.fromPod != "" {
+		// The pod has the PVC attached already, whatever its access mode
+		fmt.Printf("Mounting PVC %s from an ephemeral container in pod %s\n", pvcName, opts.fromPod)
+		return opts.fromPod, nil
+	}
+
+	if opts.AssumeRWX && contains(pvc.Spec.AccessModes, corev1.ReadWriteOncePod) {
+		// Kubernetes itself never lets a second pod use the PVC, a new pod would stay pending
+		fmt.Printf("Warning: PVC %s is %s, ignoring --assume-rwx\n", pvcName, corev1.ReadWriteOncePod)
+		opts.AssumeRWX = false
 	}
 
-	if canBeMounted {
-		return handleRWX(ctx, clientset, namespace, pvcName, localMountPoint, needsRoot, debug)
+	if opts.AssumeRWX {
+		fmt.Printf("Assuming PVC %s can be mounted by multiple pods\n", pvcName)
+		return "", nil
 	}
 
-	return handleRWO(ctx, clientset, namespace, pvcName, localMountPoint, podUsingPVC, needsRoot, debug)
+	accessMode, podUsingPVC, err := checkPVAccessMode(ctx, clientset, pvc, namespace, opts.APIRetries)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Detected access mode %s for PVC %s\n", accessMode, pvcName)
 
+	if accessMode == corev1.ReadOnlyMany && !opts.ReadOnly {
+		return "", fmt.Errorf("%w: PVC %s only supports %s, use --read-only to mount it", ErrAccessModeNotUsable, pvcName, accessMode)
+	}
+	return podUsingPVC, nil
 }
 
 func validateMountPoint(localMountPoint string) error {
 	if _, err := os.Stat(localMountPoint); os.IsNotExist(err) {
-		return fmt.Errorf("local mount point %s does not exist", localMountPoint)
+		return fmt.Errorf("%w: %s", ErrMountPointMissing, localMountPoint)
+	}
+	return nil
+}
+
+// checkMountPointEmpty makes sure mounting doesn't hide files in the mount point, which
+// users would then miss until it's unmounted again.
+func checkMountPointEmpty(localMountPoint string) error {
+	if info, err := os.Stat(localMountPoint); err != nil || !info.IsDir() {
+		// Leave reporting unusable mount points to SSHFS
+		return nil
+	}
+
+	dir, err := os.Open(localMountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to open mount point %s: %v", localMountPoint, err)
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); err == nil {
+		return fmt.Errorf("%w: %s, use --allow-nonempty to mount over its contents anyway", ErrMountPointNotEmpty, localMountPoint)
+	} else if !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read mount point %s: %v", localMountPoint, err)
+	}
+	return nil
+}
+
+// sshfsVersion returns the output of sshfs -V, which includes the version of the FUSE library.
+var sshfsVersion = func(sshfsPath string) string {
+	out, _ := exec.Command(sshfsPath, "-V").CombinedOutput()
+	return string(out)
+}
+
+// needsNonEmptyOption reports whether SSHFS uses FUSE 2, which refuses to mount over a
+// non-empty directory without -o nonempty. FUSE 3 always allows it and rejects the option.
+func needsNonEmptyOption(version string) bool {
+	return strings.Contains(version, "FUSE library version: 2.")
+}
+
+func handleRWX(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, opts MountOptions, mounter sshfsMounter) (session *MountSession, err error) {
+
+	privateKey, publicKey, err := generateKeyPairFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sshPort := opts.sshPort()
+	remotePort := remoteForwardPort("standalone", sshPort)
+	stopTimer := opts.timer.start("setupPod")
+	podName, port, err := setupPod(ctx, clientset, namespace, pvcName, localMountPoint, publicKey, "standalone", sshPort, "", opts)
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		return nil, printDryRunCommands(namespace, podName, localMountPoint, port, remotePort, opts)
+	}
+
+	cleanup := &cleanupStack{}
+	defer func() {
+		if err != nil {
+			cleanup.unwind()
+		}
+	}()
+	cleanup.push(cleanupPod(clientset, namespace, podName))
+
+	stopTimer = opts.timer.start("waitForPodReady")
+	err = waitForPodReady(ctx, clientset, namespace, podName, opts.waitReadyTimeout(), opts.pullTimeout(), newPodEventReporter(clientset, namespace, podName, opts.Debug))
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Strict {
+		if err := verifyPodImageDigest(ctx, clientset, namespace, podName, opts.ImageDigest); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Via == ViaService {
+		// The service may have been created even if exposing the pod failed afterwards
+		cleanup.push(cleanupService(clientset, namespace, podName))
+	}
+	portForward, sshfsHost, port, err := exposePod(ctx, clientset, namespace, podName, port, remotePort, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.sshfsHost = sshfsHost
+	cleanup.push(cleanupPortForward(podName, portForward))
+
+	if err := keepKeyFor(namespace, podName, privateKey, port, &opts); err != nil {
+		return nil, err
+	}
+
+	stopTimer = opts.timer.start("mount")
+	sshfs, err := mounter(port, localMountPoint, pvcName, privateKey, opts)
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+	session = newMountSession(clientset, namespace, pvcName, localMountPoint, podName, "", portForward, sshfs)
+	session.viaService = opts.Via == ViaService
+	session.host = opts.host()
+	session.reconnect = reconnector(namespace, podName, pvcName, localMountPoint, privateKey, port, remotePort, opts)
+	session.record(port)
+	cleanup.release()
+	return session, nil
+}
+
+func handleRWO(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint string, podUsingPVC string, opts MountOptions, mounter sshfsMounter) (session *MountSession, err error) {
+
+	privateKey, publicKey, err := generateKeyPairFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	remotePort := remoteForwardPort("proxy", ProxySSHPort)
+	opts.ephemeralContainerName = fmt.Sprintf("volume-exposer-ephemeral-%s", randSeq(5))
+	stopTimer := opts.timer.start("setupPod")
+	podName, port, err := setupPod(ctx, clientset, namespace, pvcName, localMountPoint, publicKey, "proxy", ProxySSHPort, podUsingPVC, opts)
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		if _, err := createEphemeralContainer(ctx, clientset, namespace, podUsingPVC, pvcName, privateKey, publicKey, "<proxy-pod-ip>", opts); err != nil {
+			return nil, err
+		}
+		return nil, printDryRunCommands(namespace, podName, localMountPoint, port, remotePort, opts)
+	}
+
+	cleanup := &cleanupStack{}
+	defer func() {
+		if err != nil {
+			cleanup.unwind()
+		}
+	}()
+	cleanup.push(cleanupPod(clientset, namespace, podName))
+
+	stopTimer = opts.timer.start("waitForPodReady")
+	err = waitForPodReady(ctx, clientset, namespace, podName, opts.waitReadyTimeout(), opts.pullTimeout(), newPodEventReporter(clientset, namespace, podName, opts.Debug))
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Strict {
+		if err := verifyPodImageDigest(ctx, clientset, namespace, podName, opts.ImageDigest); err != nil {
+			return nil, err
+		}
+	}
+
+	proxyPodIP, err := getPodIP(ctx, clientset, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+
+	stopTimer = opts.timer.start("createEphemeralContainer")
+	ephemeralContainerName, err := createEphemeralContainer(ctx, clientset, namespace, podUsingPVC, pvcName, privateKey, publicKey, proxyPodIP, opts)
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+	cleanup.push(cleanupEphemeralContainer(clientset, namespace, podUsingPVC, ephemeralContainerName))
+	if opts.Strict {
+		if err := waitForEphemeralImageDigest(ctx, clientset, namespace, podUsingPVC, ephemeralContainerName, opts.ImageDigest, opts.waitReadyTimeout()); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Via == ViaService {
+		// The service may have been created even if exposing the pod failed afterwards
+		cleanup.push(cleanupService(clientset, namespace, podName))
+	}
+	portForward, sshfsHost, port, err := exposePod(ctx, clientset, namespace, podName, port, remotePort, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.sshfsHost = sshfsHost
+	cleanup.push(cleanupPortForward(podName, portForward))
+	if opts.Debug {
+		printTunnel(podUsingPVC, ephemeralContainerName, podName, proxyPodIP, sshfsHost, port, remotePort, opts)
+	}
+
+	if err := keepKeyFor(namespace, podName, privateKey, port, &opts); err != nil {
+		return nil, err
+	}
+
+	stopTimer = opts.timer.start("mount")
+	sshfs, err := mounter(port, localMountPoint, pvcName, privateKey, opts)
+	stopTimer()
+	if err != nil {
+		return nil, err
+	}
+	session = newMountSession(clientset, namespace, pvcName, localMountPoint, podName, podUsingPVC, portForward, sshfs)
+	session.ephemeralContainerName = ephemeralContainerName
+	session.viaService = opts.Via == ViaService
+	session.host = opts.host()
+	session.reconnect = reconnector(namespace, podName, pvcName, localMountPoint, privateKey, port, remotePort, opts)
+	session.record(port)
+	cleanup.release()
+	return session, nil
+}
+
+// generateKeyPairFor generates the SSH key pair for a mount. Dry runs only print
+// the resources, so they get placeholders instead of real keys.
+func generateKeyPairFor(opts MountOptions) (string, string, error) {
+	if opts.DryRun {
+		return "<generated-private-key>", "<generated-public-key>", nil
+	}
+
+	privateKey, publicKey, err := GenerateKeyPair(elliptic.P256())
+	if err != nil {
+		return "", "", fmt.Errorf("error generating key pair: %v", err)
+	}
+
+	if opts.Debug {
+		// Debug output ends up in bug reports, so the key itself is never printed
+		if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err == nil {
+			fmt.Printf("Generated key pair with fingerprint %s\n", ssh.FingerprintSHA256(key))
+		}
+	}
+	return privateKey, publicKey, nil
+}
+
+func createEphemeralContainer(ctx context.Context, clientset kubernetes.Interface, namespace, podName, pvcName, privateKey, publicKey, proxyPodIP string, opts MountOptions) (string, error) {
+	// Retrieve the existing pod to get the volume name
+	existingPod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("%w: %w", ErrPodNotFound, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get existing pod: %w", err)
+	}
+
+	volumeName, err := getPVCVolumeName(existingPod, pvcName)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralContainerName := opts.ephemeralContainerName
+	if ephemeralContainerName == "" {
+		ephemeralContainerName = fmt.Sprintf("volume-exposer-ephemeral-%s", randSeq(5))
+	}
+	ephemeralContainer := buildEphemeralContainerSpec(ephemeralContainerName, volumeName, privateKey, publicKey, proxyPodIP, opts)
+
+	if opts.DryRun {
+		fmt.Printf("# Ephemeral container that would be added to pod %s\n", podName)
+		return ephemeralContainerName, printYAML(ephemeralContainer)
+	}
+
+	fmt.Printf("Adding ephemeral container %s to pod %s with volume name %s\n", ephemeralContainerName, podName, volumeName)
+
+	patchData, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []corev1.EphemeralContainer{ephemeralContainer},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal ephemeral container spec: %v", err)
+	}
+
+	err = retryAPICall(opts.APIRetries, func() error {
+		_, err := clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.StrategicMergePatchType, patchData, metav1.PatchOptions{}, "ephemeralcontainers")
+		return err
+	})
+	if err != nil {
+		if podSecurityErr := podSecurityError(err, opts); podSecurityErr != nil {
+			return "", podSecurityErr
+		}
+		if isEphemeralContainersUnsupported(err, podName) {
+			return "", fmt.Errorf("%w: %v\nthe PVC is in use by pod %s and can only be mounted from an ephemeral container in it. "+
+				"Enable ephemeral containers (the EphemeralContainers feature gate, on by default since Kubernetes 1.23), "+
+				"stop pod %s to mount the PVC from a pod of its own, or use --assume-rwx if the storage supports concurrent access",
+				ErrEphemeralContainersUnsupported, err, podName, podName)
+		}
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("%w: %w", ErrPodNotFound, err)
+		}
+		return "", fmt.Errorf("failed to patch pod with ephemeral container: %v", err)
+	}
+
+	fmt.Printf("Successfully added ephemeral container %s to pod %s\n", ephemeralContainerName, podName)
+	return ephemeralContainerName, nil
+}
+
+// isEphemeralContainersUnsupported reports whether patching the ephemeralcontainers subresource
+// of the pod failed because the cluster doesn't serve it, rather than because the pod is gone.
+func isEphemeralContainersUnsupported(err error, podName string) bool {
+	var statusErr *apierrors.StatusError
+	if apierrors.IsNotFound(err) && errors.As(err, &statusErr) {
+		// A missing subresource isn't reported with the name of the pod
+		details := statusErr.ErrStatus.Details
+		return details == nil || details.Name != podName
+	}
+	return apierrors.IsMethodNotSupported(err) || strings.Contains(err.Error(), "disabled by feature-gate")
+}
+
+func buildEphemeralContainerSpec(name, volumeName, privateKey, publicKey, proxyPodIP string, opts MountOptions) corev1.EphemeralContainer {
+	image, securityContext := getEphemeralContainerSettings(opts)
+
+	return corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            name,
+			Image:           image,
+			ImagePullPolicy: opts.imagePullPolicy(),
+			Env: append([]corev1.EnvVar{
+				{Name: "ROLE", Value: "ephemeral"},
+				{Name: "SSH_PRIVATE_KEY", Value: privateKey},
+				{Name: "PROXY_POD_IP", Value: proxyPodIP},
+				{Name: "SSH_PUBLIC_KEY", Value: publicKey},
+				{Name: "NEEDS_ROOT", Value: fmt.Sprintf("%v", opts.NeedsRoot)},
+			}, opts.Env...),
+			SecurityContext: securityContext,
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      volumeName,
+					MountPath: opts.remoteMountPath(),
+					ReadOnly:  opts.ReadOnly,
+				},
+			},
+		},
+	}
+}
+
+func getPodIP(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) (string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod IP: %v", err)
+	}
+	return pod.Status.PodIP, nil
+}
+
+// checkPVAccessMode returns the access mode that decides how the PVC is exposed and,
+// for single-node access modes, the pod currently using the PVC if any.
+func checkPVAccessMode(ctx context.Context, clientset kubernetes.Interface, pvc *corev1.PersistentVolumeClaim, namespace string, retries int) (corev1.PersistentVolumeAccessMode, string, error) {
+	pvName := pvc.Spec.VolumeName
+	var pv *corev1.PersistentVolume
+	err := retryAPICall(retries, func() (err error) {
+		pv, err = clientset.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get PV: %v", err)
+	}
+
+	accessMode, err := classifyAccessMode(pv.Spec.AccessModes)
+	if err != nil {
+		return "", "", fmt.Errorf("PV %s: %v", pvName, err)
+	}
+
+	if accessMode == corev1.ReadWriteOnce || accessMode == corev1.ReadWriteOncePod {
+		var podList *corev1.PodList
+		err := retryAPICall(retries, func() (err error) {
+			podList, err = clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+			return err
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to list pods: %v", err)
+		}
+		for _, pod := range podList.Items {
+			for _, volume := range pod.Spec.Volumes {
+				if podVolumeClaimName(&pod, volume) == pvc.Name {
+					return accessMode, pod.Name, nil
+				}
+			}
+		}
+	}
+	return accessMode, "", nil
+}
+
+// classifyAccessMode picks the access mode of a PV that decides how it can be exposed.
+// A PV may list several modes, the most permissive one wins except for ReadWriteOncePod,
+// which can't be combined with other modes anyway.
+func classifyAccessMode(modes []corev1.PersistentVolumeAccessMode) (corev1.PersistentVolumeAccessMode, error) {
+	for _, mode := range []corev1.PersistentVolumeAccessMode{
+		corev1.ReadWriteMany,
+		corev1.ReadWriteOncePod,
+		corev1.ReadWriteOnce,
+		corev1.ReadOnlyMany,
+	} {
+		if contains(modes, mode) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("no supported access mode found in %v", modes)
+}
+
+func contains(modes []corev1.PersistentVolumeAccessMode, modeToFind corev1.PersistentVolumeAccessMode) bool {
+	for _, mode := range modes {
+		if mode == modeToFind {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPVCUsage(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string, retries int) (*corev1.PersistentVolumeClaim, error) {
+	var pvc *corev1.PersistentVolumeClaim
+	err := retryAPICall(retries, func() (err error) {
+		pvc, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %w", ErrPVCNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PVC: %w", err)
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return nil, fmt.Errorf("%w: %s", ErrPVCNotBound, pvcName)
+	}
+	return pvc, nil
+}
+
+// podNameAttempts is how many generated names setupPod tries before giving up on pods of the
+// same name that already exist.
+const podNameAttempts = 3
+
+func setupPod(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, localMountPoint, publicKey, role string, sshPort int, originalPodName string, opts MountOptions) (string, int, error) {
+	podName, port := generatePodNameAndPort(role, opts.PodNamePrefix)
+	pod := createPodSpec(podName, port, pvcName, localMountPoint, publicKey, role, sshPort, originalPodName, opts)
+	if opts.DryRun {
+		pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		pod.Namespace = namespace
+		fmt.Printf("# Pod that would be created in namespace %s\n", namespace)
+		return podName, port, printYAML(pod)
+	}
+	for attempt := 1; ; attempt++ {
+		err := retryAPICall(opts.APIRetries, func() error {
+			_, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) && createdByRetry(ctx, clientset, namespace, pod) {
+				// An earlier attempt created the pod, but its response got lost
+				return nil
+			}
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) && attempt < podNameAttempts {
+			fmt.Printf("Pod %s already exists, retrying with another name\n", podName)
+			podName, port = generatePodNameAndPort(role, opts.PodNamePrefix)
+			pod = createPodSpec(podName, port, pvcName, localMountPoint, publicKey, role, sshPort, originalPodName, opts)
+			continue
+		}
+		if err != nil {
+			if podSecurityErr := podSecurityError(err, opts); podSecurityErr != nil {
+				return "", 0, podSecurityErr
+			}
+			return "", 0, fmt.Errorf("failed to create pod: %v", err)
+		}
+		fmt.Printf("Pod %s created successfully\n", podName)
+		return podName, port, nil
+	}
+}
+
+// createdByRetry reports whether the existing pod of the same name is the one being created,
+// recognized by its public key, which is generated for every mount.
+func createdByRetry(ctx context.Context, clientset kubernetes.Interface, namespace string, pod *corev1.Pod) bool {
+	existing, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil || len(existing.Spec.Containers) == 0 || len(pod.Spec.Containers) == 0 {
+		return false
+	}
+	return envValue(existing.Spec.Containers[0].Env, "SSH_PUBLIC_KEY") == envValue(pod.Spec.Containers[0].Env, "SSH_PUBLIC_KEY")
+}
+
+func envValue(env []corev1.EnvVar, name string) string {
+	for _, envVar := range env {
+		if envVar.Name == name {
+			return envVar.Value
+		}
+	}
+	return ""
+}
+
+// podSecurityError explains a rejection by Pod Security admission, returning nil for any
+// other error. Namespaces enforcing the restricted standard reject --needs-root outright.
+func podSecurityError(err error, opts MountOptions) error {
+	if !apierrors.IsForbidden(err) || !strings.Contains(err.Error(), "violates PodSecurity") {
+		return nil
+	}
+	if opts.NeedsRoot {
+		return fmt.Errorf("%w: %v\n--needs-root runs SSHFS as root with SYS_ADMIN, which requires the privileged Pod Security Standard in the namespace; mount without --needs-root to run as non-root", ErrPodSecurity, err)
+	}
+	return fmt.Errorf("%w: %v\ncheck that --seccomp-profile and --apparmor-profile are allowed by the Pod Security Standard of the namespace", ErrPodSecurity, err)
+}
+
+// podReadyBackoff spaces the readiness checks of a pod. They start fast, since most pods are
+// ready within seconds, and back off up to the cap, so many concurrent mounts don't keep the
+// API server busy. The checks go on at the cap once it's reached.
+var podReadyBackoff = wait.Backoff{
+	Duration: 250 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.2,
+	Steps:    10,
+	Cap:      5 * time.Second,
+}
+
+// waitForPodReady waits for the pod to become ready. It fails early once the image failed to
+// pull for longer than pullTimeout, unless that's zero. If events is set, the events of the pod
+// are printed as they occur meanwhile.
+func waitForPodReady(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, timeout, pullTimeout time.Duration, events *podEventReporter) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastPod *corev1.Pod
+	var pullFailingSince time.Time
+	err := podReadyBackoff.DelayFunc().Until(waitCtx, true, false, func(ctx context.Context) (bool, error) {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		lastPod = pod
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		events.reportChanged(ctx, pod)
+
+		failure := imagePullFailure(pod)
+		if failure == "" || pullTimeout <= 0 {
+			pullFailingSince = time.Time{}
+		} else if pullFailingSince.IsZero() {
+			pullFailingSince = clock()
+		} else if clock().Sub(pullFailingSince) >= pullTimeout {
+			return false, fmt.Errorf("%w: pod %s failed to pull for %s, %s", ErrImagePull, podName, pullTimeout, failure)
+		}
+		return false, nil
+	})
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted while waiting for pod %s to become ready: %w", podName, ctx.Err())
+	}
+	if wait.Interrupted(err) {
+		description := describePodStatus(lastPod)
+		if events := describePodEvents(ctx, clientset, namespace, podName); events != "" {
+			description += ", " + events
+		}
+		return fmt.Errorf("pod %s not ready in time (%s): %s", podName, timeout, description)
+	}
+	return err
+}
+
+// imagePullFailure describes why an image of the pod fails to pull, or returns an empty
+// string if none does. The kubelet keeps retrying, but typos in image names never resolve.
+func imagePullFailure(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		waiting := status.State.Waiting
+		if waiting == nil || (waiting.Reason != "ErrImagePull" && waiting.Reason != "ImagePullBackOff") {
+			continue
+		}
+		image := status.Image
+		if image == "" {
+			for _, container := range pod.Spec.Containers {
+				if container.Name == status.Name {
+					image = container.Image
+				}
+			}
+		}
+		failure := fmt.Sprintf("image %s of container %s is in %s", image, status.Name, waiting.Reason)
+		if waiting.Message != "" {
+			failure += ": " + waiting.Message
+		}
+		return failure
+	}
+	return ""
+}
+
+// describePodStatus summarizes the phase, container states and conditions of a pod for error messages,
+// so users can tell e.g. a failed image pull from an unschedulable pod.
+func describePodStatus(pod *corev1.Pod) string {
+	if pod == nil {
+		return "no pod status observed"
+	}
+	description := fmt.Sprintf("phase %s", pod.Status.Phase)
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && waiting.Reason != "" {
+			description += fmt.Sprintf(", container %s stuck in %s", status.Name, waiting.Reason)
+			if waiting.Message != "" {
+				description += fmt.Sprintf(": %s", waiting.Message)
+			}
+		}
+		if terminated := status.State.Terminated; terminated != nil {
+			description += fmt.Sprintf(", container %s terminated with exit code %d (%s)", status.Name, terminated.ExitCode, terminated.Reason)
+		}
+	}
+	var conditions []string
+	for _, cond := range pod.Status.Conditions {
+		condition := fmt.Sprintf("%s=%s", cond.Type, cond.Status)
+		if cond.Reason != "" {
+			condition += fmt.Sprintf(" (%s)", cond.Reason)
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) > 0 {
+		description += ", conditions: " + strings.Join(conditions, ", ")
+	}
+	return description
+}
+
+// describePodEvents returns the warning events of a pod, or an empty string if there are none
+// or they can't be listed.
+func describePodEvents(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) string {
+	eventList, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.name=%s", podName),
+	})
+	if err != nil {
+		return ""
+	}
+	var events []string
+	for _, event := range eventList.Items {
+		if event.InvolvedObject.Name == podName && event.Type == corev1.EventTypeWarning {
+			events = append(events, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+		}
+	}
+	if len(events) == 0 {
+		return ""
+	}
+	return "events: " + strings.Join(events, "; ")
+}
+
+// podEventReporter prints the events of a pod once each, so users waiting for the pod see
+// scheduling and image pull problems as they occur instead of only after the timeout.
+type podEventReporter struct {
+	clientset kubernetes.Interface
+	namespace string
+	podName   string
+	// all reports normal events like Scheduled and Pulling too, not only warnings.
+	all  bool
+	seen map[string]bool
+	// lastStatus and lastReport are when events were last listed, see reportChanged.
+	lastStatus string
+	lastReport time.Time
+}
+
+// podEventRecheckInterval is how often events are listed while the status of the pod doesn't
+// change, for events like repeated FailedScheduling that don't show in the status.
+const podEventRecheckInterval = 15 * time.Second
+
+func newPodEventReporter(clientset kubernetes.Interface, namespace, podName string, all bool) *podEventReporter {
+	return &podEventReporter{clientset: clientset, namespace: namespace, podName: podName, all: all, seen: map[string]bool{}}
+}
+
+// reportChanged reports the events of the pod only if its status changed since the last
+// time, or podEventRecheckInterval passed. Listing events on every readiness check would
+// undo the backoff of the checks.
+func (r *podEventReporter) reportChanged(ctx context.Context, pod *corev1.Pod) {
+	if r == nil {
+		return
+	}
+	status := describePodStatus(pod)
+	now := clock()
+	if status == r.lastStatus && now.Sub(r.lastReport) < podEventRecheckInterval {
+		return
+	}
+	r.lastStatus, r.lastReport = status, now
+	r.report(ctx)
+}
+
+// report prints the events that weren't seen before. Failing to list events only means
+// less feedback, so errors are ignored. It's a no-op on a nil reporter.
+func (r *podEventReporter) report(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	eventList, err := r.clientset.CoreV1().Events(r.namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.name=%s", r.podName),
+	})
+	if err != nil {
+		return
+	}
+	for _, event := range eventList.Items {
+		if event.InvolvedObject.Name != r.podName || r.seen[event.Name] {
+			continue
+		}
+		r.seen[event.Name] = true
+		if event.Type == corev1.EventTypeWarning {
+			fmt.Printf("Warning: pod %s: %s: %s\n", r.podName, event.Reason, event.Message)
+		} else if r.all {
+			fmt.Printf("Pod %s: %s: %s\n", r.podName, event.Reason, event.Message)
+		}
+	}
+}
+
+// remoteForwardPort returns the pod port the local port-forward targets. Proxy pods run their
+// own SSH server on sshPort only for the ephemeral container to connect to, the tunnel back
+// to the ephemeral container's SSH server ends on DefaultSSHPort of the proxy pod.
+func remoteForwardPort(role string, sshPort int) int {
+	if role == "proxy" {
+		return DefaultSSHPort
+	}
+	return sshPort
+}
+
+// printTunnel prints every hop of the tunnel of an RWO mount, so it can be reproduced by hand.
+// The keys are left out, they are in the environment of the ephemeral container.
+func printTunnel(workloadPodName, containerName, proxyPodName, proxyPodIP, host string, port, remotePort int, opts MountOptions) {
+	fmt.Printf("Tunnel of the mount:\n")
+	fmt.Printf("  ephemeral container %s in pod %s: SSH server on port %d\n", containerName, workloadPodName, DefaultSSHPort)
+	fmt.Printf("  ephemeral container -> proxy pod %s (PROXY_POD_IP=%s): ssh -N -R %d:localhost:%d %s@%s -p %d\n",
+		proxyPodName, proxyPodIP, remotePort, DefaultSSHPort, sshUserFor(opts.NeedsRoot), proxyPodIP, ProxySSHPort)
+	if opts.Via == ViaService {
+		fmt.Printf("  local -> proxy pod: service %s at %s:%d -> port %d\n", proxyPodName, host, port, remotePort)
+	} else {
+		fmt.Printf("  local -> proxy pod: kubectl port-forward %s:%d -> port %d\n", host, port, remotePort)
+	}
+}
+
+func setupPortForwarding(namespace, podName string, port, remotePort int, address string) (*exec.Cmd, error) {
+	if !isLoopbackAddress(address) {
+		fmt.Printf("Warning: the port-forward binds to %s, so the SSH server of the pod is reachable from other hosts\n", address)
+	}
+	cmd := buildPortForwardCommand(namespace, podName, port, remotePort, address)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runner.Start(cmd); err != nil {
+		return nil, fmt.Errorf("failed to start port-forward: %v", err)
 	}
-	return nil
+	time.Sleep(portForwardSettleTime) // Wait a bit for the port forwarding to establish
+	return cmd, nil
 }
 
-func handleRWX(ctx context.Context, clientset *kubernetes.Clientset, namespace, pvcName, localMountPoint string, needsRoot bool, debug bool) error {
-
-	privateKey, publicKey, err := GenerateKeyPair(elliptic.P256())
-	if err != nil {
-		return fmt.Errorf("error generating key pair: %v", err)
+// buildPortForwardCommand builds the kubectl port-forward of the pod. The address goes last,
+// so portForwardPattern matches the command line whatever it binds to.
+func buildPortForwardCommand(namespace, podName string, port, remotePort int, address string) *exec.Cmd {
+	args := []string{"port-forward", fmt.Sprintf("pod/%s", podName), fmt.Sprintf("%d:%d", port, remotePort), "-n", namespace}
+	if address != "" {
+		args = append(args, "--address", address)
 	}
+	return exec.Command("kubectl", args...)
+}
 
-	if debug {
-		fmt.Printf("Private Key:\n%s\n", privateKey)
+// isLoopbackAddress reports whether the port-forward address is only reachable from this host.
+func isLoopbackAddress(address string) bool {
+	if address == "" || address == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
 
-	podName, port, err := setupPod(ctx, clientset, namespace, pvcName, publicKey, "standalone", DefaultSSHPort, "", needsRoot)
-	if err != nil {
-		return err
+// forwardHost returns where SSHFS connects to for a port-forward bound to the address.
+// A port-forward bound to all addresses is reached on localhost as well.
+func forwardHost(address string) string {
+	if address == "" {
+		return "localhost"
 	}
-
-	if err := waitForPodReady(ctx, clientset, namespace, podName); err != nil {
-		return err
+	if ip := net.ParseIP(address); ip != nil && ip.IsUnspecified() {
+		return "localhost"
 	}
+	return address
+}
 
-	if err := setupPortForwarding(namespace, podName, port); err != nil {
-		return err
+// printDryRunCommands prints the local commands a real mount would run.
+func printDryRunCommands(namespace, podName, localMountPoint string, port, remotePort int, opts MountOptions) error {
+	if opts.Via == ViaService {
+		fmt.Printf("# Service %s of type %s that would expose port %d of the pod\n", podName, opts.serviceType(), remotePort)
+		opts.sshfsHost = "<service-address>"
+	} else {
+		fmt.Println("# Port-forward command")
+		fmt.Println(strings.Join(buildPortForwardCommand(namespace, podName, port, remotePort, opts.Address).Args, " "))
+		opts.sshfsHost = forwardHost(opts.Address)
 	}
-
-	return mountPVCOverSSH(port, localMountPoint, pvcName, privateKey, needsRoot)
+	fmt.Println("# Mount command")
+	fmt.Println(strings.Join(buildSSHFSCommand("<temporary-key-file>", localMountPoint, port, opts).Args, " "))
+	return nil
 }
 
-func handleRWO(ctx context.Context, clientset *kubernetes.Clientset, namespace, pvcName, localMountPoint string, podUsingPVC string, needsRoot bool, debug bool) error {
-
-	privateKey, publicKey, err := GenerateKeyPair(elliptic.P256())
+func printYAML(obj interface{}) error {
+	out, err := yaml.Marshal(obj)
 	if err != nil {
-		return fmt.Errorf("error generating key pair: %v", err)
+		return fmt.Errorf("failed to marshal to YAML: %v", err)
 	}
+	fmt.Printf("---\n%s", out)
+	return nil
+}
 
-	if debug {
-		fmt.Printf("Private Key:\n%s\n", privateKey)
+func sshUserFor(needsRoot bool) string {
+	if needsRoot {
+		return "root"
 	}
+	return "ve"
+}
+
+func mountPVCOverSSH(
+	port int,
+	localMountPoint, pvcName, privateKey string,
+	opts MountOptions) error {
 
-	podName, port, err := setupPod(ctx, clientset, namespace, pvcName, publicKey, "proxy", ProxySSHPort, podUsingPVC, needsRoot)
+	keyFile, keep, err := writeMountKey(localMountPoint, privateKey, &opts)
 	if err != nil {
 		return err
 	}
+	// SSHFS daemonizes, the key is only kept if it reconnects, until clean removes it
+	mounted := false
+	defer func() {
+		if !keep || !mounted {
+			os.Remove(keyFile)
+		}
+	}()
 
-	if err := waitForPodReady(ctx, clientset, namespace, podName); err != nil {
-		return err
-	}
+	sshfsCmd := buildSSHFSCommand(keyFile, localMountPoint, port, opts)
+	sshfsCmd.Stdout = os.Stdout
+	sshfsCmd.Stderr = os.Stderr
 
-	proxyPodIP, err := getPodIP(ctx, clientset, namespace, podName)
-	if err != nil {
-		return err
+	if err := runner.Run(sshfsCmd); err != nil {
+		return fmt.Errorf("failed to mount PVC using SSHFS: %v", err)
 	}
 
-	if err := createEphemeralContainer(ctx, clientset, namespace, podUsingPVC, privateKey, publicKey, proxyPodIP, needsRoot); err != nil {
+	if err := verifyMount(runtime.GOOS, localMountPoint); err != nil {
 		return err
 	}
+	mounted = true
 
-	if err := setupPortForwarding(namespace, podName, port); err != nil {
-		return err
+	if opts.ChownMountPoint {
+		uid, gid := opts.localOwner()
+		if err := chownMountPoint(localMountPoint, uid, gid); err != nil {
+			return err
+		}
 	}
 
-	return mountPVCOverSSH(port, localMountPoint, pvcName, privateKey, needsRoot)
+	fmt.Printf("PVC %s mounted successfully to %s\n", pvcName, localMountPoint)
+	return nil
 }
 
-func createEphemeralContainer(ctx context.Context, clientset *kubernetes.Clientset, namespace, podName, privateKey, publicKey, proxyPodIP string, needsRoot bool) error {
-	// Retrieve the existing pod to get the volume name
-	existingPod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get existing pod: %v", err)
-	}
-
-	volumeName, err := getPVCVolumeName(existingPod)
-	if err != nil {
-		return err
+// readMountTable returns the mounted filesystems in the format of /proc/mounts on Linux
+// and of the mount command on macOS.
+var readMountTable = func(goos string) (string, error) {
+	if goos == "darwin" {
+		out, err := exec.Command("mount").Output()
+		return string(out), err
 	}
+	out, err := os.ReadFile("/proc/mounts")
+	return string(out), err
+}
 
-	ephemeralContainerName := fmt.Sprintf("volume-exposer-ephemeral-%s", randSeq(5))
-	fmt.Printf("Adding ephemeral container %s to pod %s with volume name %s\n", ephemeralContainerName, podName, volumeName)
-
-	image, securityContext := getEphemeralContainerSettings(needsRoot)
-
-	ephemeralContainer := corev1.EphemeralContainer{
-		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-			Name:            ephemeralContainerName,
-			Image:           image,
-			ImagePullPolicy: corev1.PullAlways,
-			Env: []corev1.EnvVar{
-				{Name: "ROLE", Value: "ephemeral"},
-				{Name: "SSH_PRIVATE_KEY", Value: privateKey},
-				{Name: "PROXY_POD_IP", Value: proxyPodIP},
-				{Name: "SSH_PUBLIC_KEY", Value: publicKey},
-				{Name: "NEEDS_ROOT", Value: fmt.Sprintf("%v", needsRoot)},
-			},
-			SecurityContext: securityContext,
-			VolumeMounts: []corev1.VolumeMount{
-				{
-					Name:      volumeName,
-					MountPath: "/volume",
-				},
-			},
-		},
+// verifyMount checks that SSHFS really left a FUSE mount behind. SSHFS daemonizes once it
+// connected, so its exit status alone doesn't tell whether the mount point is usable.
+func verifyMount(goos, localMountPoint string) error {
+	if _, err := os.Stat(localMountPoint); err != nil {
+		return fmt.Errorf("failed to verify mount: %v", err)
 	}
-
-	patchData, err := json.Marshal(map[string]interface{}{
-		"spec": map[string]interface{}{
-			"ephemeralContainers": []corev1.EphemeralContainer{ephemeralContainer},
-		},
-	})
+	table, err := readMountTable(goos)
 	if err != nil {
-		return fmt.Errorf("failed to marshal ephemeral container spec: %v", err)
+		return fmt.Errorf("failed to read mount table: %v", err)
 	}
-
-	_, err = clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.StrategicMergePatchType, patchData, metav1.PatchOptions{}, "ephemeralcontainers")
-	if err != nil {
-		return fmt.Errorf("failed to patch pod with ephemeral container: %v", err)
+	// Mount tables list resolved paths, e.g. /private/tmp instead of /tmp on macOS
+	mountPoint := absMountPoint(localMountPoint)
+	if resolved, err := filepath.EvalSymlinks(mountPoint); err == nil {
+		mountPoint = resolved
+	}
+	if !isFUSEMount(goos, table, mountPoint) {
+		return fmt.Errorf("failed to verify mount: %s is not a FUSE mount point", localMountPoint)
 	}
-
-	fmt.Printf("Successfully added ephemeral container %s to pod %s\n", ephemeralContainerName, podName)
 	return nil
 }
 
-func getPodIP(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) (string, error) {
-	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+// isMounted reports whether the mount table lists the mount point as a FUSE mount. Unlike
+// verifyMount it doesn't stat the mount point, which fails with ENOTCONN once the SSHFS
+// connection of the mount is gone.
+func isMounted(goos, localMountPoint string) (bool, error) {
+	table, err := readMountTable(goos)
 	if err != nil {
-		return "", fmt.Errorf("failed to get pod IP: %v", err)
+		return false, fmt.Errorf("failed to read mount table: %v", err)
 	}
-	return pod.Status.PodIP, nil
+	// Only the parent can be resolved, the mount point itself may be dead
+	mountPoint := absMountPoint(localMountPoint)
+	if parent, err := filepath.EvalSymlinks(filepath.Dir(mountPoint)); err == nil {
+		mountPoint = filepath.Join(parent, filepath.Base(mountPoint))
+	}
+	return isFUSEMount(goos, table, mountPoint), nil
 }
 
-func checkPVAccessMode(ctx context.Context, clientset *kubernetes.Clientset, pvc *corev1.PersistentVolumeClaim, namespace string) (bool, string, error) {
-	pvName := pvc.Spec.VolumeName
-	pv, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
-	if err != nil {
-		return true, "", fmt.Errorf("failed to get PV: %v", err)
+// chownMountPoint changes the owner of the mount point, but not of the files below it.
+func chownMountPoint(localMountPoint string, uid, gid int) error {
+	if err := os.Chown(localMountPoint, uid, gid); err != nil {
+		return fmt.Errorf("failed to change owner of mount point %s to %d:%d: %v", localMountPoint, uid, gid, err)
 	}
+	return nil
+}
 
-	if contains(pv.Spec.AccessModes, corev1.ReadWriteOnce) {
-		podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return true, "", fmt.Errorf("failed to list pods: %v", err)
-		}
-		for _, pod := range podList.Items {
-			for _, volume := range pod.Spec.Volumes {
-				if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
-					return false, pod.Name, nil
-				}
+// mountPathUnescaper reverts the octal escapes used for whitespace and backslashes in /proc/mounts.
+var mountPathUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// isFUSEMount reports whether the mount table lists the mount point as a FUSE mount.
+func isFUSEMount(goos, table, mountPoint string) bool {
+	for _, line := range strings.Split(table, "\n") {
+		var source, target, fsType string
+		if goos == "darwin" {
+			// <source> on <target> (<type>, <options>...)
+			on := strings.Index(line, " on ")
+			paren := strings.LastIndex(line, " (")
+			if on < 0 || paren < on {
+				continue
 			}
+			source, target = line[:on], line[on+len(" on "):paren]
+			fsType, _, _ = strings.Cut(strings.TrimSuffix(line[paren+len(" ("):], ")"), ",")
+		} else {
+			// <source> <target> <type> <options> <dump> <pass>
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			source, target, fsType = fields[0], mountPathUnescaper.Replace(fields[1]), fields[2]
 		}
-	}
-	return true, "", nil
-}
-
-func contains(modes []corev1.PersistentVolumeAccessMode, modeToFind corev1.PersistentVolumeAccessMode) bool {
-	for _, mode := range modes {
-		if mode == modeToFind {
+		if target != mountPoint {
+			continue
+		}
+		// FUSE-T on macOS serves FUSE filesystems over NFS, so its mounts only show up by their source
+		if strings.Contains(fsType, "fuse") || isSSHFSSource(source) {
 			return true
 		}
 	}
 	return false
 }
 
-func checkPVCUsage(ctx context.Context, clientset *kubernetes.Clientset, namespace, pvcName string) (*corev1.PersistentVolumeClaim, error) {
-	pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get PVC: %v", err)
+// isSSHFSSource reports whether the source of a mount is the volume of an exposer pod,
+// reached through a port-forward on localhost or through a Service.
+func isSSHFSSource(source string) bool {
+	user, hostPath, found := strings.Cut(source, "@")
+	if !found || (user != sshUserFor(false) && user != sshUserFor(true)) {
+		return false
 	}
-	if pvc.Status.Phase != corev1.ClaimBound {
-		return nil, fmt.Errorf("PVC %s is not bound", pvcName)
+	if strings.HasPrefix(hostPath, "[") {
+		// Bracketed IPv6 address
+		_, hostPath, _ = strings.Cut(hostPath, "]")
 	}
-	return pvc, nil
+	_, remotePath, found := strings.Cut(hostPath, ":")
+	return found && path.IsAbs(remotePath)
 }
 
-func setupPod(ctx context.Context, clientset *kubernetes.Clientset, namespace, pvcName, publicKey, role string, sshPort int, originalPodName string, needsRoot bool) (string, int, error) {
-	podName, port := generatePodNameAndPort(role)
-	pod := createPodSpec(podName, port, pvcName, publicKey, role, sshPort, originalPodName, needsRoot)
-	if _, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
-		return "", 0, fmt.Errorf("failed to create pod: %v", err)
+// startSSHFS mounts the PVC with SSHFS kept in the foreground, so the mount lives as long
+// as the returned process instead of a daemon. It returns once the volume is mounted.
+func startSSHFS(port int, localMountPoint, pvcName, privateKey string, opts MountOptions) (*backgroundCommand, error) {
+	keyFile, err := writePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("Pod %s created successfully\n", podName)
-	return podName, port, nil
-}
 
-func waitForPodReady(ctx context.Context, clientset *kubernetes.Clientset, namespace, podName string) error {
-	return wait.PollUntilContextTimeout(ctx, time.Second, 5*time.Minute, true, func(ctx context.Context) (bool, error) {
-		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		for _, cond := range pod.Status.Conditions {
-			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
-				return true, nil
-			}
+	sshfsCmd := buildSSHFSCommand(keyFile, localMountPoint, port, opts)
+	sshfsCmd.Args = append(sshfsCmd.Args, "-f")
+	sshfsCmd.Stdout = os.Stdout
+	sshfsCmd.Stderr = os.Stderr
+
+	// SSHFS needs the key again whenever it reconnects, so it's kept until SSHFS exits
+	sshfs, err := startBackground(sshfsCmd, func() { os.Remove(keyFile) })
+	if err != nil {
+		os.Remove(keyFile)
+		return nil, fmt.Errorf("failed to start SSHFS: %v", err)
+	}
+
+	if err := waitForMount(sshfs, localMountPoint); err != nil {
+		sshfs.stop()
+		return nil, err
+	}
+
+	if opts.ChownMountPoint {
+		uid, gid := opts.localOwner()
+		if err := chownMountPoint(localMountPoint, uid, gid); err != nil {
+			_, _ = unmountLocal(localMountPoint, false)
+			sshfs.stop()
+			return nil, err
 		}
-		return false, nil
-	})
+	}
+
+	fmt.Printf("PVC %s mounted successfully to %s in the background\n", pvcName, localMountPoint)
+	return sshfs, nil
 }
 
-func setupPortForwarding(namespace, podName string, port int) error {
-	cmd := exec.Command("kubectl", "port-forward", fmt.Sprintf("pod/%s", podName), fmt.Sprintf("%d:%d", port, DefaultSSHPort), "-n", namespace)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start port-forward: %v", err)
+// keepPrivateKey writes the private key to the file of KeepKey and prints how to ssh into the pod with it.
+func keepPrivateKey(privateKey string, port int, opts MountOptions) error {
+	if err := os.WriteFile(opts.KeepKey, []byte(privateKey), 0o600); err != nil {
+		return fmt.Errorf("failed to write private key to %s: %v", opts.KeepKey, err)
+	}
+	// WriteFile keeps the permissions of an existing file
+	if err := os.Chmod(opts.KeepKey, 0o600); err != nil {
+		return fmt.Errorf("failed to restrict permissions of %s: %v", opts.KeepKey, err)
 	}
-	time.Sleep(5 * time.Second) // Wait a bit for the port forwarding to establish
+
+	fmt.Printf("Private key written to %s, connect to the pod with:\n", opts.KeepKey)
+	fmt.Println(strings.Join(buildSSHCommand(opts.KeepKey, port, opts).Args, " "))
 	return nil
 }
 
-func mountPVCOverSSH(
-	port int,
-	localMountPoint, pvcName, privateKey string,
-	needsRoot bool) error {
+// buildSSHCommand returns the ssh command reaching the pod the way SSHFS does.
+func buildSSHCommand(keyFile string, port int, opts MountOptions) *exec.Cmd {
+	return exec.Command("ssh",
+		"-i", keyFile,
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-p", fmt.Sprintf("%d", port),
+		fmt.Sprintf("%s@%s", sshUserFor(opts.NeedsRoot), opts.host()),
+	)
+}
 
-	// Create a temporary file to store the private key
+// writePrivateKey stores the private key in a temporary file for SSHFS. The caller removes it.
+func writePrivateKey(privateKey string) (string, error) {
 	tmpFile, err := os.CreateTemp("", "ssh_key_*.pem")
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file for SSH private key: %v", err)
+		return "", fmt.Errorf("failed to create temporary file for SSH private key: %v", err)
 	}
-	defer os.Remove(tmpFile.Name())
 
 	if _, err := tmpFile.Write([]byte(privateKey)); err != nil {
-		return fmt.Errorf("failed to write SSH private key to temporary file: %v", err)
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write SSH private key to temporary file: %v", err)
 	}
 	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary file: %v", err)
-	}
-
-	sshUser := "ve"
-	if needsRoot {
-		sshUser = "root"
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to close temporary file: %v", err)
 	}
+	return tmpFile.Name(), nil
+}
 
-	sshfsCmd := exec.Command(
-		"sshfs",
-		"-o", fmt.Sprintf("IdentityFile=%s", tmpFile.Name()),
+func buildSSHFSCommand(keyFile, localMountPoint string, port int, opts MountOptions) *exec.Cmd {
+	args := []string{
+		"-o", fmt.Sprintf("IdentityFile=%s", keyFile),
 		"-o", "StrictHostKeyChecking=no",
 		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "nomap=ignore",
-		fmt.Sprintf("%s@localhost:/volume", sshUser),
+	}
+	args = append(args, idMapOptions(opts)...)
+	if interval := opts.keepAliveInterval(); interval > 0 {
+		// Port-forwards drop on long-lived mounts, without keep-alives SSHFS then hangs forever
+		args = append(args,
+			"-o", "reconnect",
+			"-o", fmt.Sprintf("ServerAliveInterval=%d", interval),
+			"-o", fmt.Sprintf("ServerAliveCountMax=%d", KeepAliveCountMax),
+		)
+	}
+	if opts.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	if opts.AllowOther {
+		args = append(args, "-o", "allow_other")
+	}
+	if opts.AllowNonEmpty && needsNonEmptyOption(sshfsVersion(opts.sshfsPath())) {
+		args = append(args, "-o", "nonempty")
+	}
+	if opts.Compression {
+		args = append(args, "-o", "Compression=yes")
+	}
+	for _, option := range opts.SSHFSOptions {
+		args = append(args, "-o", option)
+	}
+	args = append(args,
+		opts.remoteTarget(opts.remoteMountPath()),
 		localMountPoint,
 		"-p", fmt.Sprintf("%d", port),
 	)
+	return exec.Command(opts.sshfsPath(), args...)
+}
 
-	sshfsCmd.Stdout = os.Stdout
-	sshfsCmd.Stderr = os.Stderr
-
-	if err := sshfsCmd.Run(); err != nil {
-		return fmt.Errorf("failed to mount PVC using SSHFS: %v", err)
+// idMapOptions returns the SSHFS options mapping the owners of the files, see IDMapMode.
+func idMapOptions(opts MountOptions) []string {
+	// Files are owned by the user of the pod, show them as owned by the local user instead
+	uid, gid := opts.localOwner()
+	owner := fmt.Sprintf("idmap=user,uid=%d,gid=%d", uid, gid)
+	switch opts.IDMapMode {
+	case IDMapNone:
+		return []string{"-o", "idmap=none"}
+	case IDMapUser:
+		return []string{"-o", owner}
+	case IDMapIgnore:
+		if opts.UID == nil && opts.GID == nil {
+			return []string{"-o", "nomap=ignore"}
+		}
 	}
+	return []string{"-o", "nomap=ignore", "-o", owner}
+}
 
-	fmt.Printf("PVC %s mounted successfully to %s\n", pvcName, localMountPoint)
+// validateIDMapMode checks the mode mapping the owners of the files against the local owner.
+func validateIDMapMode(opts MountOptions) error {
+	switch opts.IDMapMode {
+	case "", IDMapIgnore, IDMapUser:
+	case IDMapNone:
+		if opts.UID != nil || opts.GID != nil {
+			return fmt.Errorf("--idmap-mode %s shows the owners of the pod, it can't be used with --uid or --gid", IDMapNone)
+		}
+	default:
+		return fmt.Errorf("invalid --idmap-mode %s, must be %s, %s or %s", opts.IDMapMode, IDMapIgnore, IDMapNone, IDMapUser)
+	}
 	return nil
 }
 
-func generatePodNameAndPort(role string) (string, int) {
+func generatePodNameAndPort(role, prefix string) (string, int) {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	suffix := randSeq(5)
-	baseName := "volume-exposer"
+	if prefix == "" {
+		prefix = DefaultPodNamePrefix
+	}
+	baseName := prefix
 	if role == "proxy" {
-		baseName = "volume-exposer-proxy"
+		baseName = prefix + "-proxy"
 	}
 	podName := fmt.Sprintf("%s-%s", baseName, suffix)
 	port := r.Intn(64511) + 1024 // Use the local random generator
 	return podName, port
 }
 
-func createPodSpec(podName string, port int, pvcName, publicKey, role string, sshPort int, originalPodName string, needsRoot bool) *corev1.Pod {
+func createPodSpec(podName string, port int, pvcName, localMountPoint, publicKey, role string, sshPort int, originalPodName string, opts MountOptions) *corev1.Pod {
+	needsRoot := opts.NeedsRoot
 
 	envVars := []corev1.EnvVar{
 		{Name: "SSH_PUBLIC_KEY", Value: publicKey},
@@ -354,69 +1753,80 @@ func createPodSpec(podName string, port int, pvcName, publicKey, role string, ss
 			Value: role,
 		})
 	}
+	envVars = append(envVars, opts.Env...)
 
-	image, securityContext := getEphemeralContainerSettings(needsRoot)
-
-	runAsNonRoot := !needsRoot
-	runAsUser := int64(DefaultUserGroup)
-	runAsGroup := int64(DefaultUserGroup)
-	if needsRoot {
-		runAsUser = 0
-		runAsGroup = 0
-	}
+	image, securityContext := getEphemeralContainerSettings(opts)
 
 	container := corev1.Container{
 		Name:            "volume-exposer",
 		Image:           image,
-		ImagePullPolicy: corev1.PullAlways,
+		ImagePullPolicy: opts.imagePullPolicy(),
 		Ports: []corev1.ContainerPort{
 			{ContainerPort: int32(sshPort)},
 		},
 		Env:             envVars,
 		SecurityContext: securityContext,
-		Resources: corev1.ResourceRequirements{
-			Requests: corev1.ResourceList{
-				corev1.ResourceCPU:              resource.MustParse(CPURequest),
-				corev1.ResourceMemory:           resource.MustParse(MemoryRequest),
-				corev1.ResourceEphemeralStorage: resource.MustParse(EphemeralStorageRequest),
-			},
-			Limits: corev1.ResourceList{
-				corev1.ResourceMemory:           resource.MustParse(MemoryLimit),
-				corev1.ResourceEphemeralStorage: resource.MustParse(EphemeralStorageLimit),
-			},
-		},
+		Resources:       opts.podResources(),
 	}
 
-	labels := map[string]string{
-		"app":        "volume-exposer",
-		"pvcName":    pvcName,
-		"portNumber": fmt.Sprintf("%d", port),
-	}
+	labels := buildPodLabels(pvcName, localMountPoint, port, remoteForwardPort(role, sshPort), originalPodName)
+	inheritPVCLabels(labels, opts.pvcLabels)
 
-	// Add the original pod name label if provided
-	if originalPodName != "" {
-		labels["originalPodName"] = originalPodName
+	annotations := buildPodAnnotations(localMountPoint, port)
+	if opts.Via == ViaService {
+		// Tells clean to delete the Service instead of stopping a port-forward
+		annotations[ViaAnnotation] = ViaService
+	}
+	if role == "proxy" && opts.ephemeralContainerName != "" {
+		annotations[EphemeralContainerAnnotation] = opts.ephemeralContainerName
+	}
+	if opts.MaxAge > 0 {
+		annotations[MaxAgeAnnotation] = opts.MaxAge.String()
+	}
+	if opts.snapshot != "" {
+		// Tells clean to delete the PVC restored for the mount
+		annotations[SnapshotAnnotation] = opts.snapshot
+	}
+	if opts.annotatedPV != "" {
+		annotations[AnnotatedPVAnnotation] = opts.annotatedPV
+	}
+	if opts.AppArmorProfile != nil {
+		// Clusters older than 1.30 only know the annotation, newer ones require it to match the field
+		annotations[corev1.DeprecatedAppArmorBetaContainerAnnotationKeyPrefix+container.Name] = appArmorAnnotationValue(opts.AppArmorProfile)
 	}
 
 	podSpec := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   podName,
-			Labels: labels,
+			Name:        podName,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{container},
-			SecurityContext: &corev1.PodSecurityContext{
-				RunAsNonRoot: &runAsNonRoot,
-				RunAsUser:    &runAsUser,
-				RunAsGroup:   &runAsGroup,
-			},
+			Containers:      []corev1.Container{container},
+			SecurityContext: buildPodSecurityContext(opts),
+			// The pod never talks to the API server, so it doesn't need a token for it
+			AutomountServiceAccountToken: &opts.AutomountServiceAccountToken,
+			PriorityClassName:            opts.PriorityClass,
+			HostAliases:                  opts.HostAliases,
+			DNSConfig:                    buildDNSConfig(opts),
+			ImagePullSecrets:             buildImagePullSecrets(opts),
 		},
 	}
 
+	if opts.TTL > 0 {
+		// The deadline is in whole seconds and must be positive, so round up
+		deadline := int64((opts.TTL + time.Second - 1) / time.Second)
+		podSpec.Spec.ActiveDeadlineSeconds = &deadline
+	}
+
+	if opts.ownerReference != nil {
+		podSpec.OwnerReferences = []metav1.OwnerReference{*opts.ownerReference}
+	}
+
 	// Only mount the volume if the role is not "proxy"
 	if role != "proxy" {
 		container.VolumeMounts = []corev1.VolumeMount{
-			{MountPath: "/volume", Name: "my-pvc"},
+			{MountPath: opts.remoteMountPath(), Name: "my-pvc", ReadOnly: opts.ReadOnly},
 		}
 		podSpec.Spec.Volumes = []corev1.Volume{
 			{
@@ -424,6 +1834,7 @@ func createPodSpec(podName string, port int, pvcName, publicKey, role string, ss
 				VolumeSource: corev1.VolumeSource{
 					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 						ClaimName: pvcName,
+						ReadOnly:  opts.ReadOnly,
 					},
 				},
 			},
@@ -435,23 +1846,136 @@ func createPodSpec(podName string, port int, pvcName, publicKey, role string, ss
 	return podSpec
 }
 
-func getPVCVolumeName(pod *corev1.Pod) (string, error) {
+func buildPodSecurityContext(opts MountOptions) *corev1.PodSecurityContext {
+	runAsNonRoot := !opts.NeedsRoot
+	runAsUser := int64(DefaultUserGroup)
+	runAsGroup := int64(DefaultUserGroup)
+	if opts.NeedsRoot {
+		runAsUser = 0
+		runAsGroup = 0
+	}
+
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot:        &runAsNonRoot,
+		RunAsUser:           &runAsUser,
+		RunAsGroup:          &runAsGroup,
+		FSGroup:             opts.FSGroup,
+		FSGroupChangePolicy: opts.FSGroupChangePolicy,
+	}
+}
+
+func buildPodLabels(pvcName, localMountPoint string, port, remotePort int, originalPodName string) map[string]string {
+	labels := map[string]string{
+		"app":        "volume-exposer",
+		"pvcName":    pvcName,
+		"portNumber": fmt.Sprintf("%d", port),
+		"sshPort":    fmt.Sprintf("%d", remotePort),
+	}
+	// One-shot pods of cp and sftp have no mount point, clean must never find them by one
+	if localMountPoint != "" {
+		labels["mountPointHash"] = mountPointHash(localMountPoint)
+	}
+
+	// Add the original pod name label if provided
+	if originalPodName != "" {
+		labels["originalPodName"] = originalPodName
+	}
+	return labels
+}
+
+// reservedPodLabels are the labels pv-mounter finds and cleans up its pods by, never inherited
+// from the PVC even if the pod doesn't set them.
+var reservedPodLabels = map[string]bool{
+	"app":             true,
+	"pvcName":         true,
+	"portNumber":      true,
+	"sshPort":         true,
+	"mountPointHash":  true,
+	"originalPodName": true,
+}
+
+// inheritPVCLabels copies the labels of the PVC onto the labels of the pod, except the reserved
+// ones and those of the kubernetes.io and k8s.io domains, which Kubernetes sets itself.
+func inheritPVCLabels(labels, pvcLabels map[string]string) {
+	for key, value := range pvcLabels {
+		if reservedPodLabels[key] || isKubernetesLabel(key) {
+			continue
+		}
+		labels[key] = value
+	}
+}
+
+func isKubernetesLabel(key string) bool {
+	prefix, _, found := strings.Cut(key, "/")
+	if !found {
+		return false
+	}
+	for _, domain := range []string{"kubernetes.io", "k8s.io"} {
+		if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildPodAnnotations(localMountPoint string, port int) map[string]string {
+	annotations := map[string]string{
+		BackendAnnotation:   "sshfs",
+		LocalPortAnnotation: fmt.Sprintf("%d", port),
+	}
+	if localMountPoint != "" {
+		annotations[MountPointAnnotation] = absMountPoint(localMountPoint)
+	}
+	return annotations
+}
+
+// mountPointHash returns a label-safe identifier of a local mount point, so the pod
+// serving a mount can be found from the mount point alone. Paths can't be used as
+// label values directly because of their length and allowed characters.
+func mountPointHash(localMountPoint string) string {
+	sum := sha256.Sum256([]byte(absMountPoint(localMountPoint)))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+func absMountPoint(localMountPoint string) string {
+	if absPath, err := filepath.Abs(localMountPoint); err == nil {
+		return absPath
+	}
+	return localMountPoint
+}
+
+// getPVCVolumeName returns the name of the volume of the pod backed by the PVC. Pods may use
+// several PVCs, the ephemeral container has to mount the one being exposed.
+func getPVCVolumeName(pod *corev1.Pod, pvcName string) (string, error) {
 	for _, volume := range pod.Spec.Volumes {
-		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName != "" {
+		if podVolumeClaimName(pod, volume) == pvcName {
 			return volume.Name, nil
 		}
 	}
-	return "", fmt.Errorf("failed to find volume name in the existing pod")
+	return "", fmt.Errorf("failed to find the volume of PVC %s in pod %s", pvcName, pod.Name)
+}
+
+// podVolumeClaimName returns the name of the PVC backing the volume of the pod, or "" if the
+// volume isn't backed by one. The PVC of a generic ephemeral volume is created by Kubernetes
+// and named <pod>-<volume>.
+func podVolumeClaimName(pod *corev1.Pod, volume corev1.Volume) string {
+	switch {
+	case volume.PersistentVolumeClaim != nil:
+		return volume.PersistentVolumeClaim.ClaimName
+	case volume.Ephemeral != nil:
+		return pod.Name + "-" + volume.Name
+	}
+	return ""
 }
 
-func getEphemeralContainerSettings(needsRoot bool) (string, *corev1.SecurityContext) {
+func getEphemeralContainerSettings(opts MountOptions) (string, *corev1.SecurityContext) {
 	image := Image
 	var securityContext *corev1.SecurityContext
 
 	// Define boolean pointers inline
 	allowPrivilegeEscalationTrue := true
 	allowPrivilegeEscalationFalse := false
-	readOnlyRootFilesystemTrue := true
+	readOnlyRootFilesystem := !opts.AllowWritableRootFS
 	runAsNonRootTrue := true
 
 	// Define seccomp profile type
@@ -459,11 +1983,11 @@ func getEphemeralContainerSettings(needsRoot bool) (string, *corev1.SecurityCont
 		Type: corev1.SeccompProfileTypeRuntimeDefault,
 	}
 
-	if needsRoot {
+	if opts.NeedsRoot {
 		image = PrivilegedImage
 		securityContext = &corev1.SecurityContext{
 			AllowPrivilegeEscalation: &allowPrivilegeEscalationTrue,
-			ReadOnlyRootFilesystem:   &readOnlyRootFilesystemTrue,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
 			Capabilities: &corev1.Capabilities{
 				Add: []corev1.Capability{"SYS_ADMIN", "SYS_CHROOT"},
 			},
@@ -472,7 +1996,7 @@ func getEphemeralContainerSettings(needsRoot bool) (string, *corev1.SecurityCont
 	} else {
 		securityContext = &corev1.SecurityContext{
 			AllowPrivilegeEscalation: &allowPrivilegeEscalationFalse,
-			ReadOnlyRootFilesystem:   &readOnlyRootFilesystemTrue,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
 			Capabilities: &corev1.Capabilities{
 				Drop: []corev1.Capability{"ALL"},
 			},
@@ -482,5 +2006,83 @@ func getEphemeralContainerSettings(needsRoot bool) (string, *corev1.SecurityCont
 			RunAsNonRoot:   &runAsNonRootTrue,
 		}
 	}
+	if opts.SeccompProfile != nil {
+		securityContext.SeccompProfile = opts.SeccompProfile
+	}
+	securityContext.AppArmorProfile = opts.AppArmorProfile
+	if opts.ImageDigest != "" {
+		image = pinImageDigest(image, opts.ImageDigest)
+	}
 	return image, securityContext
 }
+
+// reservedEnvVars are the environment variables pv-mounter configures the image with.
+var reservedEnvVars = map[string]bool{
+	"SSH_PUBLIC_KEY":  true,
+	"SSH_PRIVATE_KEY": true,
+	"SSH_PORT":        true,
+	"NEEDS_ROOT":      true,
+	"ROLE":            true,
+	"PROXY_POD_IP":    true,
+}
+
+// ParseEnvVar parses an environment variable given as KEY=VALUE. The value may be empty.
+func ParseEnvVar(s string) (corev1.EnvVar, error) {
+	name, value, found := strings.Cut(s, "=")
+	if !found || name == "" {
+		return corev1.EnvVar{}, fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", s)
+	}
+	return corev1.EnvVar{Name: name, Value: value}, nil
+}
+
+// ParseSeccompProfile parses a seccomp profile given as runtime/default, unconfined or localhost/<profile>.
+func ParseSeccompProfile(profile string) (*corev1.SeccompProfile, error) {
+	profileType, localhostProfile, err := parseProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %v", err)
+	}
+	return &corev1.SeccompProfile{
+		Type:             corev1.SeccompProfileType(profileType),
+		LocalhostProfile: localhostProfile,
+	}, nil
+}
+
+// ParseAppArmorProfile parses an AppArmor profile given as runtime/default, unconfined or localhost/<profile>.
+func ParseAppArmorProfile(profile string) (*corev1.AppArmorProfile, error) {
+	profileType, localhostProfile, err := parseProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AppArmor profile: %v", err)
+	}
+	return &corev1.AppArmorProfile{
+		Type:             corev1.AppArmorProfileType(profileType),
+		LocalhostProfile: localhostProfile,
+	}, nil
+}
+
+// parseProfile parses the profile notation shared by seccomp and AppArmor. The returned
+// type matches the values of both corev1.SeccompProfileType and corev1.AppArmorProfileType.
+func parseProfile(profile string) (string, *string, error) {
+	switch profile {
+	case "runtime/default":
+		return "RuntimeDefault", nil, nil
+	case "unconfined":
+		return "Unconfined", nil, nil
+	}
+	if name, found := strings.CutPrefix(profile, "localhost/"); found {
+		if name == "" {
+			return "", nil, fmt.Errorf("%q is missing the profile name", profile)
+		}
+		return "Localhost", &name, nil
+	}
+	return "", nil, fmt.Errorf("%q, expected runtime/default, unconfined or localhost/<profile>", profile)
+}
+
+func appArmorAnnotationValue(profile *corev1.AppArmorProfile) string {
+	switch profile.Type {
+	case corev1.AppArmorProfileTypeLocalhost:
+		return corev1.DeprecatedAppArmorBetaProfileNamePrefix + *profile.LocalhostProfile
+	case corev1.AppArmorProfileTypeUnconfined:
+		return corev1.DeprecatedAppArmorBetaProfileNameUnconfined
+	}
+	return corev1.DeprecatedAppArmorBetaProfileRuntimeDefault
+}
@@ -2,13 +2,25 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
 )
@@ -58,6 +70,60 @@ func TestValidateMountPoint_FileInsteadOfDirectory(t *testing.T) {
 	}
 }
 
+func TestCheckMountPointEmpty(t *testing.T) {
+	t.Run("Empty directory", func(t *testing.T) {
+		if err := checkMountPointEmpty(t.TempDir()); err != nil {
+			t.Errorf("checkMountPointEmpty() returned an unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Non-empty directory", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0o600); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if err := checkMountPointEmpty(dir); !errors.Is(err, ErrMountPointNotEmpty) {
+			t.Errorf("Expected ErrMountPointNotEmpty, got: %v", err)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(file, nil, 0o600); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		if err := checkMountPointEmpty(file); err != nil {
+			t.Errorf("checkMountPointEmpty() returned an unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBuildSSHFSCommandNonEmpty(t *testing.T) {
+	oldSSHFSVersion := sshfsVersion
+	t.Cleanup(func() { sshfsVersion = oldSSHFSVersion })
+
+	tests := []struct {
+		name     string
+		version  string
+		opts     MountOptions
+		expected bool
+	}{
+		{"FUSE 2", "SSHFS version 2.10\nFUSE library version: 2.9.9\n", MountOptions{AllowNonEmpty: true}, true},
+		{"FUSE 3", "SSHFS version 3.7.3\nFUSE library version 3.14.0\n", MountOptions{AllowNonEmpty: true}, false},
+		{"Not allowed", "SSHFS version 2.10\nFUSE library version: 2.9.9\n", MountOptions{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshfsVersion = func(string) string { return tt.version }
+			cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, tt.opts)
+			if got := strings.Contains(strings.Join(cmd.Args, " "), "-o nonempty"); got != tt.expected {
+				t.Errorf("Expected nonempty option %v, got %v", tt.expected, cmd.Args)
+			}
+		})
+	}
+}
+
 func TestGetPodIP(t *testing.T) {
 	namespace := "default"
 	podName := "test-pod"
@@ -140,8 +206,8 @@ func TestContains(t *testing.T) {
 }
 
 func TestGeneratePodNameAndPort(t *testing.T) {
-	name1, port1 := generatePodNameAndPort("standalone")
-	name2, port2 := generatePodNameAndPort("standalone")
+	name1, port1 := generatePodNameAndPort("standalone", "")
+	name2, port2 := generatePodNameAndPort("standalone", "")
 	if name1 == name2 {
 		t.Error("Expected different pod names")
 	}
@@ -150,14 +216,105 @@ func TestGeneratePodNameAndPort(t *testing.T) {
 	}
 }
 
+func TestGeneratePodNameWithPrefix(t *testing.T) {
+	tests := []struct {
+		role     string
+		prefix   string
+		expected string
+	}{
+		{"standalone", "", "volume-exposer-"},
+		{"proxy", "", "volume-exposer-proxy-"},
+		{"standalone", "team-a-debug", "team-a-debug-"},
+		{"proxy", "team-a-debug", "team-a-debug-proxy-"},
+	}
+
+	for _, tt := range tests {
+		name, _ := generatePodNameAndPort(tt.role, tt.prefix)
+		if !strings.HasPrefix(name, tt.expected) || len(name) != len(tt.expected)+5 {
+			t.Errorf("Expected %s pod name with prefix %q to be %s<suffix>, got %s", tt.role, tt.prefix, tt.expected, name)
+		}
+	}
+}
+
+func TestValidatePodNamePrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		wantErr bool
+	}{
+		{prefix: "team-a-debug"},
+		{prefix: strings.Repeat("a", 63-len("-proxy-abcde"))},
+		{prefix: strings.Repeat("a", 64-len("-proxy-abcde")), wantErr: true},
+		{prefix: "Team_A", wantErr: true},
+		{prefix: "-leading-dash", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateMountOptions(MountOptions{PodNamePrefix: tt.prefix})
+		if tt.wantErr && err == nil {
+			t.Errorf("validateMountOptions() should have returned an error for prefix %s", tt.prefix)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("validateMountOptions() returned an unexpected error for prefix %s: %v", tt.prefix, err)
+		}
+	}
+}
+
 func TestCreatePodSpec(t *testing.T) {
-	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "publicKey", "standalone", 22, "", false)
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
 	if podSpec.Name != "test-pod" {
 		t.Errorf("Expected pod name 'test-pod', got '%s'", podSpec.Name)
 	}
 	// Additional checks for volumes, containers, etc.
 }
 
+func TestCreatePodSpecServiceAccountToken(t *testing.T) {
+	for _, role := range []string{"standalone", "proxy"} {
+		podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", MountOptions{})
+		if automount := podSpec.Spec.AutomountServiceAccountToken; automount == nil || *automount {
+			t.Errorf("Expected the %s pod not to mount the service account token, got %v", role, automount)
+		}
+	}
+
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{AutomountServiceAccountToken: true})
+	if automount := podSpec.Spec.AutomountServiceAccountToken; automount == nil || !*automount {
+		t.Errorf("Expected the token to be mounted when asked for, got %v", automount)
+	}
+}
+
+func TestCreatePodSpecPriorityClass(t *testing.T) {
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
+	if podSpec.Spec.PriorityClassName != "" {
+		t.Errorf("Expected no priority class by default, got %s", podSpec.Spec.PriorityClassName)
+	}
+
+	for _, role := range []string{"standalone", "proxy"} {
+		podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", MountOptions{PriorityClass: "high-priority"})
+		if podSpec.Spec.PriorityClassName != "high-priority" {
+			t.Errorf("Expected the %s pod to get the priority class, got %q", role, podSpec.Spec.PriorityClassName)
+		}
+	}
+}
+
+func TestCreatePodSpecTTL(t *testing.T) {
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
+	if podSpec.Spec.ActiveDeadlineSeconds != nil {
+		t.Errorf("Expected no active deadline by default, got %d", *podSpec.Spec.ActiveDeadlineSeconds)
+	}
+
+	for ttl, expected := range map[time.Duration]int64{2 * time.Hour: 7200, 1500 * time.Millisecond: 2} {
+		for _, role := range []string{"standalone", "proxy"} {
+			podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", MountOptions{TTL: ttl})
+			if deadline := podSpec.Spec.ActiveDeadlineSeconds; deadline == nil || *deadline != expected {
+				t.Errorf("Expected a TTL of %s to set an active deadline of %d seconds on the %s pod, got %v", ttl, expected, role, deadline)
+			}
+		}
+	}
+
+	if err := validateMountOptions(MountOptions{TTL: -time.Minute}); err == nil {
+		t.Error("Expected a negative TTL to be rejected")
+	}
+}
+
 func TestGetPVCVolumeName(t *testing.T) {
 	pod := &corev1.Pod{
 		Spec: corev1.PodSpec{
@@ -173,7 +330,7 @@ func TestGetPVCVolumeName(t *testing.T) {
 			},
 		},
 	}
-	volumeName, err := getPVCVolumeName(pod)
+	volumeName, err := getPVCVolumeName(pod, "test-pvc")
 	if err != nil {
 		t.Errorf("getPVCVolumeName returned an error: %v", err)
 	}
@@ -181,3 +338,2038 @@ func TestGetPVCVolumeName(t *testing.T) {
 		t.Errorf("Expected volume name 'test-volume', got '%s'", volumeName)
 	}
 }
+
+// newEphemeralVolumePod returns a pod using a generic ephemeral volume, whose PVC is named
+// <pod>-<volume>.
+func newEphemeralVolumePod(namespace, podName, volumeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{
+					Name: volumeName,
+					VolumeSource: corev1.VolumeSource{
+						Ephemeral: &corev1.EphemeralVolumeSource{
+							VolumeClaimTemplate: &corev1.PersistentVolumeClaimTemplate{
+								Spec: corev1.PersistentVolumeClaimSpec{
+									AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestGetPVCVolumeNameEphemeral(t *testing.T) {
+	pod := newEphemeralVolumePod("default", "workload", "scratch")
+	volumeName, err := getPVCVolumeName(pod, "workload-scratch")
+	if err != nil {
+		t.Fatalf("getPVCVolumeName returned an error: %v", err)
+	}
+	if volumeName != "scratch" {
+		t.Errorf("Expected volume name 'scratch', got '%s'", volumeName)
+	}
+	if claimName := podVolumeClaimName(pod, pod.Spec.Volumes[1]); claimName != "workload-scratch" {
+		t.Errorf("Expected claim name 'workload-scratch', got '%s'", claimName)
+	}
+	if claimName := podVolumeClaimName(pod, pod.Spec.Volumes[0]); claimName != "" {
+		t.Errorf("Expected no claim name for an emptyDir, got '%s'", claimName)
+	}
+}
+
+func TestBuildSSHFSCommand(t *testing.T) {
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{})
+	expected := []string{
+		"sshfs",
+		"-o", "IdentityFile=/tmp/key.pem",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "nomap=ignore",
+		"-o", fmt.Sprintf("idmap=user,uid=%d,gid=%d", os.Getuid(), os.Getgid()),
+		"-o", "reconnect",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"ve@localhost:/volume",
+		"/mnt/data",
+		"-p", "12345",
+	}
+	if strings.Join(cmd.Args, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected command %v, got %v", expected, cmd.Args)
+	}
+}
+
+func TestIDMapOptions(t *testing.T) {
+	uid, gid := 1000, 1001
+	tests := []struct {
+		name     string
+		opts     MountOptions
+		expected string
+	}{
+		{"Default", MountOptions{}, fmt.Sprintf("-o nomap=ignore -o idmap=user,uid=%d,gid=%d", os.Getuid(), os.Getgid())},
+		{"Default with owner", MountOptions{UID: &uid, GID: &gid}, "-o nomap=ignore -o idmap=user,uid=1000,gid=1001"},
+		{"Ignore", MountOptions{IDMapMode: IDMapIgnore}, "-o nomap=ignore"},
+		{"Ignore with owner", MountOptions{IDMapMode: IDMapIgnore, UID: &uid, GID: &gid}, "-o nomap=ignore -o idmap=user,uid=1000,gid=1001"},
+		{"None", MountOptions{IDMapMode: IDMapNone}, "-o idmap=none"},
+		{"User", MountOptions{IDMapMode: IDMapUser}, fmt.Sprintf("-o idmap=user,uid=%d,gid=%d", os.Getuid(), os.Getgid())},
+		{"User with owner", MountOptions{IDMapMode: IDMapUser, UID: &uid, GID: &gid}, "-o idmap=user,uid=1000,gid=1001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(idMapOptions(tt.opts), " "); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+			cmd := strings.Join(buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, tt.opts).Args, " ")
+			if !strings.Contains(cmd, tt.expected) {
+				t.Errorf("Expected the SSHFS command to contain %q, got %q", tt.expected, cmd)
+			}
+		})
+	}
+}
+
+func TestValidateIDMapMode(t *testing.T) {
+	uid := 1000
+	for _, opts := range []MountOptions{
+		{IDMapMode: "file"},
+		{IDMapMode: IDMapNone, UID: &uid},
+	} {
+		if err := validateMountOptions(opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+	for _, opts := range []MountOptions{
+		{IDMapMode: IDMapIgnore, UID: &uid},
+		{IDMapMode: IDMapNone},
+		{IDMapMode: IDMapUser, UID: &uid},
+	} {
+		if err := validateMountOptions(opts); err != nil {
+			t.Errorf("Expected %+v to be accepted, got %v", opts, err)
+		}
+	}
+}
+
+// newTestObjects returns a bound PVC together with its PV using the given access mode.
+func newTestObjects(namespace, pvcName string, accessMode corev1.PersistentVolumeAccessMode) []runtime.Object {
+	return []runtime.Object{
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: pvcName, Namespace: namespace},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeName:  "test-pv",
+				AccessModes: []corev1.PersistentVolumeAccessMode{accessMode},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pv"},
+			Spec: corev1.PersistentVolumeSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{accessMode},
+			},
+		},
+	}
+}
+
+// newWorkloadPod returns a pod that mounts the given PVC.
+func newWorkloadPod(namespace, podName, pvcName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+					},
+				},
+			},
+		},
+	}
+}
+
+// captureStdout returns everything written to stdout while f runs.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+
+	f()
+	w.Close()
+	return <-done
+}
+
+func assertNoWrites(t *testing.T, clientset *fake.Clientset) {
+	t.Helper()
+	for _, action := range clientset.Actions() {
+		switch action.GetVerb() {
+		case "get", "list", "watch":
+		default:
+			t.Errorf("Unexpected %s %s call during dry run", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestMountDryRun(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	t.Run("RWX volume", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+
+		var err error
+		out := captureStdout(t, func() {
+			err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true})
+		})
+		if err != nil {
+			t.Fatalf("mount() returned an error: %v", err)
+		}
+
+		assertNoWrites(t, clientset)
+		for _, expected := range []string{"kind: Pod", "claimName: test-pvc", "kubectl port-forward", "sshfs"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("Expected dry run output to contain '%s', got:\n%s", expected, out)
+			}
+		}
+	})
+
+	t.Run("Mounted RWO volume", func(t *testing.T) {
+		objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce), newWorkloadPod(namespace, "workload", pvcName))
+		clientset := fake.NewSimpleClientset(objects...)
+
+		var err error
+		out := captureStdout(t, func() {
+			err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true})
+		})
+		if err != nil {
+			t.Fatalf("mount() returned an error: %v", err)
+		}
+
+		assertNoWrites(t, clientset)
+		for _, expected := range []string{"kind: Pod", "originalPodName: workload", "value: ephemeral", "kubectl port-forward", "sshfs"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("Expected dry run output to contain '%s', got:\n%s", expected, out)
+			}
+		}
+	})
+}
+
+func TestCreateEphemeralContainerUnsupported(t *testing.T) {
+	subresourceNotFound := &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    404,
+		Reason:  metav1.StatusReasonNotFound,
+		Message: "the server could not find the requested resource",
+	}}
+	tests := []struct {
+		name        string
+		err         error
+		unsupported bool
+		podNotFound bool
+	}{
+		{"Subresource not found", subresourceNotFound, true, false},
+		{"Feature gate disabled", apierrors.NewForbidden(corev1.Resource("pods"), "workload", errors.New("spec.ephemeralContainers: Forbidden: disabled by feature-gate EphemeralContainers")), true, false},
+		{"Pod deleted meanwhile", apierrors.NewNotFound(corev1.Resource("pods"), "workload"), false, true},
+		{"Other error", apierrors.NewBadRequest("invalid patch"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(newWorkloadPod("default", "workload", "test-pvc"))
+			clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() == "ephemeralcontainers" {
+					return true, nil, tt.err
+				}
+				return false, nil, nil
+			})
+
+			var err error
+			captureStdout(t, func() {
+				_, err = createEphemeralContainer(context.Background(), clientset, "default", "workload", "test-pvc", "privateKey", "publicKey", "10.0.0.1", MountOptions{})
+			})
+			if err == nil {
+				t.Fatal("createEphemeralContainer() should have returned an error")
+			}
+			if errors.Is(err, ErrEphemeralContainersUnsupported) != tt.unsupported {
+				t.Errorf("Expected ErrEphemeralContainersUnsupported to be %v, got %v", tt.unsupported, err)
+			}
+			if errors.Is(err, ErrPodNotFound) != tt.podNotFound {
+				t.Errorf("Expected ErrPodNotFound to be %v, got %v", tt.podNotFound, err)
+			}
+			if tt.unsupported && !strings.Contains(err.Error(), "--assume-rwx") {
+				t.Errorf("Expected the error to suggest alternatives, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckPVCUsageRetries(t *testing.T) {
+	defer func(backoff wait.Backoff) { apiRetryBackoff = backoff }(apiRetryBackoff)
+	apiRetryBackoff.Duration = time.Millisecond
+
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	t.Run("Retryable error then success", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+		calls := 0
+		clientset.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			if calls == 1 {
+				return true, nil, apierrors.NewTooManyRequests("slow down", 1)
+			}
+			return false, nil, nil
+		})
+
+		pvc, err := checkPVCUsage(context.Background(), clientset, namespace, pvcName, 3)
+		if err != nil {
+			t.Fatalf("checkPVCUsage() returned an error: %v", err)
+		}
+		if pvc.Name != pvcName {
+			t.Errorf("Expected PVC %s, got %s", pvcName, pvc.Name)
+		}
+		if calls != 2 {
+			t.Errorf("Expected 2 calls, got %d", calls)
+		}
+	})
+
+	t.Run("NotFound is not retried", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		calls := 0
+		clientset.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			return false, nil, nil
+		})
+
+		if _, err := checkPVCUsage(context.Background(), clientset, namespace, pvcName, 3); err == nil {
+			t.Error("checkPVCUsage() should have returned an error for a missing PVC")
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		calls := 0
+		clientset.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			return true, nil, apierrors.NewServiceUnavailable("unavailable")
+		})
+
+		if _, err := checkPVCUsage(context.Background(), clientset, namespace, pvcName, 2); err == nil {
+			t.Error("checkPVCUsage() should have returned an error")
+		}
+		if calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls)
+		}
+	})
+}
+
+func TestSetupPodRetriesCreate(t *testing.T) {
+	defer func(backoff wait.Backoff) { apiRetryBackoff = backoff }(apiRetryBackoff)
+	apiRetryBackoff.Duration = time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+	calls := 0
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls == 1 {
+			return true, nil, apierrors.NewServerTimeout(corev1.Resource("pods"), "create", 1)
+		}
+		return false, nil, nil
+	})
+
+	podName, _, err := setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{APIRetries: 1})
+	if err != nil {
+		t.Fatalf("setupPod() returned an error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), podName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected pod %s to be created: %v", podName, err)
+	}
+}
+
+func TestSetupPodNameCollision(t *testing.T) {
+	t.Run("Retried with another name", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		var names []string
+		clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+			names = append(names, pod.Name)
+			if len(names) == 1 {
+				return true, nil, apierrors.NewAlreadyExists(corev1.Resource("pods"), pod.Name)
+			}
+			return false, nil, nil
+		})
+
+		var podName string
+		var err error
+		out := captureStdout(t, func() {
+			podName, _, err = setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+		})
+		if err != nil {
+			t.Fatalf("setupPod() returned an error: %v", err)
+		}
+		if len(names) != 2 || names[0] == names[1] || podName != names[1] {
+			t.Errorf("Expected a second attempt with another name, got %v and pod %s", names, podName)
+		}
+		if !strings.Contains(out, fmt.Sprintf("Pod %s already exists, retrying with another name", names[0])) {
+			t.Errorf("Expected the collision to be reported, got %q", out)
+		}
+	})
+
+	t.Run("Gives up", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		calls := 0
+		clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			return true, nil, apierrors.NewAlreadyExists(corev1.Resource("pods"), action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Name)
+		})
+
+		var err error
+		captureStdout(t, func() {
+			_, _, err = setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+		})
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("Expected an error about the existing pod, got %v", err)
+		}
+		if calls != podNameAttempts {
+			t.Errorf("Expected %d attempts, got %d", podNameAttempts, calls)
+		}
+	})
+
+	t.Run("Created by a retried request", func(t *testing.T) {
+		defer func(backoff wait.Backoff) { apiRetryBackoff = backoff }(apiRetryBackoff)
+		apiRetryBackoff.Duration = time.Millisecond
+
+		clientset := fake.NewSimpleClientset()
+		var names []string
+		clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+			names = append(names, pod.Name)
+			if len(names) == 1 {
+				// Created, but the response got lost
+				created := pod.DeepCopy()
+				created.Namespace = "default"
+				if err := clientset.Tracker().Add(created); err != nil {
+					t.Fatalf("Failed to add pod: %v", err)
+				}
+				return true, nil, apierrors.NewServerTimeout(corev1.Resource("pods"), "create", 1)
+			}
+			return false, nil, nil
+		})
+
+		var podName string
+		var err error
+		captureStdout(t, func() {
+			podName, _, err = setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{APIRetries: 1})
+		})
+		if err != nil {
+			t.Fatalf("setupPod() returned an error: %v", err)
+		}
+		if len(names) != 2 || podName != names[0] {
+			t.Errorf("Expected the pod created by the first request to be used after the retry, got %s (attempts %v)", podName, names)
+		}
+		pods, _ := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+		if len(pods.Items) != 1 {
+			t.Errorf("Expected a single pod, got %d", len(pods.Items))
+		}
+	})
+}
+
+func TestSetupPodPodSecurityRejection(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "volume-exposer-abcde",
+			errors.New(`violates PodSecurity "restricted:latest": unrestricted capabilities (container "volume-exposer" must not include "SYS_ADMIN" in securityContext.capabilities.add)`))
+	})
+
+	_, _, err := setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{NeedsRoot: true})
+	if !errors.Is(err, ErrPodSecurity) {
+		t.Fatalf("Expected ErrPodSecurity, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "without --needs-root") {
+		t.Errorf("Expected the error to suggest mounting without --needs-root, got %v", err)
+	}
+
+	clientset = fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "volume-exposer-abcde", errors.New("quota exceeded"))
+	})
+	_, _, err = setupPod(context.Background(), clientset, "default", "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+	if err == nil || errors.Is(err, ErrPodSecurity) {
+		t.Errorf("Expected a plain error for other rejections, got %v", err)
+	}
+}
+
+func TestClassifyAccessMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		modes    []corev1.PersistentVolumeAccessMode
+		expected corev1.PersistentVolumeAccessMode
+		wantErr  bool
+	}{
+		{"RWO", []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, corev1.ReadWriteOnce, false},
+		{"RWX", []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, corev1.ReadWriteMany, false},
+		{"ROX", []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, corev1.ReadOnlyMany, false},
+		{"RWOP", []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}, corev1.ReadWriteOncePod, false},
+		{"RWO and RWX", []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadWriteMany}, corev1.ReadWriteMany, false},
+		{"RWO and ROX", []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany, corev1.ReadWriteOnce}, corev1.ReadWriteOnce, false},
+		{"None", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := classifyAccessMode(tt.modes)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("classifyAccessMode() should have returned an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("classifyAccessMode() returned an error: %v", err)
+			}
+			if mode != tt.expected {
+				t.Errorf("classifyAccessMode() = %s; want %s", mode, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckPVAccessMode(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	tests := []struct {
+		name        string
+		accessMode  corev1.PersistentVolumeAccessMode
+		withPod     bool
+		expectedPod string
+	}{
+		{"Unused RWO", corev1.ReadWriteOnce, false, ""},
+		{"Mounted RWO", corev1.ReadWriteOnce, true, "workload"},
+		{"Mounted RWOP", corev1.ReadWriteOncePod, true, "workload"},
+		{"Mounted RWX", corev1.ReadWriteMany, true, ""},
+		{"Mounted ROX", corev1.ReadOnlyMany, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := newTestObjects(namespace, pvcName, tt.accessMode)
+			if tt.withPod {
+				objects = append(objects, newWorkloadPod(namespace, "workload", pvcName))
+			}
+			clientset := fake.NewSimpleClientset(objects...)
+			pvc := objects[0].(*corev1.PersistentVolumeClaim)
+
+			mode, podUsingPVC, err := checkPVAccessMode(context.Background(), clientset, pvc, namespace, 0)
+			if err != nil {
+				t.Fatalf("checkPVAccessMode() returned an error: %v", err)
+			}
+			if mode != tt.accessMode {
+				t.Errorf("Expected access mode %s, got %s", tt.accessMode, mode)
+			}
+			if podUsingPVC != tt.expectedPod {
+				t.Errorf("Expected pod '%s', got '%s'", tt.expectedPod, podUsingPVC)
+			}
+		})
+	}
+}
+
+func TestCheckPVAccessModeEphemeralVolume(t *testing.T) {
+	namespace := "default"
+	objects := newTestObjects(namespace, "workload-scratch", corev1.ReadWriteOnce)
+	objects = append(objects, newEphemeralVolumePod(namespace, "workload", "scratch"))
+	clientset := fake.NewSimpleClientset(objects...)
+	pvc := objects[0].(*corev1.PersistentVolumeClaim)
+
+	mode, podUsingPVC, err := checkPVAccessMode(context.Background(), clientset, pvc, namespace, 0)
+	if err != nil {
+		t.Fatalf("checkPVAccessMode() returned an error: %v", err)
+	}
+	if mode != corev1.ReadWriteOnce {
+		t.Errorf("Expected access mode %s, got %s", corev1.ReadWriteOnce, mode)
+	}
+	if podUsingPVC != "workload" {
+		t.Errorf("Expected pod 'workload', got '%s'", podUsingPVC)
+	}
+}
+
+func TestMountReadOnlyManyRequiresReadOnly(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadOnlyMany)...)
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true})
+	})
+	if err == nil || !strings.Contains(err.Error(), "--read-only") {
+		t.Errorf("Expected an error suggesting --read-only, got: %v", err)
+	}
+
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, ReadOnly: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+	for _, expected := range []string{"Detected access mode ReadOnlyMany", "readOnly: true", "-o ro"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected output to contain '%s', got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestBuildSSHFSCommandReadOnly(t *testing.T) {
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{ReadOnly: true})
+	if !strings.Contains(strings.Join(cmd.Args, " "), "-o ro") {
+		t.Errorf("Expected read-only option in %v", cmd.Args)
+	}
+}
+
+func TestBuildSSHFSCommandIDMap(t *testing.T) {
+	uid, gid := 1000, 100
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{UID: &uid, GID: &gid})
+	if !strings.Contains(strings.Join(cmd.Args, " "), "-o idmap=user,uid=1000,gid=100") {
+		t.Errorf("Expected idmap option for uid 1000 and gid 100 in %v", cmd.Args)
+	}
+
+	cmd = buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{UID: &uid})
+	expected := fmt.Sprintf("-o idmap=user,uid=1000,gid=%d", os.Getgid())
+	if !strings.Contains(strings.Join(cmd.Args, " "), expected) {
+		t.Errorf("Expected option '%s' in %v", expected, cmd.Args)
+	}
+
+	// Without --uid and --gid the files are mapped to the current user and group
+	cmd = buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{})
+	expected = fmt.Sprintf("-o idmap=user,uid=%d,gid=%d", os.Getuid(), os.Getgid())
+	if !strings.Contains(strings.Join(cmd.Args, " "), expected) {
+		t.Errorf("Expected option '%s' for the current user by default in %v", expected, cmd.Args)
+	}
+}
+
+func TestBuildSSHFSCommandCompression(t *testing.T) {
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{Compression: true})
+	if !strings.Contains(strings.Join(cmd.Args, " "), "-o Compression=yes") {
+		t.Errorf("Expected compression option in %v", cmd.Args)
+	}
+
+	cmd = buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{})
+	if strings.Contains(strings.Join(cmd.Args, " "), "Compression") {
+		t.Errorf("Expected no compression option in %v", cmd.Args)
+	}
+}
+
+func TestBuildSSHFSCommandAllowOther(t *testing.T) {
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{AllowOther: true})
+	if !strings.Contains(strings.Join(cmd.Args, " "), "-o allow_other") {
+		t.Errorf("Expected allow_other option in %v", cmd.Args)
+	}
+
+	cmd = buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{})
+	if strings.Contains(strings.Join(cmd.Args, " "), "allow_other") {
+		t.Errorf("Expected no allow_other option in %v", cmd.Args)
+	}
+}
+
+func TestBuildSSHFSCommandKeepAlive(t *testing.T) {
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{KeepAliveInterval: 30})
+	args := strings.Join(cmd.Args, " ")
+	for _, option := range []string{"-o reconnect", "-o ServerAliveInterval=30", "-o ServerAliveCountMax=3"} {
+		if !strings.Contains(args, option) {
+			t.Errorf("Expected option '%s' in %v", option, cmd.Args)
+		}
+	}
+
+	cmd = buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{KeepAliveInterval: -1})
+	args = strings.Join(cmd.Args, " ")
+	if strings.Contains(args, "ServerAlive") || strings.Contains(args, "reconnect") {
+		t.Errorf("Expected no keep-alive options in %v", cmd.Args)
+	}
+}
+
+func TestBuildSSHFSCommandCustomBinaryAndOptions(t *testing.T) {
+	oldSSHFSVersion := sshfsVersion
+	t.Cleanup(func() { sshfsVersion = oldSSHFSVersion })
+	var versionOf string
+	sshfsVersion = func(sshfsPath string) string {
+		versionOf = sshfsPath
+		return ""
+	}
+
+	opts := MountOptions{
+		SSHFSPath:     "/opt/sshfs/bin/sshfs",
+		SSHFSOptions:  []string{"cache_timeout=115200", "ssh_command=ssh -v"},
+		AllowNonEmpty: true,
+	}
+	cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, opts)
+	if cmd.Args[0] != "/opt/sshfs/bin/sshfs" {
+		t.Errorf("Expected the custom sshfs binary to be run, got %v", cmd.Args)
+	}
+	if versionOf != "/opt/sshfs/bin/sshfs" {
+		t.Errorf("Expected the version of the custom sshfs binary to be checked, got %q", versionOf)
+	}
+	args := strings.Join(cmd.Args, " ")
+	// Passed verbatim, even with spaces, since they don't go through a shell
+	if !strings.Contains(args, "-o cache_timeout=115200 -o ssh_command=ssh -v ve@localhost:/volume") {
+		t.Errorf("Expected the options before the source, got %v", cmd.Args)
+	}
+
+	if cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, MountOptions{}); cmd.Args[0] != "sshfs" {
+		t.Errorf("Expected sshfs from the PATH by default, got %v", cmd.Args)
+	}
+}
+
+func TestValidateMountOptionsSSHFSOptions(t *testing.T) {
+	if err := validateMountOptions(MountOptions{SSHFSOptions: []string{"max_conns=4"}}); err != nil {
+		t.Errorf("validateMountOptions() returned an unexpected error: %v", err)
+	}
+	for _, option := range []string{"", "ro\nallow_other"} {
+		if err := validateMountOptions(MountOptions{SSHFSOptions: []string{option}}); err == nil {
+			t.Errorf("validateMountOptions() should have rejected SSHFS option %q", option)
+		}
+	}
+}
+
+func TestCheckSSHFSPath(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "sshfs")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("Failed to create fake sshfs: %v", err)
+	}
+	notExecutable := filepath.Join(dir, "sshfs.txt")
+	if err := os.WriteFile(notExecutable, nil, 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := checkSSHFS(executable); err != nil {
+		t.Errorf("checkSSHFS() returned an unexpected error: %v", err)
+	}
+	for _, sshfsPath := range []string{notExecutable, filepath.Join(dir, "missing")} {
+		if err := checkSSHFS(sshfsPath); !errors.Is(err, ErrSSHFSNotFound) {
+			t.Errorf("Expected ErrSSHFSNotFound for %s, got %v", sshfsPath, err)
+		}
+	}
+}
+
+func TestBuildPodLabels(t *testing.T) {
+	labels := buildPodLabels("test-pvc", "/mnt/data", 12345, DefaultSSHPort, "workload")
+	expected := map[string]string{
+		"app":             "volume-exposer",
+		"pvcName":         "test-pvc",
+		"portNumber":      "12345",
+		"sshPort":         "2137",
+		"mountPointHash":  mountPointHash("/mnt/data"),
+		"originalPodName": "workload",
+	}
+	for key, value := range expected {
+		if labels[key] != value {
+			t.Errorf("Expected label %s=%s, got %s", key, value, labels[key])
+		}
+	}
+	if errs := validation.IsValidLabelValue(labels["mountPointHash"]); len(errs) != 0 {
+		t.Errorf("mountPointHash is not a valid label value: %v", errs)
+	}
+}
+
+func TestInheritPVCLabels(t *testing.T) {
+	pvcLabels := map[string]string{
+		"team":                        "a",
+		"example.com/cost-center":     "42",
+		"app":                         "database",
+		"pvcName":                     "other",
+		"originalPodName":             "workload",
+		"app.kubernetes.io/name":      "database",
+		"topology.kubernetes.io/zone": "eu-1a",
+		"k8s.io/managed":              "true",
+	}
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{pvcLabels: pvcLabels})
+	labels := podSpec.Labels
+	for key, value := range map[string]string{
+		"team":                    "a",
+		"example.com/cost-center": "42",
+		"app":                     "volume-exposer",
+		"pvcName":                 "test-pvc",
+	} {
+		if labels[key] != value {
+			t.Errorf("Expected label %s=%s, got %q", key, value, labels[key])
+		}
+	}
+	for _, key := range []string{"originalPodName", "app.kubernetes.io/name", "topology.kubernetes.io/zone", "k8s.io/managed"} {
+		if _, ok := labels[key]; ok {
+			t.Errorf("Expected label %s not to be inherited, got %v", key, labels)
+		}
+	}
+}
+
+func TestMountInheritPVCLabels(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	for _, inherit := range []bool{false, true} {
+		objects := newTestObjects(namespace, pvcName, corev1.ReadWriteMany)
+		objects[0].(*corev1.PersistentVolumeClaim).Labels = map[string]string{"team": "a", "app": "database"}
+		clientset := fake.NewSimpleClientset(objects...)
+
+		var err error
+		out := captureStdout(t, func() {
+			err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, InheritPVCLabels: inherit})
+		})
+		if err != nil {
+			t.Fatalf("mount() returned an error: %v", err)
+		}
+		if inherited := strings.Contains(out, "team: a"); inherited != inherit {
+			t.Errorf("Expected the PVC labels on the pod only with InheritPVCLabels (%v), got:\n%s", inherit, out)
+		}
+		if !strings.Contains(out, "app: volume-exposer") || strings.Contains(out, "app: database") {
+			t.Errorf("Expected the app label of the pod to be kept, got:\n%s", out)
+		}
+	}
+}
+
+func TestMountPointHash(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+
+	hash := mountPointHash("foo")
+	for _, path := range []string{"./foo", "foo/", filepath.Join(cwd, "foo")} {
+		if got := mountPointHash(path); got != hash {
+			t.Errorf("mountPointHash(%s) = %s; want %s", path, got, hash)
+		}
+	}
+	if mountPointHash("bar") == hash {
+		t.Error("Expected different mount points to have different hashes")
+	}
+}
+
+func TestCreatePodSpecAnnotations(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+	expected := map[string]string{
+		MountPointAnnotation: filepath.Join(cwd, "data"),
+		BackendAnnotation:    "sshfs",
+		LocalPortAnnotation:  "12345",
+	}
+	for key, value := range expected {
+		if podSpec.Annotations[key] != value {
+			t.Errorf("Expected annotation %s=%s, got %s", key, value, podSpec.Annotations[key])
+		}
+	}
+}
+
+func TestMountCustomSSHPort(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, SSHPort: 2222})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+	for _, expected := range []string{"containerPort: 2222", "value: \"2222\"", ":2222 -n default"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected output to contain '%s', got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestValidateMountOptions(t *testing.T) {
+	if err := validateMountOptions(MountOptions{SSHPort: 2222}); err != nil {
+		t.Errorf("validateMountOptions() returned an unexpected error: %v", err)
+	}
+	if err := validateMountOptions(MountOptions{SSHPort: 70000}); err == nil {
+		t.Error("validateMountOptions() should have returned an error for an out of range port")
+	}
+}
+
+func TestMaxAgeAnnotation(t *testing.T) {
+	pod := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{MaxAge: 2 * time.Hour})
+	if pod.Annotations[MaxAgeAnnotation] != "2h0m0s" {
+		t.Errorf("Expected max age annotation 2h0m0s, got %q", pod.Annotations[MaxAgeAnnotation])
+	}
+
+	pod = createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+	if _, ok := pod.Annotations[MaxAgeAnnotation]; ok {
+		t.Error("Expected no max age annotation by default")
+	}
+}
+
+func TestKeepPrivateKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "debug.pem")
+	// An existing file keeps its permissions on write, they still have to be restricted
+	if err := os.WriteFile(keyFile, []byte("old"), 0o644); err != nil {
+		t.Fatalf("Failed to create key file: %v", err)
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = keepPrivateKey("private-key", 12345, MountOptions{KeepKey: keyFile})
+	})
+	if err != nil {
+		t.Fatalf("keepPrivateKey() returned an error: %v", err)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+	if string(data) != "private-key" {
+		t.Errorf("Expected the private key in %s, got %q", keyFile, data)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to stat key file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	expected := fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -p 12345 ve@localhost", keyFile)
+	if !strings.Contains(out, expected) {
+		t.Errorf("Expected the connect hint %q, got:\n%s", expected, out)
+	}
+}
+
+func TestRemoteMountPath(t *testing.T) {
+	for _, tt := range []struct {
+		opts     MountOptions
+		expected string
+	}{
+		{MountOptions{}, DefaultRemoteMountPath},
+		{MountOptions{RemoteMountPath: "/data"}, "/data"},
+	} {
+		pod := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", tt.opts)
+		if mountPath := pod.Spec.Containers[0].VolumeMounts[0].MountPath; mountPath != tt.expected {
+			t.Errorf("Expected the volume mounted at %s in the pod, got %s", tt.expected, mountPath)
+		}
+
+		container := buildEphemeralContainerSpec("ephemeral", "volume", "privateKey", "publicKey", "10.0.0.1", tt.opts)
+		if mountPath := container.VolumeMounts[0].MountPath; mountPath != tt.expected {
+			t.Errorf("Expected the volume mounted at %s in the ephemeral container, got %s", tt.expected, mountPath)
+		}
+
+		cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, tt.opts)
+		if source := "ve@localhost:" + tt.expected; !slices.Contains(cmd.Args, source) {
+			t.Errorf("Expected SSHFS to mount %s, got %v", source, cmd.Args)
+		}
+	}
+
+	if err := validateMountOptions(MountOptions{RemoteMountPath: "data"}); err == nil {
+		t.Error("validateMountOptions() should have rejected a relative remote mount path")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	for _, tt := range []struct {
+		address      string
+		expectedHost string
+		warns        bool
+	}{
+		{"", "localhost", false},
+		{"127.0.0.2", "127.0.0.2", false},
+		{"::1", "::1", false},
+		{"::", "localhost", true},
+		{"0.0.0.0", "localhost", true},
+		{"192.168.1.10", "192.168.1.10", true},
+	} {
+		t.Run(tt.address, func(t *testing.T) {
+			r := &fakeRunner{}
+			useFakeRunner(t, r)
+			opts := MountOptions{Address: tt.address}
+
+			var host string
+			var err error
+			out := captureStdout(t, func() {
+				_, host, _, err = exposePod(context.Background(), fake.NewSimpleClientset(), "default", "test-pod", 12345, DefaultSSHPort, opts)
+			})
+			if err != nil {
+				t.Fatalf("exposePod() returned an error: %v", err)
+			}
+			if host != tt.expectedHost {
+				t.Errorf("Expected SSHFS to connect to %s, got %s", tt.expectedHost, host)
+			}
+			if warned := strings.Contains(out, "Warning"); warned != tt.warns {
+				t.Errorf("Expected warning %v, got output %q", tt.warns, out)
+			}
+
+			args := strings.Join(r.started[0], " ")
+			if tt.address == "" && strings.Contains(args, "--address") {
+				t.Errorf("Expected no --address by default, got %s", args)
+			}
+			if tt.address != "" && !strings.HasSuffix(args, "--address "+tt.address) {
+				t.Errorf("Expected the port-forward bound to %s, got %s", tt.address, args)
+			}
+
+			opts.sshfsHost = host
+			cmd := buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, opts)
+			if source := (MountOptions{sshfsHost: tt.expectedHost}).remoteTarget(DefaultRemoteMountPath); !slices.Contains(cmd.Args, source) {
+				t.Errorf("Expected SSHFS to mount %s, got %v", source, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestRemoteTarget(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"", "ve@localhost:/volume"},
+		{"127.0.0.1", "ve@127.0.0.1:/volume"},
+		{"pod.example.com", "ve@pod.example.com:/volume"},
+		{"::1", "ve@[::1]:/volume"},
+		{"fd00::10", "ve@[fd00::10]:/volume"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			opts := MountOptions{sshfsHost: tt.host}
+			if got := opts.remoteTarget(DefaultRemoteMountPath); got != tt.expected {
+				t.Errorf("remoteTarget() = %s; want %s", got, tt.expected)
+			}
+			if !isSSHFSSource(tt.expected) {
+				t.Errorf("Expected %s to be recognized as the source of a mount", tt.expected)
+			}
+
+			// Every command reaching the volume has to agree on the target
+			commands := map[string][]string{
+				"sshfs": buildSSHFSCommand("/tmp/key.pem", "/mnt/data", 12345, opts).Args,
+				"sftp":  buildSFTPCommand("/tmp/key.pem", "", 12345, opts).Args,
+				"scp":   buildCopyCommand("/tmp/key.pem", DefaultRemoteMountPath, 12345, CopySpec{LocalPath: "."}, opts).Args,
+			}
+			for name, args := range commands {
+				if !slices.Contains(args, tt.expected) {
+					t.Errorf("Expected %s to use %s, got %v", name, tt.expected, args)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMountOptionsAddress(t *testing.T) {
+	if err := validateMountOptions(MountOptions{Address: "localhost"}); err != nil {
+		t.Errorf("validateMountOptions() returned an unexpected error: %v", err)
+	}
+	if err := validateMountOptions(MountOptions{Address: "not an address"}); err == nil {
+		t.Error("validateMountOptions() should have rejected an invalid address")
+	}
+	if err := validateMountOptions(MountOptions{Address: "0.0.0.0", Via: ViaService}); err == nil {
+		t.Error("validateMountOptions() should have rejected an address with --via service")
+	}
+}
+
+func TestParseEnvVar(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected corev1.EnvVar
+		wantErr  bool
+	}{
+		{"FOO=bar", corev1.EnvVar{Name: "FOO", Value: "bar"}, false},
+		{"FOO=a=b", corev1.EnvVar{Name: "FOO", Value: "a=b"}, false},
+		{"FOO=", corev1.EnvVar{Name: "FOO"}, false},
+		{"FOO", corev1.EnvVar{}, true},
+		{"=bar", corev1.EnvVar{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEnvVar(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEnvVar(%q) error = %v; wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseEnvVar(%q) = %v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestValidateMountOptionsEnv(t *testing.T) {
+	if err := validateMountOptions(MountOptions{Env: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy:3128"}}}); err != nil {
+		t.Errorf("validateMountOptions() returned an unexpected error: %v", err)
+	}
+	for _, name := range []string{"SSH_PUBLIC_KEY", "ROLE", "NEEDS_ROOT", "SSH_PORT"} {
+		if err := validateMountOptions(MountOptions{Env: []corev1.EnvVar{{Name: name, Value: "x"}}}); err == nil {
+			t.Errorf("validateMountOptions() should have rejected reserved variable %s", name)
+		}
+	}
+	if err := validateMountOptions(MountOptions{Env: []corev1.EnvVar{{Name: "1FOO"}}}); err == nil {
+		t.Error("validateMountOptions() should have rejected an invalid variable name")
+	}
+}
+
+func TestExtraEnvVars(t *testing.T) {
+	opts := MountOptions{Env: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy:3128"}}}
+
+	pod := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", opts)
+	env := pod.Spec.Containers[0].Env
+	if last := env[len(env)-1]; last != opts.Env[0] {
+		t.Errorf("Expected extra variable after the fixed ones, got %v", env)
+	}
+	if len(env) != 5 {
+		t.Errorf("Expected 4 fixed variables and 1 extra one, got %v", env)
+	}
+
+	container := buildEphemeralContainerSpec("ephemeral", "volume", "privateKey", "publicKey", "10.0.0.1", opts)
+	env = container.Env
+	if last := env[len(env)-1]; last != opts.Env[0] {
+		t.Errorf("Expected extra variable in the ephemeral container, got %v", env)
+	}
+}
+
+func TestMountAssumeRWX(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce), newWorkloadPod(namespace, "workload", pvcName))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, AssumeRWX: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "pods" {
+			t.Error("Expected pods using the PVC not to be looked up")
+		}
+	}
+	if !strings.Contains(out, "value: standalone") || !strings.Contains(out, "claimName: test-pvc") {
+		t.Errorf("Expected a standalone pod mounting the PVC, got:\n%s", out)
+	}
+	if strings.Contains(out, "value: ephemeral") || strings.Contains(out, "originalPodName") {
+		t.Errorf("Expected no ephemeral container, got:\n%s", out)
+	}
+}
+
+func TestMountNoEphemeralFallback(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce), newWorkloadPod(namespace, "workload", pvcName))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{NoEphemeralFallback: true})
+	})
+	if !errors.Is(err, ErrAccessModeNotUsable) || !strings.Contains(err.Error(), "workload") {
+		t.Fatalf("Expected the PVC in use to be refused, got: %v", err)
+	}
+	assertNoWrites(t, clientset)
+
+	// Unused RWO PVCs don't need an ephemeral container
+	clientset = fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce)...)
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, NoEphemeralFallback: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+	if !strings.Contains(out, "value: standalone") {
+		t.Errorf("Expected a standalone pod for the unused PVC, got:\n%s", out)
+	}
+}
+
+func TestMountReadWriteOncePod(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+
+	for _, assumeRWX := range []bool{false, true} {
+		objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOncePod), newWorkloadPod(namespace, "workload", pvcName))
+		clientset := fake.NewSimpleClientset(objects...)
+
+		var err error
+		out := captureStdout(t, func() {
+			err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, AssumeRWX: assumeRWX})
+		})
+		if err != nil {
+			t.Fatalf("mount() returned an error: %v", err)
+		}
+
+		// Only the pod using the PVC may attach it, so it must be mounted from an ephemeral container in it
+		if !strings.Contains(out, "value: ephemeral") || !strings.Contains(out, "originalPodName: workload") {
+			t.Errorf("Expected an ephemeral container in the pod using the PVC (assume-rwx %v), got:\n%s", assumeRWX, out)
+		}
+		if strings.Contains(out, "claimName: test-pvc") {
+			t.Errorf("Expected no new pod attaching the PVC (assume-rwx %v), got:\n%s", assumeRWX, out)
+		}
+		if assumeRWX && !strings.Contains(out, "ignoring --assume-rwx") {
+			t.Errorf("Expected a warning about --assume-rwx, got:\n%s", out)
+		}
+	}
+}
+
+func TestMountReadWriteOncePodDebugTunnel(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOncePod), newWorkloadPod(namespace, "workload", pvcName))
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		pod.Status.PodIP = "10.1.2.3"
+		return false, nil, nil
+	})
+	clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return action.GetSubresource() == "ephemeralcontainers", nil, nil
+	})
+	useFakeRunner(t, &fakeRunner{})
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{Debug: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	start := strings.Index(out, "Tunnel of the mount:")
+	if start < 0 {
+		t.Fatalf("Expected the tunnel in the debug output, got:\n%s", out)
+	}
+	tunnel := out[start:]
+	for _, want := range []string{
+		"ephemeral container volume-exposer-ephemeral-",
+		"in pod workload: SSH server on port 2137",
+		"PROXY_POD_IP=10.1.2.3",
+		"ssh -N -R 2137:localhost:2137 ve@10.1.2.3 -p 6666",
+		"kubectl port-forward localhost:",
+		"-> port 2137",
+	} {
+		if !strings.Contains(tunnel, want) {
+			t.Errorf("Expected %q in the debug output, got:\n%s", want, tunnel)
+		}
+	}
+	if strings.Contains(tunnel, "PRIVATE KEY") || strings.Contains(tunnel, "ecdsa-sha2") {
+		t.Errorf("Expected no keys in the tunnel details, got:\n%s", tunnel)
+	}
+	if strings.Contains(out, "PRIVATE KEY") {
+		t.Errorf("Expected no private key anywhere in the debug output, got:\n%s", out)
+	}
+	if !strings.Contains(out, "fingerprint SHA256:") {
+		t.Errorf("Expected the fingerprint of the key in the debug output, got:\n%s", out)
+	}
+}
+
+// fakeRunner records the commands of a mount instead of running them.
+type fakeRunner struct {
+	runErr  error
+	started [][]string
+	run     [][]string
+	// exited makes Wait block until it's closed, Wait returns right away with waitErr if unset.
+	exited  chan struct{}
+	waitErr error
+}
+
+func (r *fakeRunner) Run(cmd *exec.Cmd) error {
+	r.run = append(r.run, cmd.Args)
+	return r.runErr
+}
+
+func (r *fakeRunner) Start(cmd *exec.Cmd) error {
+	r.started = append(r.started, cmd.Args)
+	return nil
+}
+
+func (r *fakeRunner) Wait(*exec.Cmd) error {
+	if r.exited != nil {
+		<-r.exited
+	}
+	return r.waitErr
+}
+
+// useFakeRunner replaces the command runner for the duration of the test.
+func useFakeRunner(t *testing.T, r *fakeRunner) {
+	t.Helper()
+	oldRunner, oldSettleTime := runner, portForwardSettleTime
+	runner, portForwardSettleTime = r, 0
+	t.Cleanup(func() {
+		runner, portForwardSettleTime = oldRunner, oldSettleTime
+	})
+}
+
+// useMountTable replaces the mount table read to verify mounts for the duration of the test.
+func useMountTable(t *testing.T, table string) {
+	t.Helper()
+	oldReadMountTable := readMountTable
+	readMountTable = func(string) (string, error) {
+		return table, nil
+	}
+	t.Cleanup(func() {
+		readMountTable = oldReadMountTable
+	})
+}
+
+// markPodsReady makes pods created through the clientset ready right away.
+func markPodsReady(clientset *fake.Clientset) {
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		return false, nil, nil
+	})
+}
+
+func TestMountRunsCommands(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateDir)
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	if len(r.started) != 1 || r.started[0][0] != "kubectl" || r.started[0][1] != "port-forward" {
+		t.Errorf("Expected a port-forward to be started, got %v", r.started)
+	}
+	if len(r.run) != 1 || r.run[0][0] != "sshfs" {
+		t.Fatalf("Expected sshfs to be run, got %v", r.run)
+	}
+	// SSHFS reconnects by default, so the key is kept until clean
+	keyFile := strings.TrimPrefix(r.run[0][2], "IdentityFile=")
+	if expected := filepath.Join(stateDir, "pv-mounter", "keys", "mount_"+mountPointHash(mountPoint)); keyFile != expected {
+		t.Errorf("Expected the private key to be kept at %s, got %s", expected, keyFile)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Errorf("Expected the private key %s to be kept for reconnects: %v", keyFile, err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 1 {
+		t.Errorf("Expected the exposer pod to be kept, got %d pods", len(pods.Items))
+	}
+}
+
+func TestMountCleansUpWhenSSHFSFails(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	useFakeRunner(t, &fakeRunner{runErr: errors.New("connection refused")})
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{})
+	})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected the SSHFS error to be returned, got %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("Expected the exposer pod to be deleted after the failed mount, got %d pods", len(pods.Items))
+	}
+}
+
+func TestMountPVCOverSSHKeepsKeyForReconnects(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	mountKey := func(t *testing.T, r *fakeRunner, opts MountOptions) (string, error) {
+		t.Helper()
+		useFakeRunner(t, r)
+		var err error
+		captureStdout(t, func() {
+			err = mountPVCOverSSH(12345, mountPoint, "test-pvc", "privateKey", opts)
+		})
+		if len(r.run) != 1 {
+			t.Fatalf("Expected sshfs to be run once, got %v", r.run)
+		}
+		return strings.TrimPrefix(r.run[0][2], "IdentityFile="), err
+	}
+
+	keyFile, err := mountKey(t, &fakeRunner{}, MountOptions{})
+	if err != nil {
+		t.Fatalf("mountPVCOverSSH() returned an error: %v", err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Errorf("Expected the private key to be kept for reconnects: %v", err)
+	}
+	removeMountKey(mountPointHash(mountPoint))
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("Expected clean to remove the private key %s", keyFile)
+	}
+
+	keyFile, err = mountKey(t, &fakeRunner{}, MountOptions{KeepAliveInterval: -1})
+	if err != nil {
+		t.Fatalf("mountPVCOverSSH() returned an error: %v", err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the private key %s to be removed without reconnects", keyFile)
+	}
+
+	keyFile, err = mountKey(t, &fakeRunner{runErr: errors.New("connection refused")}, MountOptions{})
+	if err == nil {
+		t.Fatal("Expected the SSHFS error to be returned")
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the private key %s to be removed after the failed mount", keyFile)
+	}
+}
+
+func TestMountCleansUpWhenVerificationFails(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	useFakeRunner(t, &fakeRunner{})
+	useMountTable(t, "/dev/sda1 / ext4 rw 0 0\n")
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, t.TempDir(), MountOptions{})
+	})
+	if err == nil || !strings.Contains(err.Error(), "not a FUSE mount point") {
+		t.Fatalf("Expected the verification error to be returned, got %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("Expected the exposer pod to be deleted after the failed mount, got %d pods", len(pods.Items))
+	}
+}
+
+func TestMountCleansUpWhenInterrupted(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	useFakeRunner(t, &fakeRunner{})
+
+	// The pod never becomes ready, ctrl-C is pressed while waiting for it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset.PrependReactor("get", "pods", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(ctx, clientset, namespace, pvcName, "/mnt/data", MountOptions{})
+	})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("Expected the mount to be interrupted, got %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("Expected the exposer pod to be deleted after the interrupted mount, got %d pods", len(pods.Items))
+	}
+}
+
+func TestChownMountPoint(t *testing.T) {
+	mountPoint := t.TempDir()
+
+	if err := chownMountPoint(mountPoint, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("chownMountPoint() to the current user returned an error: %v", err)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner to another user requires root")
+	}
+	if err := chownMountPoint(mountPoint, 12345, 12345); err != nil {
+		t.Fatalf("chownMountPoint() returned an error: %v", err)
+	}
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		t.Fatalf("Failed to stat mount point: %v", err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && (stat.Uid != 12345 || stat.Gid != 12345) {
+		t.Errorf("Expected owner 12345:12345, got %d:%d", stat.Uid, stat.Gid)
+	}
+}
+
+func TestChownMountPointMissing(t *testing.T) {
+	if err := chownMountPoint(filepath.Join(t.TempDir(), "missing"), os.Getuid(), os.Getgid()); err == nil {
+		t.Error("chownMountPoint() should have returned an error for a missing mount point")
+	}
+}
+
+func TestIsFUSEMount(t *testing.T) {
+	linuxTable := `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda1 /mnt/disk ext4 rw,relatime 0 0
+ve@localhost:/volume /mnt/data fuse.sshfs rw,nosuid,nodev,relatime,user_id=1000,group_id=1000 0 0
+ve@localhost:/volume /mnt/my\040data fuse.sshfs rw,nosuid,nodev 0 0
+`
+	darwinTable := `/dev/disk3s1s1 on / (apfs, sealed, local, read-only, journaled)
+ve@localhost:/volume on /Users/me/data (macfuse, nodev, nosuid, synchronous, mounted by me)
+fuse-t:/volume on /Users/me/fuse-t (nfs, nodev, nosuid, mounted by me)
+ve@localhost:/volume on /Users/me/nfs (nfs, nodev, nosuid, mounted by me)
+ve@10.96.0.10:/volume on /Users/me/service (nfs, nodev, nosuid, mounted by me)
+ve@[::1]:/volume on /Users/me/ipv6 (nfs, nodev, nosuid, mounted by me)
+`
+
+	tests := []struct {
+		name       string
+		goos       string
+		table      string
+		mountPoint string
+		expected   bool
+	}{
+		{"Linux sshfs mount", "linux", linuxTable, "/mnt/data", true},
+		{"Linux escaped mount point", "linux", linuxTable, "/mnt/my data", true},
+		{"Linux non-FUSE mount", "linux", linuxTable, "/mnt/disk", false},
+		{"Linux not mounted", "linux", linuxTable, "/mnt/other", false},
+		{"macOS macFUSE mount", "darwin", darwinTable, "/Users/me/data", true},
+		{"macOS FUSE-T mount", "darwin", darwinTable, "/Users/me/nfs", true},
+		{"macOS FUSE-T mount via service", "darwin", darwinTable, "/Users/me/service", true},
+		{"macOS FUSE-T mount over IPv6", "darwin", darwinTable, "/Users/me/ipv6", true},
+		{"macOS other NFS mount", "darwin", darwinTable, "/Users/me/fuse-t", false},
+		{"macOS non-FUSE mount", "darwin", darwinTable, "/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFUSEMount(tt.goos, tt.table, tt.mountPoint); got != tt.expected {
+				t.Errorf("isFUSEMount(%s) = %v; want %v", tt.mountPoint, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWaitForPodReady(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+
+	t.Run("Ready pod", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		})
+		if err := waitForPodReady(context.Background(), clientset, namespace, podName, time.Second, 0, nil); err != nil {
+			t.Errorf("waitForPodReady() returned an error: %v", err)
+		}
+	})
+
+	t.Run("Short timeout", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable"},
+				},
+			},
+		})
+
+		start := time.Now()
+		err := waitForPodReady(context.Background(), clientset, namespace, podName, 100*time.Millisecond, 0, nil)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected waitForPodReady() to return promptly, took %s", elapsed)
+		}
+		if err == nil {
+			t.Fatal("waitForPodReady() should have returned an error")
+		}
+		for _, expected := range []string{"not ready in time", "phase Pending", "PodScheduled=False (Unschedulable)"} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to contain '%s', got: %v", expected, err)
+			}
+		}
+	})
+}
+
+func TestWaitForPodReadyStreamsEvents(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+	newEvent := func(name, eventType, reason, message string) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: namespace},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: podName, Namespace: namespace},
+			Type:           eventType,
+			Reason:         reason,
+			Message:        message,
+		}
+	}
+
+	for _, all := range []bool{false, true} {
+		t.Run(fmt.Sprintf("all=%v", all), func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+					Status:     corev1.PodStatus{Phase: corev1.PodPending},
+				},
+				newEvent(podName+".1", corev1.EventTypeWarning, "FailedScheduling", "0/3 nodes are available"),
+				newEvent(podName+".2", corev1.EventTypeNormal, "Scheduled", "Successfully assigned"),
+			)
+			// An event occurring together with a status change while waiting is reported as well
+			gets := 0
+			clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				if gets == 2 {
+					_ = clientset.Tracker().Add(newEvent(podName+".3", corev1.EventTypeWarning, "Failed", "ErrImagePull"))
+					_ = clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+						Status: corev1.PodStatus{
+							Phase: corev1.PodPending,
+							ContainerStatuses: []corev1.ContainerStatus{
+								{Name: "volume-exposer", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}}},
+							},
+						},
+					}, namespace)
+				}
+				return false, nil, nil
+			})
+
+			out := captureStdout(t, func() {
+				_ = waitForPodReady(context.Background(), clientset, namespace, podName, 500*time.Millisecond, 0, newPodEventReporter(clientset, namespace, podName, all))
+			})
+
+			for _, expected := range []string{"FailedScheduling: 0/3 nodes are available", "Failed: ErrImagePull"} {
+				if n := strings.Count(out, expected); n != 1 {
+					t.Errorf("Expected %q to be reported once, got %d times in %q", expected, n, out)
+				}
+			}
+			if reported := strings.Contains(out, "Scheduled: Successfully assigned"); reported != all {
+				t.Errorf("Expected normal event reported %v, got output %q", all, out)
+			}
+		})
+	}
+}
+
+func TestWaitForPodReadyListsEventsOnChange(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	})
+	gets, lists := 0, 0
+	clientset.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	clientset.PrependReactor("list", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+
+	captureStdout(t, func() {
+		_ = waitForPodReady(context.Background(), clientset, namespace, podName, 2*time.Second, 0, newPodEventReporter(clientset, namespace, podName, false))
+	})
+
+	// Once while waiting for the unchanged pod, once more for the timeout error
+	if gets < 3 || lists != 2 {
+		t.Errorf("Expected events to be listed twice for %d checks of an unchanged pod, got %d", gets, lists)
+	}
+}
+
+func TestPodReadyBackoffGrows(t *testing.T) {
+	delay := podReadyBackoff.DelayFunc()
+	previous := delay()
+	if previous >= time.Second {
+		t.Errorf("Expected the first check to follow quickly, got %s", previous)
+	}
+
+	for i := 0; i < 20; i++ {
+		next := delay()
+		// Jitter only adds up to 20%, less than the factor, so intervals never shrink below the cap
+		if next < previous && previous < podReadyBackoff.Cap {
+			t.Errorf("Expected interval %d to grow, got %s after %s", i, next, previous)
+		}
+		if next > podReadyBackoff.Cap+time.Duration(float64(podReadyBackoff.Cap)*podReadyBackoff.Jitter) {
+			t.Errorf("Expected interval %d to stay around the cap, got %s", i, next)
+		}
+		previous = next
+	}
+	if previous < podReadyBackoff.Cap {
+		t.Errorf("Expected intervals to reach the cap, got %s", previous)
+	}
+}
+
+func TestWaitForPodReadyDetectsReadinessPromptly(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	})
+	gets := 0
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < 3 {
+			return false, nil, nil
+		}
+		return true, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}, nil
+	})
+
+	start := time.Now()
+	if err := waitForPodReady(context.Background(), clientset, namespace, podName, time.Minute, 0, nil); err != nil {
+		t.Fatalf("waitForPodReady() returned an error: %v", err)
+	}
+	// Two checks at the start of the backoff take well below a second, polling every second took two
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("Expected readiness to be detected promptly, took %s", elapsed)
+	}
+}
+
+func TestWaitForPodReadyReportsStatus(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "volume-exposer",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{
+								Reason:  "ImagePullBackOff",
+								Message: "Back-off pulling image \"bfenski/volume-exposer:typo\"",
+							},
+						},
+					},
+				},
+			},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: podName + ".1", Namespace: namespace},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: podName, Namespace: namespace},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "Failed",
+			Message:        "Failed to pull image \"bfenski/volume-exposer:typo\": not found",
+		},
+	)
+
+	err := waitForPodReady(context.Background(), clientset, namespace, podName, 100*time.Millisecond, 0, nil)
+	if err == nil {
+		t.Fatal("waitForPodReady() should have returned an error")
+	}
+	for _, expected := range []string{
+		"container volume-exposer stuck in ImagePullBackOff",
+		"bfenski/volume-exposer:typo",
+		"events: Failed: Failed to pull image",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain '%s', got: %v", expected, err)
+		}
+	}
+}
+
+func TestWaitForPodReadyPullTimeout(t *testing.T) {
+	namespace := "default"
+	podName := "volume-exposer-abcde"
+	newPod := func(reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "volume-exposer", Image: "bfenski/volume-exposer:typo"}}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "volume-exposer",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "manifest unknown"}},
+				}},
+			},
+		}
+	}
+
+	t.Run("Stuck pull", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newPod("ImagePullBackOff"))
+
+		start := time.Now()
+		err := waitForPodReady(context.Background(), clientset, namespace, podName, time.Minute, 100*time.Millisecond, nil)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected waitForPodReady() to fail early, took %s", elapsed)
+		}
+		if !errors.Is(err, ErrImagePull) {
+			t.Fatalf("Expected ErrImagePull, got %v", err)
+		}
+		for _, expected := range []string{"bfenski/volume-exposer:typo", "ImagePullBackOff", "manifest unknown"} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to contain '%s', got: %v", expected, err)
+			}
+		}
+	})
+
+	t.Run("Other waiting reason", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newPod("ContainerCreating"))
+
+		err := waitForPodReady(context.Background(), clientset, namespace, podName, 300*time.Millisecond, time.Millisecond, nil)
+		if err == nil || errors.Is(err, ErrImagePull) || !strings.Contains(err.Error(), "not ready in time") {
+			t.Errorf("Expected the readiness timeout, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newPod("ErrImagePull"))
+
+		err := waitForPodReady(context.Background(), clientset, namespace, podName, 300*time.Millisecond, 0, nil)
+		if err == nil || errors.Is(err, ErrImagePull) {
+			t.Errorf("Expected the readiness timeout, got %v", err)
+		}
+	})
+}
+
+func TestGetEphemeralContainerSettingsRootFS(t *testing.T) {
+	for _, needsRoot := range []bool{false, true} {
+		_, securityContext := getEphemeralContainerSettings(MountOptions{NeedsRoot: needsRoot})
+		if !*securityContext.ReadOnlyRootFilesystem {
+			t.Errorf("Expected read-only root filesystem by default (needsRoot=%v)", needsRoot)
+		}
+
+		_, securityContext = getEphemeralContainerSettings(MountOptions{NeedsRoot: needsRoot, AllowWritableRootFS: true})
+		if *securityContext.ReadOnlyRootFilesystem {
+			t.Errorf("Expected writable root filesystem with AllowWritableRootFS (needsRoot=%v)", needsRoot)
+		}
+	}
+}
+
+func TestParseSeccompProfile(t *testing.T) {
+	tests := []struct {
+		profile          string
+		expectedType     corev1.SeccompProfileType
+		localhostProfile string
+		wantErr          bool
+	}{
+		{profile: "runtime/default", expectedType: corev1.SeccompProfileTypeRuntimeDefault},
+		{profile: "unconfined", expectedType: corev1.SeccompProfileTypeUnconfined},
+		{profile: "localhost/profiles/audit.json", expectedType: corev1.SeccompProfileTypeLocalhost, localhostProfile: "profiles/audit.json"},
+		{profile: "localhost/", wantErr: true},
+		{profile: "docker/default", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			profile, err := ParseSeccompProfile(tt.profile)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseSeccompProfile(%s) should have returned an error", tt.profile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSeccompProfile(%s) returned an error: %v", tt.profile, err)
+			}
+			if profile.Type != tt.expectedType {
+				t.Errorf("Expected type %s, got %s", tt.expectedType, profile.Type)
+			}
+			if tt.localhostProfile != "" && (profile.LocalhostProfile == nil || *profile.LocalhostProfile != tt.localhostProfile) {
+				t.Errorf("Expected localhost profile %s, got %v", tt.localhostProfile, profile.LocalhostProfile)
+			}
+		})
+	}
+}
+
+func TestSecurityProfilesApplied(t *testing.T) {
+	seccompProfile, err := ParseSeccompProfile("localhost/audit.json")
+	if err != nil {
+		t.Fatalf("ParseSeccompProfile returned an error: %v", err)
+	}
+	appArmorProfile, err := ParseAppArmorProfile("localhost/volume-exposer")
+	if err != nil {
+		t.Fatalf("ParseAppArmorProfile returned an error: %v", err)
+	}
+	opts := MountOptions{SeccompProfile: seccompProfile, AppArmorProfile: appArmorProfile}
+
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", opts)
+	securityContext := podSpec.Spec.Containers[0].SecurityContext
+	if securityContext.SeccompProfile.Type != corev1.SeccompProfileTypeLocalhost || *securityContext.SeccompProfile.LocalhostProfile != "audit.json" {
+		t.Errorf("Unexpected seccomp profile %+v", securityContext.SeccompProfile)
+	}
+	if securityContext.AppArmorProfile.Type != corev1.AppArmorProfileTypeLocalhost || *securityContext.AppArmorProfile.LocalhostProfile != "volume-exposer" {
+		t.Errorf("Unexpected AppArmor profile %+v", securityContext.AppArmorProfile)
+	}
+	annotation := podSpec.Annotations["container.apparmor.security.beta.kubernetes.io/volume-exposer"]
+	if annotation != "localhost/volume-exposer" {
+		t.Errorf("Expected AppArmor annotation 'localhost/volume-exposer', got '%s'", annotation)
+	}
+
+	ephemeral := buildEphemeralContainerSpec("ephemeral", "data", "privateKey", "publicKey", "10.0.0.1", opts)
+	if ephemeral.SecurityContext.SeccompProfile.Type != corev1.SeccompProfileTypeLocalhost {
+		t.Errorf("Expected the ephemeral container to use the seccomp profile, got %+v", ephemeral.SecurityContext.SeccompProfile)
+	}
+
+	defaultSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{})
+	if defaultSpec.Spec.Containers[0].SecurityContext.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Error("Expected RuntimeDefault seccomp profile by default")
+	}
+	if defaultSpec.Spec.Containers[0].SecurityContext.AppArmorProfile != nil {
+		t.Error("Expected no AppArmor profile by default")
+	}
+}
+
+func TestOfflineImagePullPolicy(t *testing.T) {
+	tests := []struct {
+		opts     MountOptions
+		expected corev1.PullPolicy
+	}{
+		{MountOptions{}, corev1.PullAlways},
+		{MountOptions{Offline: true}, corev1.PullIfNotPresent},
+	}
+
+	for _, tt := range tests {
+		for _, role := range []string{"standalone", "proxy"} {
+			podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, DefaultSSHPort, "", tt.opts)
+			if got := podSpec.Spec.Containers[0].ImagePullPolicy; got != tt.expected {
+				t.Errorf("Expected pull policy %s for %s pod with offline=%v, got %s", tt.expected, role, tt.opts.Offline, got)
+			}
+		}
+		ephemeralContainer := buildEphemeralContainerSpec("volume-exposer-ephemeral-abcde", "data", "privateKey", "publicKey", "10.0.0.1", tt.opts)
+		if got := ephemeralContainer.ImagePullPolicy; got != tt.expected {
+			t.Errorf("Expected pull policy %s for ephemeral container with offline=%v, got %s", tt.expected, tt.opts.Offline, got)
+		}
+	}
+}
+
+func TestBuildPodSecurityContextFSGroup(t *testing.T) {
+	securityContext := buildPodSecurityContext(MountOptions{})
+	if securityContext.FSGroup != nil || securityContext.FSGroupChangePolicy != nil {
+		t.Errorf("Expected no fsGroup by default, got %v", securityContext.FSGroup)
+	}
+
+	fsGroup := int64(1000)
+	policy := corev1.FSGroupChangeOnRootMismatch
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", DefaultSSHPort, "", MountOptions{FSGroup: &fsGroup, FSGroupChangePolicy: &policy})
+	securityContext = podSpec.Spec.SecurityContext
+	if securityContext.FSGroup == nil || *securityContext.FSGroup != fsGroup {
+		t.Errorf("Expected fsGroup %d, got %v", fsGroup, securityContext.FSGroup)
+	}
+	if securityContext.FSGroupChangePolicy == nil || *securityContext.FSGroupChangePolicy != policy {
+		t.Errorf("Expected fsGroup change policy %s, got %v", policy, securityContext.FSGroupChangePolicy)
+	}
+
+	invalid := corev1.PodFSGroupChangePolicy("Sometimes")
+	if err := validateMountOptions(MountOptions{FSGroupChangePolicy: &invalid}); err == nil {
+		t.Error("validateMountOptions() should have returned an error for an invalid change policy")
+	}
+}
+
+func TestMountJSON(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	useFakeRunner(t, &fakeRunner{})
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw 0 0\n", mountPoint))
+
+	var result strings.Builder
+	var err error
+	progress := captureStdout(t, func() {
+		err = mountJSON(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{}, &result)
+	})
+	if err != nil {
+		t.Fatalf("mountJSON() returned an error: %v", err)
+	}
+
+	var info map[string]interface{}
+	if err := json.Unmarshal([]byte(result.String()), &info); err != nil {
+		t.Fatalf("Expected JSON, got %q: %v", result.String(), err)
+	}
+	for key, expected := range map[string]interface{}{
+		"namespace":  namespace,
+		"pvc":        pvcName,
+		"backend":    "sshfs",
+		"via":        ViaPortForward,
+		"mountPoint": mountPoint,
+		"phase":      string(corev1.PodRunning),
+	} {
+		if info[key] != expected {
+			t.Errorf("Expected %s to be %v, got %v", key, expected, info[key])
+		}
+	}
+	if pod, _ := info["pod"].(string); !strings.HasPrefix(pod, "volume-exposer-") {
+		t.Errorf("Expected the pod of the mount, got %v", info["pod"])
+	}
+	if port, _ := info["localPort"].(float64); port < 1 {
+		t.Errorf("Expected the local port of the mount, got %v", info["localPort"])
+	}
+
+	if !strings.Contains(progress, "Detected access mode") || strings.Contains(result.String(), "Detected access mode") {
+		t.Errorf("Expected progress messages apart from the JSON, got %q", progress)
+	}
+}
+
+func TestWithStdoutToStderr(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	out := captureStdout(t, func() {
+		err = WithStdoutToStderr(func(stdout io.Writer) error {
+			fmt.Println("progress")
+			_, err := fmt.Fprintln(stdout, "result")
+			return err
+		})
+	})
+	w.Close()
+	errOut, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("WithStdoutToStderr() returned an error: %v", err)
+	}
+	if out != "result\n" {
+		t.Errorf("Expected only the result on stdout, got %q", out)
+	}
+	if string(errOut) != "progress\n" {
+		t.Errorf("Expected the progress on stderr, got %q", string(errOut))
+	}
+}
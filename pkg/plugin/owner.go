@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WorkloadOwner as OwnerRef makes the pod using an RWO PVC the owner of the proxy pod.
+const WorkloadOwner = "workload"
+
+// parseOwnerRef splits an owner given as <kind>/<name>, the kind may be plural or abbreviated like in kubectl.
+func parseOwnerRef(ownerRef string) (string, string, error) {
+	kind, name, found := strings.Cut(ownerRef, "/")
+	if !found || name == "" {
+		return "", "", fmt.Errorf("invalid owner %q, must be %s or <kind>/<name>", ownerRef, WorkloadOwner)
+	}
+
+	kind = strings.ToLower(kind)
+	switch kind {
+	case "pod", "pods", "po":
+		kind = "Pod"
+	case "persistentvolumeclaim", "persistentvolumeclaims", "pvc":
+		kind = "PersistentVolumeClaim"
+	case "deployment", "deployments", "deploy":
+		kind = "Deployment"
+	case "statefulset", "statefulsets", "sts":
+		kind = "StatefulSet"
+	case "job", "jobs":
+		kind = "Job"
+	case "namespace", "namespaces", "ns":
+		kind = "Namespace"
+	case "persistentvolume", "persistentvolumes", "pv":
+		kind = "PersistentVolume"
+	default:
+		return "", "", fmt.Errorf("unsupported owner kind %q, must be one of pod, pvc, deployment, statefulset, job, namespace or pv", kind)
+	}
+	return kind, name, nil
+}
+
+// resolveOwnerReference looks up the owner of the pod created for a mount. Owner references can't
+// cross namespaces, so namespaced owners are looked up in the namespace of the mount, while
+// cluster-scoped ones like namespaces and PVs can own pods in any namespace.
+func resolveOwnerReference(ctx context.Context, clientset kubernetes.Interface, namespace, ownerRef, podUsingPVC string) (*metav1.OwnerReference, error) {
+	if ownerRef == WorkloadOwner {
+		if podUsingPVC == "" {
+			return nil, fmt.Errorf("no pod is using the PVC, so it can't own the created pod")
+		}
+		ownerRef = "pod/" + podUsingPVC
+	}
+
+	kind, name, err := parseOwnerRef(ownerRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj metav1.Object
+	apiVersion := "v1"
+	switch kind {
+	case "Pod":
+		obj, err = clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "PersistentVolumeClaim":
+		obj, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "Deployment":
+		apiVersion = "apps/v1"
+		obj, err = clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "StatefulSet":
+		apiVersion = "apps/v1"
+		obj, err = clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "Job":
+		apiVersion = "batch/v1"
+		obj, err = clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "Namespace":
+		obj, err = clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	case "PersistentVolume":
+		obj, err = clientset.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get owner %s %s: %v", kind, name, err)
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       name,
+		UID:        obj.GetUID(),
+	}, nil
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseOwnerRef(t *testing.T) {
+	tests := []struct {
+		ownerRef string
+		kind     string
+		name     string
+		wantErr  bool
+	}{
+		{ownerRef: "pod/workload", kind: "Pod", name: "workload"},
+		{ownerRef: "deploy/app", kind: "Deployment", name: "app"},
+		{ownerRef: "PVC/data", kind: "PersistentVolumeClaim", name: "data"},
+		{ownerRef: "ns/team", kind: "Namespace", name: "team"},
+		{ownerRef: "workload", wantErr: true},
+		{ownerRef: "pod/", wantErr: true},
+		{ownerRef: "configmap/settings", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ownerRef, func(t *testing.T) {
+			kind, name, err := parseOwnerRef(tt.ownerRef)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseOwnerRef(%s) should have returned an error", tt.ownerRef)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOwnerRef(%s) returned an unexpected error: %v", tt.ownerRef, err)
+			}
+			if kind != tt.kind || name != tt.name {
+				t.Errorf("Expected %s %s, got %s %s", tt.kind, tt.name, kind, name)
+			}
+		})
+	}
+}
+
+func TestResolveOwnerReference(t *testing.T) {
+	namespace := "default"
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "workload", Namespace: namespace, UID: "pod-uid"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace, UID: "deployment-uid"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: "other", UID: "other-uid"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", UID: "namespace-uid"}},
+	)
+
+	tests := []struct {
+		name        string
+		ownerRef    string
+		podUsingPVC string
+		expected    metav1.OwnerReference
+		wantErr     bool
+	}{
+		{
+			name:        "Workload pod",
+			ownerRef:    WorkloadOwner,
+			podUsingPVC: "workload",
+			expected:    metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "workload", UID: "pod-uid"},
+		},
+		{
+			name:     "Namespaced owner",
+			ownerRef: "deployment/app",
+			expected: metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deployment-uid"},
+		},
+		{
+			name:     "Cluster-scoped owner",
+			ownerRef: "namespace/team",
+			expected: metav1.OwnerReference{APIVersion: "v1", Kind: "Namespace", Name: "team", UID: "namespace-uid"},
+		},
+		{name: "No workload", ownerRef: WorkloadOwner, wantErr: true},
+		{name: "Owner in another namespace", ownerRef: "deployment/elsewhere", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := resolveOwnerReference(context.Background(), clientset, namespace, tt.ownerRef, tt.podUsingPVC)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveOwnerReference(%s) should have returned an error", tt.ownerRef)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveOwnerReference(%s) returned an unexpected error: %v", tt.ownerRef, err)
+			}
+			if *ref != tt.expected {
+				t.Errorf("Expected owner reference %+v, got %+v", tt.expected, *ref)
+			}
+		})
+	}
+}
+
+func TestMountSetsOwnerReference(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	workload := newWorkloadPod(namespace, "workload", pvcName)
+	workload.UID = "workload-uid"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce), workload)
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{DryRun: true, OwnerRef: WorkloadOwner})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+	for _, expected := range []string{"ownerReferences:", "kind: Pod", "name: workload", "uid: workload-uid"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected '%s' in the proxy pod, got:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MountPod mounts every PVC used by a workload pod, each into a subdirectory of baseDir named
+// after the PVC. All of them are mounted from ephemeral containers in the pod, like RWO
+// volumes in use, so the volumes are seen exactly as the pod sees them. CleanPod cleans them
+// up again.
+func MountPod(ctx context.Context, namespace, podName, baseDir string, opts MountOptions) error {
+	if err := checkSupportedOS(runtime.GOOS); err != nil {
+		return err
+	}
+	if err := validateMountOptions(opts); err != nil {
+		return err
+	}
+	if opts.AssumeRWX || opts.NoEphemeralFallback {
+		return errors.New("--assume-rwx and --no-ephemeral-fallback can't be used with --pod, its PVCs are mounted from ephemeral containers")
+	}
+	if !opts.DryRun {
+		if err := checkSSHFS(opts.SSHFSPath); err != nil {
+			return err
+		}
+	}
+	if err := validateMountPoint(baseDir); err != nil {
+		return err
+	}
+
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return err
+	}
+	return mountPod(ctx, clientset, namespace, podName, baseDir, opts)
+}
+
+func mountPod(ctx context.Context, clientset kubernetes.Interface, namespace, podName, baseDir string, opts MountOptions) error {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %w", ErrPodNotFound, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s: %v", podName, err)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod %s is %s, its PVCs can only be mounted while it's running", podName, pod.Status.Phase)
+	}
+
+	targets := podMountTargets(pod, baseDir)
+	if len(targets) == 0 {
+		return fmt.Errorf("pod %s uses no PVCs", podName)
+	}
+	if !opts.DryRun {
+		for _, target := range targets {
+			if err := os.MkdirAll(target.LocalMountPoint, 0o755); err != nil {
+				return fmt.Errorf("failed to create mount point %s: %v", target.LocalMountPoint, err)
+			}
+			if !opts.AllowNonEmpty {
+				if err := checkMountPointEmpty(target.LocalMountPoint); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	opts.fromPod = podName
+	return mountBatch(ctx, targets, opts.concurrency(), func(ctx context.Context, target MountTarget) error {
+		return mount(ctx, clientset, namespace, target.PVCName, target.LocalMountPoint, opts)
+	})
+}
+
+// podMountTargets returns a target for every PVC the pod uses, mounted at a subdirectory of
+// baseDir named after the PVC.
+func podMountTargets(pod *corev1.Pod, baseDir string) []MountTarget {
+	var targets []MountTarget
+	seen := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		pvcName := podVolumeClaimName(pod, volume)
+		if pvcName == "" || seen[pvcName] {
+			continue
+		}
+		seen[pvcName] = true
+		targets = append(targets, MountTarget{PVCName: pvcName, LocalMountPoint: filepath.Join(baseDir, pvcName)})
+	}
+	return targets
+}
+
+// CleanPod cleans every mount from ephemeral containers in the workload pod, e.g. those of
+// MountPod.
+func CleanPod(ctx context.Context, namespace, podName string, opts CleanOptions) (*CleanResult, error) {
+	result := &CleanResult{}
+	clientset, err := BuildKubeClient()
+	if err != nil {
+		return result, err
+	}
+	return result, cleanPodMounts(ctx, clientset, namespace, podName, opts, result)
+}
+
+func cleanPodMounts(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, opts CleanOptions, result *CleanResult) error {
+	selector := labels.Set{"originalPodName": podName}.String()
+	if opts.Selector != "" {
+		selector += "," + opts.Selector
+	}
+	opts.Selector = selector
+	return cleanAll(ctx, clientset, namespace, opts, result)
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newMultiPVCPod returns a running pod using the PVCs data and logs, a generic ephemeral
+// volume and an emptyDir.
+func newMultiPVCPod(namespace, podName string) *corev1.Pod {
+	pod := newEphemeralVolumePod(namespace, podName, "scratch")
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+		corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"}}},
+		// The same PVC mounted twice is only exposed once
+		corev1.Volume{Name: "logs-again", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs", ReadOnly: true}}},
+	)
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+func TestPodMountTargets(t *testing.T) {
+	targets := podMountTargets(newMultiPVCPod("default", "workload"), "/mnt/workload")
+	expected := []MountTarget{
+		{PVCName: "workload-scratch", LocalMountPoint: filepath.Join("/mnt/workload", "workload-scratch")},
+		{PVCName: "data", LocalMountPoint: filepath.Join("/mnt/workload", "data")},
+		{PVCName: "logs", LocalMountPoint: filepath.Join("/mnt/workload", "logs")},
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected targets %+v, got %+v", expected, targets)
+	}
+
+	if targets := podMountTargets(&corev1.Pod{}, "/mnt/workload"); len(targets) != 0 {
+		t.Errorf("Expected no targets for a pod without PVCs, got %+v", targets)
+	}
+}
+
+func TestMountPodDryRun(t *testing.T) {
+	namespace := "default"
+	var objects []runtime.Object
+	for _, pvcName := range []string{"workload-scratch", "data", "logs"} {
+		// Even RWX PVCs are mounted from the pod
+		objects = append(objects, newTestObjects(namespace, pvcName, corev1.ReadWriteMany)[0])
+	}
+	objects = append(objects, newMultiPVCPod(namespace, "workload"))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mountPod(context.Background(), clientset, namespace, "workload", "/mnt/workload", MountOptions{DryRun: true, Concurrency: 1})
+	})
+	if err != nil {
+		t.Fatalf("mountPod() returned an error: %v", err)
+	}
+	assertNoWrites(t, clientset)
+
+	if count := strings.Count(out, "# Ephemeral container that would be added to pod workload"); count != 3 {
+		t.Errorf("Expected an ephemeral container for each of the 3 PVCs, got %d:\n%s", count, out)
+	}
+	for _, expected := range []string{"name: scratch", "name: data", "name: logs", "originalPodName: workload", filepath.Join("/mnt/workload", "logs")} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected dry run output to contain '%s', got:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "name: logs-again") {
+		t.Errorf("Expected the PVC mounted twice to be exposed once, got:\n%s", out)
+	}
+}
+
+func TestMountPodNotRunning(t *testing.T) {
+	pod := newMultiPVCPod("default", "workload")
+	pod.Status.Phase = corev1.PodPending
+	clientset := fake.NewSimpleClientset(pod)
+
+	err := mountPod(context.Background(), clientset, "default", "workload", "/mnt/workload", MountOptions{DryRun: true})
+	if err == nil || !strings.Contains(err.Error(), "Pending") {
+		t.Errorf("Expected an error about the pending pod, got %v", err)
+	}
+}
+
+func TestCleanPodMounts(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+	useMountTable(t, "")
+	oldExecInContainer := execInContainer
+	t.Cleanup(func() { execInContainer = oldExecInContainer })
+	execInContainer = func(_ context.Context, _ kubernetes.Interface, _, _, _ string, _ []string) (string, error) {
+		return "", nil
+	}
+
+	workload := newMultiPVCPod("default", "workload")
+	objects := []runtime.Object{workload}
+	for _, pvcName := range []string{"data", "logs"} {
+		container := "volume-exposer-ephemeral-" + pvcName
+		workload.Spec.EphemeralContainers = append(workload.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: container},
+		})
+		proxy := newExposerPod("default", "volume-exposer-proxy-"+pvcName, pvcName, "/mnt/workload/"+pvcName)
+		proxy.Labels["originalPodName"] = "workload"
+		proxy.Annotations = map[string]string{EphemeralContainerAnnotation: container}
+		objects = append(objects, proxy)
+	}
+	other := newExposerPod("default", "volume-exposer-abcde", "other", "/mnt/other")
+	objects = append(objects, other)
+	clientset := fake.NewSimpleClientset(objects...)
+
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanPodMounts(context.Background(), clientset, "default", "workload", CleanOptions{}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanPodMounts() returned an error: %v", err)
+	}
+	if len(result.EphemeralProcessesKilled) != 2 {
+		t.Errorf("Expected the processes of both ephemeral containers to be killed, got %v", result.EphemeralProcessesKilled)
+	}
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	var left []string
+	for _, pod := range pods.Items {
+		left = append(left, pod.Name)
+	}
+	if !reflect.DeepEqual(left, []string{"volume-exposer-abcde", "workload"}) {
+		t.Errorf("Expected only the workload and the unrelated mount to be left, got %v", left)
+	}
+}
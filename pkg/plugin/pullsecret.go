@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// validateImagePullSecrets checks the names of the image pull secrets of the pod.
+func validateImagePullSecrets(opts MountOptions) error {
+	for _, name := range opts.ImagePullSecrets {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+			return fmt.Errorf("invalid image pull secret %q: %s", name, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// checkImagePullSecrets makes sure the image pull secrets exist in the namespace. Otherwise
+// the pod is created fine, but only fails to pull its image once the kubelet tries, which
+// takes until the pull timeout to notice.
+func checkImagePullSecrets(ctx context.Context, clientset kubernetes.Interface, namespace string, opts MountOptions) error {
+	if opts.SkipImagePullSecretCheck {
+		return nil
+	}
+	for _, name := range opts.ImagePullSecrets {
+		err := retryAPICall(opts.APIRetries, func() error {
+			_, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s in namespace %s, create it first or use --skip-image-secret-check if it's created concurrently", ErrImagePullSecretNotFound, name, namespace)
+		}
+		if apierrors.IsForbidden(err) {
+			// Pods can use secrets their creator isn't allowed to read
+			fmt.Printf("Warning: failed to check image pull secret %s of namespace %s: %v\n", name, namespace, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get image pull secret %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// buildImagePullSecrets returns the image pull secrets of the pod, nil if there are none.
+func buildImagePullSecrets(opts MountOptions) []corev1.LocalObjectReference {
+	var secrets []corev1.LocalObjectReference
+	for _, name := range opts.ImagePullSecrets {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: name})
+	}
+	return secrets
+}
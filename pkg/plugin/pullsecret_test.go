@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckImagePullSecrets(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"}}
+	clientset := fake.NewSimpleClientset(secret)
+	ctx := context.Background()
+
+	if err := checkImagePullSecrets(ctx, clientset, "default", MountOptions{ImagePullSecrets: []string{"registry"}}); err != nil {
+		t.Errorf("Expected the existing secret to be accepted, got %v", err)
+	}
+
+	opts := MountOptions{ImagePullSecrets: []string{"registry", "mirror"}}
+	if err := checkImagePullSecrets(ctx, clientset, "default", opts); !errors.Is(err, ErrImagePullSecretNotFound) {
+		t.Errorf("Expected ErrImagePullSecretNotFound for the missing secret, got %v", err)
+	}
+	// Secrets of other namespaces can't be used by the pod
+	if err := checkImagePullSecrets(ctx, clientset, "other", MountOptions{ImagePullSecrets: []string{"registry"}}); !errors.Is(err, ErrImagePullSecretNotFound) {
+		t.Errorf("Expected ErrImagePullSecretNotFound for the secret of another namespace, got %v", err)
+	}
+
+	opts.SkipImagePullSecretCheck = true
+	if err := checkImagePullSecrets(ctx, clientset, "default", opts); err != nil {
+		t.Errorf("Expected the check to be skipped, got %v", err)
+	}
+}
+
+func TestCheckImagePullSecretsForbidden(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "registry", errors.New("no RBAC"))
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = checkImagePullSecrets(context.Background(), clientset, "default", MountOptions{ImagePullSecrets: []string{"registry", "mirror"}})
+	})
+	if err != nil {
+		t.Errorf("Expected secrets that can't be read to be let through, got %v", err)
+	}
+	for _, name := range []string{"registry", "mirror"} {
+		if !strings.Contains(out, "Warning: failed to check image pull secret "+name) {
+			t.Errorf("Expected a warning for secret %s, got %q", name, out)
+		}
+	}
+}
+
+func TestMountMissingImagePullSecret(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{ImagePullSecrets: []string{"registry"}})
+	})
+	if !errors.Is(err, ErrImagePullSecretNotFound) {
+		t.Errorf("Expected ErrImagePullSecretNotFound, got %v", err)
+	}
+	assertNoWrites(t, clientset)
+}
+
+func TestCreatePodSpecImagePullSecrets(t *testing.T) {
+	podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", "standalone", 22, "", MountOptions{})
+	if podSpec.Spec.ImagePullSecrets != nil {
+		t.Errorf("Expected no image pull secrets by default, got %+v", podSpec.Spec.ImagePullSecrets)
+	}
+
+	opts := MountOptions{ImagePullSecrets: []string{"registry", "mirror"}}
+	expected := []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}
+	for _, role := range []string{"standalone", "proxy"} {
+		podSpec := createPodSpec("test-pod", 12345, "test-pvc", "/mnt/data", "publicKey", role, 22, "", opts)
+		if !reflect.DeepEqual(podSpec.Spec.ImagePullSecrets, expected) {
+			t.Errorf("Expected the image pull secrets %+v on the %s pod, got %+v", expected, role, podSpec.Spec.ImagePullSecrets)
+		}
+	}
+
+	if err := validateMountOptions(MountOptions{ImagePullSecrets: []string{"Registry_Secret"}}); err == nil {
+		t.Error("Expected an invalid secret name to be rejected")
+	}
+}
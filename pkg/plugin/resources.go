@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// buildResourceRequirements returns the resources of the container exposing the volume,
+// with the limits of the options in place of the defaults.
+func buildResourceRequirements(opts MountOptions) corev1.ResourceRequirements {
+	resources, _ := buildResources(opts)
+	return resources
+}
+
+// buildResources is buildResourceRequirements, also returning descriptions of the default
+// requests lowered to limits of the options below them, which would get the pod rejected.
+func buildResources(opts MountOptions) (corev1.ResourceRequirements, []string) {
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse(CPURequest),
+			corev1.ResourceMemory:           resource.MustParse(MemoryRequest),
+			corev1.ResourceEphemeralStorage: resource.MustParse(EphemeralStorageRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory:           resource.MustParse(MemoryLimit),
+			corev1.ResourceEphemeralStorage: resource.MustParse(EphemeralStorageLimit),
+		},
+	}
+	if opts.CPULimit != nil {
+		resources.Limits[corev1.ResourceCPU] = opts.CPULimit.DeepCopy()
+	}
+	if opts.MemoryLimit != nil {
+		resources.Limits[corev1.ResourceMemory] = opts.MemoryLimit.DeepCopy()
+	}
+	return resources, lowerRequestsToLimits(resources)
+}
+
+// lowerRequestsToLimits lowers the requests above their limit to the limit and returns
+// descriptions of what was lowered.
+func lowerRequestsToLimits(resources corev1.ResourceRequirements) []string {
+	var lowered []string
+	for _, name := range sortedResourceNames(resources.Requests) {
+		request := resources.Requests[name]
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			lowered = append(lowered, fmt.Sprintf("%s limit %s is below the default request %s, lowering the request to it", name, limit.String(), request.String()))
+			resources.Requests[name] = limit.DeepCopy()
+		}
+	}
+	return lowered
+}
+
+// ParseResourceLimit parses a CPU or memory limit like 500m or 256Mi. Limits have to be positive.
+func ParseResourceLimit(value string) (*resource.Quantity, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quantity %q, expected e.g. 500m or 256Mi", value)
+	}
+	if quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid quantity %q, must be positive", value)
+	}
+	return &quantity, nil
+}
+
+// validateResourceLimits checks the limits set on the options by library callers, which
+// don't go through ParseResourceLimit.
+func validateResourceLimits(opts MountOptions) error {
+	if opts.CPULimit != nil && opts.CPULimit.Sign() <= 0 {
+		return fmt.Errorf("invalid CPU limit %s, must be positive", opts.CPULimit.String())
+	}
+	if opts.MemoryLimit != nil && opts.MemoryLimit.Sign() <= 0 {
+		return fmt.Errorf("invalid memory limit %s, must be positive", opts.MemoryLimit.String())
+	}
+	return nil
+}
+
+// resolveResources checks the resources of the pod against the LimitRanges and ResourceQuotas
+// of the namespace, whose admission would otherwise reject the pod with a confusing error.
+// Violations are only reported, unless AutoAdjustResources is set to move the resources into
+// the range the LimitRanges allow. Exceeded quotas can't be adjusted for.
+func resolveResources(ctx context.Context, clientset kubernetes.Interface, namespace string, opts MountOptions) corev1.ResourceRequirements {
+	resources, lowered := buildResources(opts)
+	for _, description := range lowered {
+		fmt.Printf("Warning: %s\n", description)
+	}
+	resources, limitRanges := checkLimitRanges(ctx, clientset, namespace, resources, opts)
+	// Quotas are checked after the LimitRanges filled in their defaults
+	checkResourceQuotas(ctx, clientset, namespace, withLimitRangeDefaults(resources, limitRanges), opts)
+	return resources
+}
+
+func checkLimitRanges(ctx context.Context, clientset kubernetes.Interface, namespace string, resources corev1.ResourceRequirements, opts MountOptions) (corev1.ResourceRequirements, []corev1.LimitRange) {
+
+	var limitRanges *corev1.LimitRangeList
+	err := retryAPICall(opts.APIRetries, func() error {
+		var err error
+		limitRanges, err = clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		// Not being allowed to list them doesn't mean the pod violates them
+		fmt.Printf("Warning: failed to check LimitRanges of namespace %s: %v\n", namespace, err)
+		return resources, nil
+	}
+
+	adjusted, violations := fitLimitRanges(resources, limitRanges.Items)
+	if len(violations) == 0 {
+		return resources, limitRanges.Items
+	}
+	for _, violation := range violations {
+		if opts.AutoAdjustResources {
+			fmt.Printf("Adjusting resources of the pod: %s\n", violation)
+		} else {
+			fmt.Printf("Warning: %s, the pod will likely be rejected, use --auto-adjust-resources to adjust it\n", violation)
+		}
+	}
+	if opts.AutoAdjustResources {
+		return adjusted, limitRanges.Items
+	}
+	return resources, limitRanges.Items
+}
+
+// fitLimitRanges moves the resources into the minimums and maximums of the LimitRanges that
+// apply to containers and pods, the pod only has one container. It returns the adjusted
+// resources and descriptions of what violated the LimitRanges.
+func fitLimitRanges(resources corev1.ResourceRequirements, limitRanges []corev1.LimitRange) (corev1.ResourceRequirements, []string) {
+	adjusted := *resources.DeepCopy()
+	var violations []string
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer && item.Type != corev1.LimitTypePod {
+				continue
+			}
+			for _, name := range sortedResourceNames(item.Min) {
+				minimum := item.Min[name]
+				for kind, list := range map[string]corev1.ResourceList{"request": adjusted.Requests, "limit": adjusted.Limits} {
+					if quantity, ok := list[name]; ok && quantity.Cmp(minimum) < 0 {
+						violations = append(violations, fmt.Sprintf("%s %s %s is below the minimum %s of LimitRange %s", name, kind, quantity.String(), minimum.String(), limitRange.Name))
+						list[name] = minimum.DeepCopy()
+					}
+				}
+			}
+			for _, name := range sortedResourceNames(item.Max) {
+				maximum := item.Max[name]
+				for kind, list := range map[string]corev1.ResourceList{"request": adjusted.Requests, "limit": adjusted.Limits} {
+					if quantity, ok := list[name]; ok && quantity.Cmp(maximum) > 0 {
+						violations = append(violations, fmt.Sprintf("%s %s %s is above the maximum %s of LimitRange %s", name, kind, quantity.String(), maximum.String(), limitRange.Name))
+						list[name] = maximum.DeepCopy()
+					}
+				}
+			}
+		}
+	}
+
+	// A raised request must not end up above its limit
+	for name, request := range adjusted.Requests {
+		if limit, ok := adjusted.Limits[name]; ok && request.Cmp(limit) > 0 {
+			adjusted.Limits[name] = request.DeepCopy()
+		}
+	}
+	sort.Strings(violations)
+	return adjusted, violations
+}
+
+func checkResourceQuotas(ctx context.Context, clientset kubernetes.Interface, namespace string, resources corev1.ResourceRequirements, opts MountOptions) {
+	var quotas *corev1.ResourceQuotaList
+	err := retryAPICall(opts.APIRetries, func() error {
+		var err error
+		quotas, err = clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to check ResourceQuotas of namespace %s: %v\n", namespace, err)
+		return
+	}
+	for _, violation := range fitResourceQuotas(resources, quotas.Items) {
+		fmt.Printf("Warning: %s, the pod will likely be rejected\n", violation)
+	}
+}
+
+// withLimitRangeDefaults returns the resources with the defaults of the container LimitRanges
+// filled in for what they don't set, like admission does.
+func withLimitRangeDefaults(resources corev1.ResourceRequirements, limitRanges []corev1.LimitRange) corev1.ResourceRequirements {
+	defaulted := *resources.DeepCopy()
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for name, quantity := range item.DefaultRequest {
+				if _, ok := defaulted.Requests[name]; !ok {
+					defaulted.Requests[name] = quantity.DeepCopy()
+				}
+			}
+			for name, quantity := range item.Default {
+				if _, ok := defaulted.Limits[name]; !ok {
+					defaulted.Limits[name] = quantity.DeepCopy()
+				}
+			}
+		}
+	}
+	return defaulted
+}
+
+// quotaResources maps the resources a ResourceQuota can limit to the requests or limits of
+// the pod they count.
+var quotaResources = map[corev1.ResourceName]struct {
+	kind string
+	name corev1.ResourceName
+}{
+	corev1.ResourceCPU:                      {"request", corev1.ResourceCPU},
+	corev1.ResourceMemory:                   {"request", corev1.ResourceMemory},
+	corev1.ResourceEphemeralStorage:         {"request", corev1.ResourceEphemeralStorage},
+	corev1.ResourceRequestsCPU:              {"request", corev1.ResourceCPU},
+	corev1.ResourceRequestsMemory:           {"request", corev1.ResourceMemory},
+	corev1.ResourceRequestsEphemeralStorage: {"request", corev1.ResourceEphemeralStorage},
+	corev1.ResourceLimitsCPU:                {"limit", corev1.ResourceCPU},
+	corev1.ResourceLimitsMemory:             {"limit", corev1.ResourceMemory},
+	corev1.ResourceLimitsEphemeralStorage:   {"limit", corev1.ResourceEphemeralStorage},
+}
+
+// fitResourceQuotas returns descriptions of how the pod doesn't fit into the ResourceQuotas:
+// quotas on a resource require pods to set it, and what's left of the quota has to cover the pod.
+func fitResourceQuotas(resources corev1.ResourceRequirements, quotas []corev1.ResourceQuota) []string {
+	var violations []string
+	for _, quota := range quotas {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			// Whether scoped quotas apply to the pod is up to admission
+			continue
+		}
+		for _, name := range sortedResourceNames(quota.Spec.Hard) {
+			hard := quota.Spec.Hard[name]
+			used := quota.Status.Used[name]
+
+			var needed resource.Quantity
+			if name == corev1.ResourcePods {
+				needed = *resource.NewQuantity(1, resource.DecimalSI)
+			} else if counted, ok := quotaResources[name]; ok {
+				list := resources.Requests
+				if counted.kind == "limit" {
+					list = resources.Limits
+				}
+				quantity, ok := list[counted.name]
+				if !ok {
+					hint := ""
+					if counted.name == corev1.ResourceCPU {
+						hint = ", use --cpu-limit to set it"
+					}
+					violations = append(violations, fmt.Sprintf("ResourceQuota %s requires a %s %s, which the pod doesn't set%s", quota.Name, counted.name, counted.kind, hint))
+					continue
+				}
+				needed = quantity
+			} else {
+				continue
+			}
+
+			remaining := hard.DeepCopy()
+			remaining.Sub(used)
+			if remaining.Cmp(needed) < 0 {
+				violations = append(violations, fmt.Sprintf("%s %s of ResourceQuota %s is left, the pod needs %s", remaining.String(), name, quota.Name, needed.String()))
+			}
+		}
+	}
+	return violations
+}
+
+func sortedResourceNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
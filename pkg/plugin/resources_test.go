@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newLimitRange(namespace string, limitType corev1.LimitType, min, max corev1.ResourceList) *corev1.LimitRange {
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: namespace},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{Type: limitType, Min: min, Max: max}},
+		},
+	}
+}
+
+func assertQuantity(t *testing.T, list corev1.ResourceList, name corev1.ResourceName, expected string) {
+	t.Helper()
+	quantity, ok := list[name]
+	if !ok {
+		t.Errorf("Expected %s to be %s, got none", name, expected)
+		return
+	}
+	if quantity.Cmp(resource.MustParse(expected)) != 0 {
+		t.Errorf("Expected %s to be %s, got %s", name, expected, quantity.String())
+	}
+}
+
+func TestFitLimitRanges(t *testing.T) {
+	t.Run("Minimum above the defaults", func(t *testing.T) {
+		limitRange := newLimitRange("default", corev1.LimitTypeContainer, corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		}, nil)
+
+		adjusted, violations := fitLimitRanges(buildResourceRequirements(MountOptions{}), []corev1.LimitRange{*limitRange})
+		if len(violations) != 3 {
+			t.Errorf("Expected the CPU request and the memory request and limit to violate the minimum, got %v", violations)
+		}
+		assertQuantity(t, adjusted.Requests, corev1.ResourceCPU, "100m")
+		assertQuantity(t, adjusted.Requests, corev1.ResourceMemory, "128Mi")
+		assertQuantity(t, adjusted.Limits, corev1.ResourceMemory, "128Mi")
+		if _, ok := adjusted.Limits[corev1.ResourceCPU]; ok {
+			t.Error("Expected no CPU limit to be added")
+		}
+		assertQuantity(t, adjusted.Requests, corev1.ResourceEphemeralStorage, EphemeralStorageRequest)
+	})
+
+	t.Run("Raised request above the limit", func(t *testing.T) {
+		limitRange := newLimitRange("default", corev1.LimitTypePod, corev1.ResourceList{
+			corev1.ResourceEphemeralStorage: resource.MustParse("1500Ki"),
+		}, nil)
+
+		adjusted, _ := fitLimitRanges(buildResourceRequirements(MountOptions{}), []corev1.LimitRange{*limitRange})
+		assertQuantity(t, adjusted.Requests, corev1.ResourceEphemeralStorage, "1500Ki")
+		assertQuantity(t, adjusted.Limits, corev1.ResourceEphemeralStorage, EphemeralStorageLimit)
+	})
+
+	t.Run("Maximum below the defaults", func(t *testing.T) {
+		limitRange := newLimitRange("default", corev1.LimitTypeContainer, nil, corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		})
+
+		adjusted, violations := fitLimitRanges(buildResourceRequirements(MountOptions{}), []corev1.LimitRange{*limitRange})
+		if len(violations) != 1 || !strings.Contains(violations[0], "memory limit 100Mi is above the maximum 64Mi") {
+			t.Errorf("Expected the memory limit to violate the maximum, got %v", violations)
+		}
+		assertQuantity(t, adjusted.Requests, corev1.ResourceMemory, MemoryRequest)
+		assertQuantity(t, adjusted.Limits, corev1.ResourceMemory, "64Mi")
+	})
+
+	t.Run("Other limit types and fitting resources", func(t *testing.T) {
+		pvcLimit := newLimitRange("default", corev1.LimitTypePersistentVolumeClaim, corev1.ResourceList{
+			corev1.ResourceStorage: resource.MustParse("1Gi"),
+		}, nil)
+		fitting := newLimitRange("default", corev1.LimitTypeContainer, corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1m"),
+		}, corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		})
+
+		_, violations := fitLimitRanges(buildResourceRequirements(MountOptions{}), []corev1.LimitRange{*pvcLimit, *fitting})
+		if len(violations) != 0 {
+			t.Errorf("Expected no violations, got %v", violations)
+		}
+	})
+}
+
+func TestResolveResources(t *testing.T) {
+	limitRange := newLimitRange("default", corev1.LimitTypeContainer, corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("100m"),
+	}, nil)
+
+	for _, autoAdjust := range []bool{false, true} {
+		t.Run(fmt.Sprintf("auto-adjust=%v", autoAdjust), func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(limitRange)
+
+			var resources corev1.ResourceRequirements
+			out := captureStdout(t, func() {
+				resources = resolveResources(context.Background(), clientset, "default", MountOptions{AutoAdjustResources: autoAdjust})
+			})
+
+			expected := CPURequest
+			if autoAdjust {
+				expected = "100m"
+			}
+			assertQuantity(t, resources.Requests, corev1.ResourceCPU, expected)
+			if !strings.Contains(out, "cpu request 10m is below the minimum 100m of LimitRange limits") {
+				t.Errorf("Expected the violation to be reported, got %q", out)
+			}
+			if warned := strings.Contains(out, "--auto-adjust-resources"); warned == autoAdjust {
+				t.Errorf("Expected a hint at --auto-adjust-resources only without it, got %q", out)
+			}
+		})
+	}
+
+	t.Run("Other namespace", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(limitRange)
+		var resources corev1.ResourceRequirements
+		out := captureStdout(t, func() {
+			resources = resolveResources(context.Background(), clientset, "other", MountOptions{AutoAdjustResources: true})
+		})
+		assertQuantity(t, resources.Requests, corev1.ResourceCPU, CPURequest)
+		if out != "" {
+			t.Errorf("Expected nothing to be reported, got %q", out)
+		}
+	})
+}
+
+func TestMountAutoAdjustResources(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteMany), newLimitRange(namespace, corev1.LimitTypeContainer, corev1.ResourceList{
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}, nil))
+	clientset := fake.NewSimpleClientset(objects...)
+	markPodsReady(clientset)
+	useFakeRunner(t, &fakeRunner{})
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw 0 0\n", mountPoint))
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{AutoAdjustResources: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(pods.Items) != 1 {
+		t.Fatalf("Expected the exposer pod, got %v (%v)", pods, err)
+	}
+	resources := pods.Items[0].Spec.Containers[0].Resources
+	assertQuantity(t, resources.Requests, corev1.ResourceMemory, "128Mi")
+	assertQuantity(t, resources.Limits, corev1.ResourceMemory, "128Mi")
+}
+
+func TestBuildResourceRequirements(t *testing.T) {
+	cpuLimit := resource.MustParse("500m")
+	memoryLimit := resource.MustParse("256Mi")
+	resources := buildResourceRequirements(MountOptions{CPULimit: &cpuLimit, MemoryLimit: &memoryLimit})
+	assertQuantity(t, resources.Limits, corev1.ResourceCPU, "500m")
+	assertQuantity(t, resources.Limits, corev1.ResourceMemory, "256Mi")
+	assertQuantity(t, resources.Requests, corev1.ResourceCPU, CPURequest)
+
+	if _, ok := buildResourceRequirements(MountOptions{}).Limits[corev1.ResourceCPU]; ok {
+		t.Error("Expected no CPU limit by default")
+	}
+}
+
+func newResourceQuota(hard, used corev1.ResourceList) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+		Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+	}
+}
+
+func TestFitResourceQuotas(t *testing.T) {
+	t.Run("Enough left", func(t *testing.T) {
+		quota := newResourceQuota(corev1.ResourceList{
+			corev1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+			corev1.ResourcePods:           resource.MustParse("10"),
+		}, corev1.ResourceList{
+			corev1.ResourceRequestsMemory: resource.MustParse("512Mi"),
+			corev1.ResourcePods:           resource.MustParse("9"),
+		})
+		if violations := fitResourceQuotas(buildResourceRequirements(MountOptions{}), []corev1.ResourceQuota{*quota}); len(violations) != 0 {
+			t.Errorf("Expected the pod to fit, got %v", violations)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		quota := newResourceQuota(corev1.ResourceList{
+			corev1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+			corev1.ResourcePods:           resource.MustParse("10"),
+		}, corev1.ResourceList{
+			corev1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+			corev1.ResourcePods:           resource.MustParse("10"),
+		})
+		violations := fitResourceQuotas(buildResourceRequirements(MountOptions{}), []corev1.ResourceQuota{*quota})
+		if len(violations) != 2 || !strings.Contains(violations[0], "0 pods of ResourceQuota quota is left, the pod needs 1") {
+			t.Errorf("Expected the pods and memory quota to be exceeded, got %v", violations)
+		}
+	})
+
+	t.Run("CPU limit required", func(t *testing.T) {
+		quota := newResourceQuota(corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse("2")}, nil)
+		violations := fitResourceQuotas(buildResourceRequirements(MountOptions{}), []corev1.ResourceQuota{*quota})
+		if len(violations) != 1 || !strings.Contains(violations[0], "--cpu-limit") {
+			t.Errorf("Expected a hint at --cpu-limit, got %v", violations)
+		}
+
+		cpuLimit := resource.MustParse("100m")
+		if violations := fitResourceQuotas(buildResourceRequirements(MountOptions{CPULimit: &cpuLimit}), []corev1.ResourceQuota{*quota}); len(violations) != 0 {
+			t.Errorf("Expected the CPU limit to satisfy the quota, got %v", violations)
+		}
+	})
+
+	t.Run("Scoped quota", func(t *testing.T) {
+		quota := newResourceQuota(corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse("2")}, nil)
+		quota.Spec.Scopes = []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeNotBestEffort}
+		if violations := fitResourceQuotas(buildResourceRequirements(MountOptions{}), []corev1.ResourceQuota{*quota}); len(violations) != 0 {
+			t.Errorf("Expected scoped quotas to be skipped, got %v", violations)
+		}
+	})
+}
+
+func TestResolveResourcesQuota(t *testing.T) {
+	quota := newResourceQuota(corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse("2")}, nil)
+
+	t.Run("Without a default", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(quota)
+		out := captureStdout(t, func() {
+			resolveResources(context.Background(), clientset, "default", MountOptions{})
+		})
+		if !strings.Contains(out, "Warning: ResourceQuota quota requires a cpu limit") {
+			t.Errorf("Expected the missing CPU limit to be reported, got %q", out)
+		}
+	})
+
+	t.Run("LimitRange default", func(t *testing.T) {
+		limitRange := newLimitRange("default", corev1.LimitTypeContainer, nil, nil)
+		limitRange.Spec.Limits[0].Default = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+		clientset := fake.NewSimpleClientset(quota, limitRange)
+		out := captureStdout(t, func() {
+			resolveResources(context.Background(), clientset, "default", MountOptions{})
+		})
+		if out != "" {
+			t.Errorf("Expected the default of the LimitRange to satisfy the quota, got %q", out)
+		}
+	})
+}
+
+func TestParseResourceLimit(t *testing.T) {
+	for value, expected := range map[string]string{"500m": "500m", "1": "1", "256Mi": "256Mi"} {
+		quantity, err := ParseResourceLimit(value)
+		if err != nil {
+			t.Errorf("ParseResourceLimit(%q) returned an error: %v", value, err)
+			continue
+		}
+		if quantity.String() != expected {
+			t.Errorf("Expected %q to parse as %s, got %s", value, expected, quantity.String())
+		}
+	}
+
+	for _, value := range []string{"500mm", "abc", "", "1.5.0Gi", "0", "-100m"} {
+		if _, err := ParseResourceLimit(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestValidateResourceLimits(t *testing.T) {
+	negative := resource.MustParse("-1")
+	if err := validateMountOptions(MountOptions{CPULimit: &negative}); err == nil {
+		t.Error("Expected a negative CPU limit to be rejected")
+	}
+	zero := resource.MustParse("0")
+	if err := validateMountOptions(MountOptions{MemoryLimit: &zero}); err == nil {
+		t.Error("Expected a zero memory limit to be rejected")
+	}
+	limit := resource.MustParse("500m")
+	if err := validateMountOptions(MountOptions{CPULimit: &limit}); err != nil {
+		t.Errorf("Expected a positive CPU limit to be accepted, got %v", err)
+	}
+}
+
+func TestBuildResourcesLimitBelowRequest(t *testing.T) {
+	cpuLimit := resource.MustParse("5m")
+	memoryLimit := resource.MustParse("32Mi")
+	resources, lowered := buildResources(MountOptions{CPULimit: &cpuLimit, MemoryLimit: &memoryLimit})
+	assertQuantity(t, resources.Requests, corev1.ResourceCPU, "5m")
+	assertQuantity(t, resources.Requests, corev1.ResourceMemory, "32Mi")
+	assertQuantity(t, resources.Limits, corev1.ResourceCPU, "5m")
+	if len(lowered) != 2 || !strings.Contains(lowered[0], "cpu limit 5m is below the default request "+CPURequest) {
+		t.Errorf("Expected the lowered requests to be described, got %v", lowered)
+	}
+
+	cpuLimit = resource.MustParse("500m")
+	resources, lowered = buildResources(MountOptions{CPULimit: &cpuLimit})
+	assertQuantity(t, resources.Requests, corev1.ResourceCPU, CPURequest)
+	if len(lowered) != 0 {
+		t.Errorf("Expected nothing to be lowered for limits above the requests, got %v", lowered)
+	}
+}
+
+func TestResolveResourcesWarnsAboutLimitBelowRequest(t *testing.T) {
+	cpuLimit := resource.MustParse("1m")
+	clientset := fake.NewSimpleClientset()
+
+	var resources corev1.ResourceRequirements
+	out := captureStdout(t, func() {
+		resources = resolveResources(context.Background(), clientset, "default", MountOptions{CPULimit: &cpuLimit})
+	})
+	if !strings.Contains(out, "Warning: cpu limit 1m is below the default request") {
+		t.Errorf("Expected a warning about the CPU limit, got %q", out)
+	}
+	assertQuantity(t, resources.Requests, corev1.ResourceCPU, "1m")
+}
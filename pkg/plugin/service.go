@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// How the SSH server of the pod is reached, see MountOptions.Via.
+const (
+	ViaPortForward = "port-forward"
+	ViaService     = "service"
+)
+
+// exposePod makes the SSH server of the pod reachable for SSHFS, either through a port-forward
+// to the local port or through a Service. It returns the port-forward if one was started,
+// and the host and port to connect to.
+func exposePod(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, port, remotePort int, opts MountOptions) (*exec.Cmd, string, int, error) {
+	if opts.Via != ViaService {
+		defer opts.timer.start("setupPortForwarding")()
+		portForward, err := setupPortForwarding(namespace, podName, port, remotePort, opts.Address)
+		return portForward, forwardHost(opts.Address), port, err
+	}
+
+	defer opts.timer.start("setupService")()
+	host, servicePort, err := setupService(ctx, clientset, namespace, podName, remotePort, opts)
+	return nil, host, servicePort, err
+}
+
+// setupService creates a Service of the pod named after it. The pod owns the Service,
+// so it's garbage collected with the pod even if clean never runs.
+func setupService(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, remotePort int, opts MountOptions) (string, int, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get pod %s: %v", podName, err)
+	}
+
+	service := buildServiceSpec(pod, remotePort, opts.serviceType())
+	err = retryAPICall(opts.APIRetries, func() error {
+		created, err := clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
+		if err == nil {
+			service = created
+		}
+		return err
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create service: %v", err)
+	}
+	fmt.Printf("Service %s created successfully\n", service.Name)
+
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		service, err = waitForLoadBalancer(ctx, clientset, namespace, service.Name, opts.waitReadyTimeout())
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return serviceAddress(service, pod, remotePort)
+}
+
+func buildServiceSpec(pod *corev1.Pod, remotePort int, serviceType corev1.ServiceType) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Labels:    map[string]string{"app": "volume-exposer"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			}},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: serviceType,
+			// The labels of an exposer pod include its random local port, so they select only that pod
+			Selector: pod.Labels,
+			Ports: []corev1.ServicePort{{
+				Name:       "ssh",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       int32(remotePort),
+				TargetPort: intstr.FromInt32(int32(remotePort)),
+			}},
+		},
+	}
+}
+
+// serviceAddress returns the host and port SSHFS connects to for the type of the Service.
+// Node ports are reached on the node running the pod, the only one known to be reachable.
+func serviceAddress(service *corev1.Service, pod *corev1.Pod, remotePort int) (string, int, error) {
+	switch service.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		if pod.Status.HostIP == "" || len(service.Spec.Ports) == 0 || service.Spec.Ports[0].NodePort == 0 {
+			return "", 0, fmt.Errorf("service %s has no node port on the node of pod %s", service.Name, pod.Name)
+		}
+		return pod.Status.HostIP, int(service.Spec.Ports[0].NodePort), nil
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, remotePort, nil
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, remotePort, nil
+			}
+		}
+		return "", 0, fmt.Errorf("service %s has no load balancer address", service.Name)
+	default:
+		if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+			return "", 0, fmt.Errorf("service %s has no cluster IP", service.Name)
+		}
+		return service.Spec.ClusterIP, remotePort, nil
+	}
+}
+
+// waitForLoadBalancer waits until the load balancer of the Service got an address.
+func waitForLoadBalancer(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string, timeout time.Duration) (*corev1.Service, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var service *corev1.Service
+	err := podReadyBackoff.DelayFunc().Until(waitCtx, true, false, func(ctx context.Context) (bool, error) {
+		var err error
+		service, err = clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(service.Status.LoadBalancer.Ingress) > 0, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, fmt.Errorf("timed out after %s waiting for the load balancer of service %s", timeout, serviceName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %s: %v", serviceName, err)
+	}
+	return service, nil
+}
+
+// deleteService deletes the Service created for the pod. Services that are already gone are fine.
+func deleteService(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) error {
+	err := clientset.CoreV1().Services(namespace).Delete(ctx, serviceName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service %s: %v", serviceName, err)
+	}
+	return nil
+}
+
+// validateVia checks how the SSH server of the pod is to be reached.
+func validateVia(opts MountOptions) error {
+	switch opts.Via {
+	case "", ViaPortForward, ViaService:
+	default:
+		return fmt.Errorf("invalid --via %s, must be %s or %s", opts.Via, ViaPortForward, ViaService)
+	}
+	switch opts.ServiceType {
+	case "", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
+		return fmt.Errorf("invalid service type %s, must be %s, %s or %s", opts.ServiceType, corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer)
+	}
+	if opts.ServiceType != "" && opts.Via != ViaService {
+		return errors.New("a service type can only be used with --via service")
+	}
+	return nil
+}
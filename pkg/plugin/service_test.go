@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// allocateServiceAddresses makes created Services get a cluster IP and node ports like a real API server.
+func allocateServiceAddresses(clientset *fake.Clientset) {
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		service := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		service.Spec.ClusterIP = "10.96.0.10"
+		if service.Spec.Type == corev1.ServiceTypeNodePort {
+			service.Spec.Ports[0].NodePort = 30022
+		}
+		return false, nil, nil
+	})
+}
+
+func newServiceTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "volume-exposer-abcde",
+			Namespace: "default",
+			UID:       "pod-uid",
+			Labels:    map[string]string{"app": "volume-exposer", "portNumber": "12345"},
+		},
+		Status: corev1.PodStatus{HostIP: "192.168.1.10"},
+	}
+}
+
+func TestBuildServiceSpec(t *testing.T) {
+	pod := newServiceTestPod()
+	service := buildServiceSpec(pod, DefaultSSHPort, corev1.ServiceTypeNodePort)
+
+	if service.Name != pod.Name || service.Namespace != pod.Namespace {
+		t.Errorf("Expected service %s/%s, got %s/%s", pod.Namespace, pod.Name, service.Namespace, service.Name)
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort {
+		t.Errorf("Expected type NodePort, got %s", service.Spec.Type)
+	}
+	if service.Spec.Selector["portNumber"] != "12345" || service.Spec.Selector["app"] != "volume-exposer" {
+		t.Errorf("Expected the service to select the pod by its labels, got %v", service.Spec.Selector)
+	}
+	if len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Port != int32(DefaultSSHPort) || service.Spec.Ports[0].TargetPort.IntValue() != DefaultSSHPort {
+		t.Errorf("Expected port %d, got %v", DefaultSSHPort, service.Spec.Ports)
+	}
+	if len(service.OwnerReferences) != 1 || service.OwnerReferences[0].Kind != "Pod" || service.OwnerReferences[0].UID != pod.UID {
+		t.Errorf("Expected the service to be owned by the pod, got %v", service.OwnerReferences)
+	}
+}
+
+func TestServiceAddress(t *testing.T) {
+	pod := newServiceTestPod()
+	tests := []struct {
+		name     string
+		service  corev1.Service
+		wantHost string
+		wantPort int
+		wantErr  bool
+	}{
+		{
+			name:     "ClusterIP",
+			service:  corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10"}},
+			wantHost: "10.96.0.10",
+			wantPort: DefaultSSHPort,
+		},
+		{
+			name:    "ClusterIP not allocated",
+			service: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}},
+			wantErr: true,
+		},
+		{
+			name: "NodePort",
+			service: corev1.Service{Spec: corev1.ServiceSpec{
+				Type:  corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{{Port: int32(DefaultSSHPort), NodePort: 30022}},
+			}},
+			wantHost: "192.168.1.10",
+			wantPort: 30022,
+		},
+		{
+			name: "LoadBalancer IP",
+			service: corev1.Service{
+				Spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.7"}}}},
+			},
+			wantHost: "203.0.113.7",
+			wantPort: DefaultSSHPort,
+		},
+		{
+			name: "LoadBalancer hostname",
+			service: corev1.Service{
+				Spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}}},
+			},
+			wantHost: "lb.example.com",
+			wantPort: DefaultSSHPort,
+		},
+		{
+			name:    "LoadBalancer pending",
+			service: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, err := serviceAddress(&tt.service, pod, DefaultSSHPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serviceAddress() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Errorf("serviceAddress() = %s:%d; want %s:%d", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestSetupService(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newServiceTestPod())
+	allocateServiceAddresses(clientset)
+
+	var host string
+	var port int
+	var err error
+	captureStdout(t, func() {
+		host, port, err = setupService(context.Background(), clientset, "default", "volume-exposer-abcde", DefaultSSHPort, MountOptions{ServiceType: corev1.ServiceTypeNodePort})
+	})
+	if err != nil {
+		t.Fatalf("setupService() returned an error: %v", err)
+	}
+	if host != "192.168.1.10" || port != 30022 {
+		t.Errorf("Expected the node port on the node of the pod, got %s:%d", host, port)
+	}
+	if _, err := clientset.CoreV1().Services("default").Get(context.Background(), "volume-exposer-abcde", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the service to be created: %v", err)
+	}
+}
+
+func TestDeleteService(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "volume-exposer-abcde", Namespace: "default"}})
+
+	if err := deleteService(context.Background(), clientset, "default", "volume-exposer-abcde"); err != nil {
+		t.Fatalf("deleteService() returned an error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("default").Get(context.Background(), "volume-exposer-abcde", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the service to be deleted, got %v", err)
+	}
+	if err := deleteService(context.Background(), clientset, "default", "volume-exposer-abcde"); err != nil {
+		t.Errorf("Expected deleting a missing service to succeed, got %v", err)
+	}
+}
+
+func TestValidateVia(t *testing.T) {
+	tests := []struct {
+		opts    MountOptions
+		wantErr bool
+	}{
+		{MountOptions{}, false},
+		{MountOptions{Via: ViaPortForward}, false},
+		{MountOptions{Via: ViaService, ServiceType: corev1.ServiceTypeNodePort}, false},
+		{MountOptions{Via: "ingress"}, true},
+		{MountOptions{Via: ViaService, ServiceType: corev1.ServiceTypeExternalName}, true},
+		{MountOptions{ServiceType: corev1.ServiceTypeNodePort}, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateVia(tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("validateVia(%+v) error = %v; wantErr %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMountViaService(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	allocateServiceAddresses(clientset)
+	r := &fakeRunner{}
+	useFakeRunner(t, r)
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@10.96.0.10:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{Via: ViaService})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	if len(r.started) != 0 {
+		t.Errorf("Expected no port-forward, got %v", r.started)
+	}
+	if len(r.run) != 1 {
+		t.Fatalf("Expected sshfs to be run, got %v", r.run)
+	}
+	args := strings.Join(r.run[0], " ")
+	if !strings.Contains(args, "ve@10.96.0.10:/volume") || !strings.HasSuffix(args, fmt.Sprintf("-p %d", DefaultSSHPort)) {
+		t.Errorf("Expected sshfs to connect to the cluster IP, got %s", args)
+	}
+
+	services, err := clientset.CoreV1().Services(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	if len(services.Items) != 1 {
+		t.Fatalf("Expected one service, got %d", len(services.Items))
+	}
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), services.Items[0].Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the service to be named after the pod: %v", err)
+	}
+	if pod.Annotations[ViaAnnotation] != ViaService {
+		t.Errorf("Expected the pod to be annotated for clean, got %v", pod.Annotations)
+	}
+}
+
+func TestMountViaServiceRejectsRWO(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	objects := append(newTestObjects(namespace, pvcName, corev1.ReadWriteOnce), newWorkloadPod(namespace, "workload", pvcName))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var err error
+	captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, "/mnt/data", MountOptions{Via: ViaService})
+	})
+	if err == nil || !strings.Contains(err.Error(), "--via service") || !strings.Contains(err.Error(), "workload") {
+		t.Fatalf("Expected --via service to be rejected for the mounted RWO volume, got: %v", err)
+	}
+	assertNoWrites(t, clientset)
+}
@@ -0,0 +1,420 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sshfsTimeout is how long SSHFS gets to mount the volume, and to exit after its mount
+// point was unmounted.
+const sshfsTimeout = 10 * time.Second
+
+// sshfsMountPollInterval is how often the mount table is checked while SSHFS mounts the volume.
+var sshfsMountPollInterval = 200 * time.Millisecond
+
+// MountSession is a mount started by MountAsync. The PVC stays mounted until
+// Unmount or Close is called, or SSHFS exits on its own.
+type MountSession struct {
+	Namespace       string
+	PVCName         string
+	LocalMountPoint string
+	// PodName is the pod created for the mount.
+	PodName string
+
+	clientset       kubernetes.Interface
+	originalPodName string
+	// ephemeralContainerName is the ephemeral container in originalPodName holding the tunnel.
+	ephemeralContainerName string
+	portForward            *exec.Cmd
+	sshfs                  *backgroundCommand
+	unmount                func() error
+	// unmountStale lazily unmounts the mount point once the mount dropped.
+	unmountStale func() error
+	// viaService is set if SSHFS reaches the pod through a Service named after it.
+	viaService bool
+	// localPort is the port SSHFS connects to, on host.
+	localPort int
+	host      string
+	// reconnect starts a new port-forward, unless the pod is reached through a Service, and
+	// a new SSHFS for the mount.
+	reconnect func() (*exec.Cmd, *backgroundCommand, error)
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// backgroundCommand is a started command that is waited for in the background.
+type backgroundCommand struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// startBackground starts the command and waits for it in a goroutine, calling cleanup once it exited.
+func startBackground(cmd *exec.Cmd, cleanup func()) (*backgroundCommand, error) {
+	if err := runner.Start(cmd); err != nil {
+		return nil, err
+	}
+
+	b := &backgroundCommand{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		b.err = runner.Wait(cmd)
+		if cleanup != nil {
+			cleanup()
+		}
+		close(b.done)
+	}()
+	return b, nil
+}
+
+// waitForMount waits until the background SSHFS mounted the volume. It fails once SSHFS exits
+// or sshfsTimeout passes without a FUSE mount showing up at the mount point.
+func waitForMount(sshfs *backgroundCommand, localMountPoint string) error {
+	timeout := time.After(sshfsTimeout)
+	for {
+		err := verifyMount(runtime.GOOS, localMountPoint)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-sshfs.done:
+			if sshfs.err != nil {
+				return fmt.Errorf("SSHFS exited before mounting %s: %v", localMountPoint, sshfs.err)
+			}
+			return fmt.Errorf("SSHFS exited before mounting %s", localMountPoint)
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for SSHFS: %v", err)
+		case <-time.After(sshfsMountPollInterval):
+		}
+	}
+}
+
+// stop kills the command and waits until it exited.
+func (b *backgroundCommand) stop() {
+	if b.cmd.Process != nil {
+		_ = b.cmd.Process.Kill()
+	}
+	<-b.done
+}
+
+// MountAsync mounts the PVC like Mount, but keeps SSHFS running in the background and returns
+// a session to unmount it and remove what was created for it once the volume is mounted.
+// Dry runs aren't supported.
+func MountAsync(ctx context.Context, namespace, pvcName, localMountPoint string, opts MountOptions) (*MountSession, error) {
+	if opts.DryRun {
+		return nil, errors.New("dry runs are not supported by MountAsync, use Mount instead")
+	}
+
+	clientset, err := prepareMount(localMountPoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return startMount(ctx, clientset, namespace, pvcName, localMountPoint, opts, startSSHFS)
+}
+
+func newMountSession(clientset kubernetes.Interface, namespace, pvcName, localMountPoint, podName, originalPodName string, portForward *exec.Cmd, sshfs *backgroundCommand) *MountSession {
+	return &MountSession{
+		Namespace:       namespace,
+		PVCName:         pvcName,
+		LocalMountPoint: localMountPoint,
+		PodName:         podName,
+		clientset:       clientset,
+		originalPodName: originalPodName,
+		portForward:     portForward,
+		sshfs:           sshfs,
+		unmount: func() error {
+			_, err := unmountLocal(localMountPoint, false)
+			return err
+		},
+		unmountStale: func() error {
+			_, err := unmountLocal(localMountPoint, true)
+			return err
+		},
+	}
+}
+
+// record remembers the mount locally, so it can be cleaned from another terminal.
+func (s *MountSession) record(localPort int) {
+	s.localPort = localPort
+	if s.portForward == nil || s.portForward.Process == nil {
+		return
+	}
+	record := mountRecord{
+		Namespace:      s.Namespace,
+		PVCName:        s.PVCName,
+		PodName:        s.PodName,
+		Backend:        "sshfs",
+		LocalPort:      localPort,
+		PortForwardPID: s.portForward.Process.Pid,
+		CreatedAt:      time.Now(),
+	}
+	if s.LocalMountPoint != "" {
+		record.LocalMountPoint = absMountPoint(s.LocalMountPoint)
+	}
+	recordMount(record)
+}
+
+// Info describes the mount like ListMounts does.
+func (s *MountSession) Info() MountInfo {
+	info := MountInfo{
+		Namespace:       s.Namespace,
+		PVCName:         s.PVCName,
+		PodName:         s.PodName,
+		OriginalPodName: s.originalPodName,
+		Backend:         "sshfs",
+		Via:             ViaPortForward,
+		LocalPort:       s.localPort,
+		LocalMountPoint: s.LocalMountPoint,
+		Phase:           corev1.PodRunning,
+	}
+	if s.viaService {
+		info.Via = ViaService
+	}
+	if info.LocalMountPoint != "" {
+		info.LocalMountPoint = absMountPoint(info.LocalMountPoint)
+	}
+	return info
+}
+
+// notMounted is the closed Done channel of sessions without SSHFS.
+var notMounted = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// Done returns a channel that is closed once SSHFS exited and the PVC is no longer mounted.
+// It's closed from the start if SSHFS was never started, e.g. with NoWait.
+func (s *MountSession) Done() <-chan struct{} {
+	if s.sshfs == nil {
+		return notMounted
+	}
+	return s.sshfs.done
+}
+
+// Err returns why SSHFS exited, nil while it's running or if it exited cleanly.
+func (s *MountSession) Err() error {
+	if s.sshfs == nil {
+		return nil
+	}
+	select {
+	case <-s.sshfs.done:
+		return s.sshfs.err
+	default:
+		return nil
+	}
+}
+
+// Unmount unmounts the local mount point and waits for SSHFS to exit. The pod and the
+// port-forward are left running, use Close to remove them as well.
+func (s *MountSession) Unmount() error {
+	if s.sshfs == nil {
+		// Nothing was mounted, e.g. for an sftp session
+		return nil
+	}
+
+	select {
+	case <-s.sshfs.done:
+		return nil
+	default:
+	}
+
+	if err := s.unmount(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.sshfs.done:
+	case <-time.After(sshfsTimeout):
+		fmt.Printf("Warning: SSHFS didn't exit after unmounting %s, killing it\n", s.LocalMountPoint)
+		if err := s.sshfs.cmd.Process.Kill(); err != nil {
+			return fmt.Errorf("failed to kill SSHFS: %v", err)
+		}
+		<-s.sshfs.done
+	}
+	return nil
+}
+
+// Close unmounts the PVC, stops the port-forward and deletes the pod created for the mount.
+// It is safe to call Close more than once.
+func (s *MountSession) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *MountSession) close() error {
+	var errs []error
+	if err := s.Unmount(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if s.portForward != nil && s.portForward.Process != nil {
+		if err := s.portForward.Process.Kill(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to kill port-forward process: %v", err))
+		} else {
+			// The port-forward was killed, so its exit status carries no information
+			_ = s.portForward.Wait()
+		}
+	}
+
+	// Use a fresh context, the one of the mount may have been canceled by now
+	ctx := context.Background()
+	if s.originalPodName != "" {
+		if err := killProcessInEphemeralContainer(ctx, s.clientset, s.Namespace, s.originalPodName, s.ephemeralContainerName); err != nil {
+			errs = append(errs, fmt.Errorf("failed to kill process in ephemeral container: %v", err))
+		}
+	}
+
+	if s.viaService {
+		if err := deleteService(ctx, s.clientset, s.Namespace, s.PodName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := deletePod(ctx, s.clientset, s.Namespace, s.PodName, 0); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete pod: %v", err))
+	} else {
+		fmt.Printf("Pod %s deleted successfully\n", s.PodName)
+		forgetMount(s.Namespace, s.PodName)
+	}
+
+	return errors.Join(errs...)
+}
+
+// watchInterval is how often MountWatch checks whether the mount is still up.
+var watchInterval = 10 * time.Second
+
+// MountWatch mounts the PVC like MountAsync and keeps it mounted until the context is canceled,
+// reconnecting the port-forward and SSHFS to the same pod whenever the mount drops. Once the
+// context is canceled, it removes everything created for the mount like MountSession.Close.
+func MountWatch(ctx context.Context, namespace, pvcName, localMountPoint string, opts MountOptions) error {
+	session, err := MountAsync(ctx, namespace, pvcName, localMountPoint, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Watching the mount of PVC %s, press ctrl-C to unmount it\n", pvcName)
+	err = session.watch(ctx, watchInterval, checkMountHealth)
+	return errors.Join(err, session.Close())
+}
+
+// checkMountHealth returns why the mount of the session is down, nil while it's up.
+var checkMountHealth = func(s *MountSession) error {
+	if s.sshfs == nil {
+		return errors.New("SSHFS isn't running")
+	}
+	select {
+	case <-s.sshfs.done:
+		return errors.New("SSHFS exited")
+	default:
+	}
+	if err := verifyMount(runtime.GOOS, s.LocalMountPoint); err != nil {
+		return err
+	}
+	// A dead port-forward doesn't take SSHFS down, it keeps trying to reconnect
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.host, strconv.Itoa(s.localPort)), sshfsTimeout)
+	if err != nil {
+		return fmt.Errorf("SSH server of pod %s can't be reached: %v", s.PodName, err)
+	}
+	return conn.Close()
+}
+
+// watch checks the mount every interval and reconnects it when check reports it's down. It
+// returns once the context is canceled, or with an error once the pod is gone, since there's
+// nothing left to reconnect to then.
+func (s *MountSession) watch(ctx context.Context, interval time.Duration, check func(*MountSession) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := check(s)
+		if err == nil {
+			continue
+		}
+		fmt.Printf("Mount of PVC %s dropped: %v\n", s.PVCName, err)
+
+		if _, err := s.clientset.CoreV1().Pods(s.Namespace).Get(ctx, s.PodName, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+			return fmt.Errorf("pod %s is gone, can't reconnect PVC %s", s.PodName, s.PVCName)
+		}
+		if err := s.remount(); err != nil {
+			fmt.Printf("Warning: failed to reconnect PVC %s, retrying in %v: %v\n", s.PVCName, interval, err)
+			continue
+		}
+		fmt.Printf("Reconnected PVC %s to %s\n", s.PVCName, s.LocalMountPoint)
+	}
+}
+
+// remount replaces the port-forward and SSHFS of the session with new ones to the same pod.
+func (s *MountSession) remount() error {
+	if s.reconnect == nil {
+		return errors.New("the mount can't be reconnected")
+	}
+
+	// A dropped mount usually leaves a stale mount point behind
+	mounted, err := isMounted(runtime.GOOS, s.LocalMountPoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := s.unmountStale(); err != nil {
+			return err
+		}
+	}
+	if s.sshfs != nil {
+		s.sshfs.stop()
+	}
+	if s.portForward != nil && s.portForward.Process != nil {
+		_ = s.portForward.Process.Kill()
+		_ = s.portForward.Wait()
+	}
+
+	portForward, sshfs, err := s.reconnect()
+	if err != nil {
+		return err
+	}
+	s.portForward, s.sshfs = portForward, sshfs
+	s.record(s.localPort)
+	return nil
+}
+
+// reconnector returns how a dropped mount of the pod is reconnected, see MountSession.reconnect.
+func reconnector(namespace, podName, pvcName, localMountPoint, privateKey string, port, remotePort int, opts MountOptions) func() (*exec.Cmd, *backgroundCommand, error) {
+	return func() (*exec.Cmd, *backgroundCommand, error) {
+		var portForward *exec.Cmd
+		if opts.Via != ViaService {
+			var err error
+			portForward, err = setupPortForwarding(namespace, podName, port, remotePort, opts.Address)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		sshfs, err := startSSHFS(port, localMountPoint, pvcName, privateKey, opts)
+		if err != nil {
+			if portForward != nil && portForward.Process != nil {
+				_ = portForward.Process.Kill()
+				_ = portForward.Wait()
+			}
+			return nil, nil, err
+		}
+		return portForward, sshfs, nil
+	}
+}
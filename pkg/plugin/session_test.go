@@ -0,0 +1,326 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestSession returns a session whose SSHFS and port-forward are stubbed by sleep
+// commands. Unmounting the session stops the stubbed SSHFS like a real unmount would.
+func newTestSession(t *testing.T, clientset *fake.Clientset, unmounts *int) *MountSession {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	sshfs, err := startBackground(exec.Command("sleep", "60"), nil)
+	if err != nil {
+		t.Fatalf("Failed to start the SSHFS stub: %v", err)
+	}
+	portForward := exec.Command("sleep", "60")
+	if err := portForward.Start(); err != nil {
+		t.Fatalf("Failed to start the port-forward stub: %v", err)
+	}
+
+	session := newMountSession(clientset, "default", "test-pvc", "/mnt/data", "volume-exposer-abcde", "", portForward, sshfs)
+	session.unmount = func() error {
+		*unmounts++
+		return sshfs.cmd.Process.Kill()
+	}
+	t.Cleanup(func() {
+		_ = sshfs.cmd.Process.Kill()
+		_ = portForward.Process.Kill()
+	})
+	return session
+}
+
+func TestMountSessionClose(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newExposerPod("default", "volume-exposer-abcde", "test-pvc", "/mnt/data"))
+	var unmounts int
+	session := newTestSession(t, clientset, &unmounts)
+
+	select {
+	case <-session.Done():
+		t.Fatal("Session should be running before it's closed")
+	default:
+	}
+
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() returned an unexpected error: %v", err)
+	}
+
+	select {
+	case <-session.Done():
+	default:
+		t.Error("Session should be done after it's closed")
+	}
+	if session.portForward.ProcessState == nil {
+		t.Error("Port-forward should have been stopped")
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), "volume-exposer-abcde", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected pod to be deleted, got %v", err)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Errorf("Second Close() returned an unexpected error: %v", err)
+	}
+	if unmounts != 1 {
+		t.Errorf("Expected the mount point to be unmounted once, got %d", unmounts)
+	}
+}
+
+func TestMountSessionCloseViaService(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newExposerPod("default", "volume-exposer-abcde", "test-pvc", "/mnt/data"),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "volume-exposer-abcde", Namespace: "default"}},
+	)
+	var unmounts int
+	session := newTestSession(t, clientset, &unmounts)
+	session.viaService = true
+
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() returned an unexpected error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("default").Get(context.Background(), "volume-exposer-abcde", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected service to be deleted, got %v", err)
+	}
+}
+
+func TestMountSessionUnmountAfterExit(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var unmounts int
+	session := newTestSession(t, clientset, &unmounts)
+
+	// SSHFS exiting on its own means nothing is mounted anymore
+	_ = session.sshfs.cmd.Process.Kill()
+	<-session.Done()
+
+	if err := session.Unmount(); err != nil {
+		t.Fatalf("Unmount() returned an unexpected error: %v", err)
+	}
+	if unmounts != 0 {
+		t.Errorf("Expected no unmount after SSHFS exited, got %d", unmounts)
+	}
+	if session.Err() == nil {
+		t.Error("Expected Err() to report why SSHFS exited")
+	}
+}
+
+func TestMountSessionWithoutSSHFS(t *testing.T) {
+	// Sessions of mounts made with NoWait never start SSHFS
+	session := newMountSession(fake.NewSimpleClientset(), "default", "test-pvc", "/mnt/data", "volume-exposer-abcde", "", nil, nil)
+
+	t.Run("Done", func(t *testing.T) {
+		select {
+		case <-session.Done():
+		default:
+			t.Error("Expected Done() to be closed without SSHFS")
+		}
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		if err := session.Err(); err != nil {
+			t.Errorf("Expected no error without SSHFS, got %v", err)
+		}
+	})
+
+	t.Run("Health check", func(t *testing.T) {
+		if err := checkMountHealth(session); err == nil || !strings.Contains(err.Error(), "SSHFS isn't running") {
+			t.Errorf("Expected the mount to be reported down without SSHFS, got %v", err)
+		}
+	})
+}
+
+func TestStartSSHFS(t *testing.T) {
+	t.Run("Returns once mounted", func(t *testing.T) {
+		r := &fakeRunner{exited: make(chan struct{})}
+		useFakeRunner(t, r)
+		mountPoint := t.TempDir()
+		useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+
+		var sshfs *backgroundCommand
+		var err error
+		captureStdout(t, func() {
+			sshfs, err = startSSHFS(12345, mountPoint, "test-pvc", "privateKey", MountOptions{})
+		})
+		if err != nil {
+			t.Fatalf("startSSHFS() returned an error: %v", err)
+		}
+		if len(r.started) != 1 || !strings.Contains(strings.Join(r.started[0], " "), "sshfs") {
+			t.Errorf("Expected SSHFS to be started through the runner, got %v", r.started)
+		}
+		select {
+		case <-sshfs.done:
+			t.Error("SSHFS should still be running")
+		default:
+		}
+		close(r.exited)
+		<-sshfs.done
+	})
+
+	t.Run("Fails if SSHFS exits before mounting", func(t *testing.T) {
+		useFakeRunner(t, &fakeRunner{waitErr: errors.New("exit status 1")})
+		useMountTable(t, "")
+		oldInterval := sshfsMountPollInterval
+		sshfsMountPollInterval = time.Millisecond
+		t.Cleanup(func() { sshfsMountPollInterval = oldInterval })
+
+		var err error
+		captureStdout(t, func() {
+			_, err = startSSHFS(12345, t.TempDir(), "test-pvc", "privateKey", MountOptions{})
+		})
+		if err == nil || !strings.Contains(err.Error(), "exited before mounting") {
+			t.Errorf("Expected an error about SSHFS exiting, got %v", err)
+		}
+	})
+}
+
+func TestMountAsyncDryRun(t *testing.T) {
+	if _, err := MountAsync(context.Background(), "default", "test-pvc", "/mnt/data", MountOptions{DryRun: true}); err == nil {
+		t.Error("MountAsync() should have returned an error for a dry run")
+	}
+}
+
+func TestMountSessionWatch(t *testing.T) {
+	// newReconnector stubs reconnecting by starting another SSHFS stub, counting the reconnects.
+	newReconnector := func(t *testing.T, reconnects *int, err error) func() (*exec.Cmd, *backgroundCommand, error) {
+		return func() (*exec.Cmd, *backgroundCommand, error) {
+			*reconnects++
+			if err != nil {
+				return nil, nil, err
+			}
+			sshfs, err := startBackground(exec.Command("sleep", "60"), nil)
+			if err != nil {
+				t.Fatalf("Failed to start the SSHFS stub: %v", err)
+			}
+			t.Cleanup(func() { _ = sshfs.cmd.Process.Kill() })
+			return nil, sshfs, nil
+		}
+	}
+
+	// checkSequence reports the mount down for the checks whose results are errors, and cancels
+	// the watch once all results were used.
+	checkSequence := func(cancel context.CancelFunc, results ...error) func(*MountSession) error {
+		checks := 0
+		return func(*MountSession) error {
+			if checks == len(results) {
+				cancel()
+				return nil
+			}
+			checks++
+			return results[checks-1]
+		}
+	}
+
+	t.Run("Reconnects dropped mounts", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newExposerPod("default", "volume-exposer-abcde", "test-pvc", "/mnt/data"))
+		var unmounts, reconnects int
+		session := newTestSession(t, clientset, &unmounts)
+		session.reconnect = newReconnector(t, &reconnects, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		dropped := errors.New("SSHFS exited")
+		var err error
+		out := captureStdout(t, func() {
+			err = session.watch(ctx, time.Millisecond, checkSequence(cancel, dropped, nil, dropped, nil))
+		})
+		if err != nil {
+			t.Fatalf("watch() returned an error: %v", err)
+		}
+		if reconnects != 2 {
+			t.Errorf("Expected a reconnect for each drop, got %d", reconnects)
+		}
+		if strings.Count(out, "Reconnected PVC test-pvc") != 2 {
+			t.Errorf("Expected the reconnects to be logged, got %q", out)
+		}
+	})
+
+	t.Run("Retries failed reconnects", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newExposerPod("default", "volume-exposer-abcde", "test-pvc", "/mnt/data"))
+		var unmounts, reconnects int
+		session := newTestSession(t, clientset, &unmounts)
+		session.reconnect = newReconnector(t, &reconnects, errors.New("port-forward failed"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		dropped := errors.New("SSHFS exited")
+		var err error
+		out := captureStdout(t, func() {
+			err = session.watch(ctx, time.Millisecond, checkSequence(cancel, dropped, dropped))
+		})
+		if err != nil {
+			t.Fatalf("watch() returned an error: %v", err)
+		}
+		if reconnects != 2 {
+			t.Errorf("Expected the reconnect to be retried, got %d", reconnects)
+		}
+		if !strings.Contains(out, "failed to reconnect PVC test-pvc") {
+			t.Errorf("Expected the failed reconnect to be logged, got %q", out)
+		}
+	})
+
+	t.Run("Pod gone", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		var unmounts, reconnects int
+		session := newTestSession(t, clientset, &unmounts)
+		session.reconnect = newReconnector(t, &reconnects, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var err error
+		captureStdout(t, func() {
+			err = session.watch(ctx, time.Millisecond, checkSequence(cancel, errors.New("SSHFS exited")))
+		})
+		if err == nil || !strings.Contains(err.Error(), "pod volume-exposer-abcde is gone") {
+			t.Errorf("Expected the watch to give up without the pod, got %v", err)
+		}
+		if reconnects != 0 {
+			t.Errorf("Expected no reconnect without the pod, got %d", reconnects)
+		}
+	})
+}
+
+func TestMountSessionRemountStale(t *testing.T) {
+	// The mount point of a dropped mount can't be stat'd, but the mount table still lists it
+	mountPoint := filepath.Join(t.TempDir(), "data")
+
+	for _, tt := range []struct {
+		name    string
+		table   string
+		unmount bool
+	}{
+		{"Stale mount", fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint), true},
+		{"Not mounted", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			useMountTable(t, tt.table)
+			var unmounts, staleUnmounts int
+			session := newTestSession(t, fake.NewSimpleClientset(), &unmounts)
+			session.LocalMountPoint = mountPoint
+			session.unmountStale = func() error {
+				staleUnmounts++
+				return nil
+			}
+			session.reconnect = func() (*exec.Cmd, *backgroundCommand, error) {
+				return nil, session.sshfs, nil
+			}
+
+			if err := session.remount(); err != nil {
+				t.Fatalf("remount() returned an error: %v", err)
+			}
+			if unmounted := staleUnmounts == 1; unmounted != tt.unmount {
+				t.Errorf("Expected the stale mount point to be unmounted: %v, got %d unmounts", tt.unmount, staleUnmounts)
+			}
+		})
+	}
+}
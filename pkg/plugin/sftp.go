@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// SFTP opens an sftp session to the PVC instead of mounting it, for where FUSE and so SSHFS
+// aren't available. With a batch file, sftp runs its commands and exits instead of being
+// interactive. The pod and port-forward are removed once sftp exits.
+func SFTP(ctx context.Context, namespace, pvcName, batchFile string, opts MountOptions) error {
+	clientset, err := prepareOneShot("sftp", ErrSFTPNotFound, opts)
+	if err != nil {
+		return err
+	}
+
+	return sftp(ctx, clientset, namespace, pvcName, batchFile, opts)
+}
+
+// prepareOneShot checks the environment and options of a command run against a PVC instead
+// of mounting it, like prepareMount does for mounts, and builds the Kubernetes client.
+func prepareOneShot(command string, errNotFound error, opts MountOptions) (kubernetes.Interface, error) {
+	if opts.DryRun {
+		return nil, fmt.Errorf("dry runs are not supported with %s, use mount --dry-run instead", command)
+	}
+
+	if err := checkSupportedOS(runtime.GOOS); err != nil {
+		return nil, err
+	}
+
+	if err := validateMountOptions(opts); err != nil {
+		return nil, err
+	}
+
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("%w, please install it and try again", errNotFound)
+	}
+
+	return BuildKubeClient()
+}
+
+// runOneShot sets up the pod and port-forward of the PVC like a mount, but runs the command
+// instead of SSHFS and removes everything again once it's done. There is no local mount
+// point, so the pod isn't labeled with one and clean can't mistake it for a mount.
+func runOneShot(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string, opts MountOptions, run func(port int, keyFile string, opts MountOptions) error) error {
+	session, err := startMount(ctx, clientset, namespace, pvcName, "", opts, func(port int, _, _, privateKey string, opts MountOptions) (*backgroundCommand, error) {
+		keyFile, err := writePrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyFile)
+
+		return nil, run(port, keyFile, opts)
+	})
+	if err != nil {
+		return err
+	}
+	return session.Close()
+}
+
+func sftp(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName, batchFile string, opts MountOptions) error {
+	return runOneShot(ctx, clientset, namespace, pvcName, opts, func(port int, keyFile string, opts MountOptions) error {
+		sftpCmd := buildSFTPCommand(keyFile, batchFile, port, opts)
+		sftpCmd.Stdin = os.Stdin
+		sftpCmd.Stdout = os.Stdout
+		sftpCmd.Stderr = os.Stderr
+
+		fmt.Printf("Opening sftp session to PVC %s\n", pvcName)
+		if err := runner.Run(sftpCmd); err != nil {
+			return fmt.Errorf("failed to run sftp: %v", err)
+		}
+		return nil
+	})
+}
+
+func buildSFTPCommand(keyFile, batchFile string, port int, opts MountOptions) *exec.Cmd {
+	args := []string{
+		"-i", keyFile,
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-P", fmt.Sprintf("%d", port),
+	}
+	if interval := opts.keepAliveInterval(); interval > 0 {
+		args = append(args,
+			"-o", fmt.Sprintf("ServerAliveInterval=%d", interval),
+			"-o", fmt.Sprintf("ServerAliveCountMax=%d", KeepAliveCountMax),
+		)
+	}
+	if opts.Compression {
+		args = append(args, "-C")
+	}
+	if batchFile != "" {
+		args = append(args, "-b", batchFile)
+	}
+	// Starts the session in the volume instead of the home directory of the user
+	args = append(args, opts.remoteTarget(opts.remoteMountPath()))
+	return exec.Command("sftp", args...)
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestBuildSFTPCommand(t *testing.T) {
+	cmd := buildSFTPCommand("/tmp/key.pem", "", 12345, MountOptions{})
+	expected := []string{
+		"sftp",
+		"-i", "/tmp/key.pem",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-P", "12345",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"ve@localhost:/volume",
+	}
+	if !slices.Equal(cmd.Args, expected) {
+		t.Errorf("buildSFTPCommand() = %v; want %v", cmd.Args, expected)
+	}
+
+	cmd = buildSFTPCommand("/tmp/key.pem", "commands.txt", 12345, MountOptions{NeedsRoot: true, Compression: true, KeepAliveInterval: -1, RemoteMountPath: "/data"})
+	args := strings.Join(cmd.Args, " ")
+	for _, arg := range []string{"-C", "-b commands.txt", "root@localhost:/data"} {
+		if !strings.Contains(args, arg) {
+			t.Errorf("Expected %q in sftp command %s", arg, args)
+		}
+	}
+	if strings.Contains(args, "ServerAliveInterval") {
+		t.Errorf("Expected no keep-alives when disabled, got %s", args)
+	}
+	if !strings.HasSuffix(args, "root@localhost:/data") {
+		t.Errorf("Expected the destination last, got %s", args)
+	}
+}
+
+func TestSFTPRemovesPod(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		runErr error
+	}{
+		{"session ended", nil},
+		{"session failed", errors.New("exit status 1")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := "default"
+			pvcName := "test-pvc"
+			clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+			markPodsReady(clientset)
+			r := &fakeRunner{runErr: tt.runErr}
+			useFakeRunner(t, r)
+
+			var err error
+			captureStdout(t, func() {
+				err = sftp(context.Background(), clientset, namespace, pvcName, "commands.txt", MountOptions{})
+			})
+			if (err != nil) != (tt.runErr != nil) {
+				t.Fatalf("sftp() returned %v, expected error %v", err, tt.runErr)
+			}
+
+			if len(r.run) != 1 || r.run[0][0] != "sftp" || !slices.Contains(r.run[0], "commands.txt") {
+				t.Errorf("Expected sftp to be run with the batch file, got %v", r.run)
+			}
+			pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Failed to list pods: %v", err)
+			}
+			if len(pods.Items) != 0 {
+				t.Errorf("Expected the exposer pod to be deleted once sftp exited, got %d pods", len(pods.Items))
+			}
+		})
+	}
+}
+
+func TestSFTPPodHasNoMountPoint(t *testing.T) {
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	var created *corev1.Pod
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).DeepCopy()
+		return false, nil, nil
+	})
+	useFakeRunner(t, &fakeRunner{})
+
+	var err error
+	captureStdout(t, func() {
+		err = sftp(context.Background(), clientset, namespace, pvcName, "", MountOptions{})
+	})
+	if err != nil {
+		t.Fatalf("sftp() returned an error: %v", err)
+	}
+	if created == nil {
+		t.Fatal("Expected a pod to be created")
+	}
+	// The working directory must not look like the mount point of the pod
+	if _, ok := created.Labels["mountPointHash"]; ok {
+		t.Errorf("Expected no mount point label on the one-shot pod, got %v", created.Labels)
+	}
+	if _, ok := created.Annotations[MountPointAnnotation]; ok {
+		t.Errorf("Expected no mount point annotation on the one-shot pod, got %v", created.Annotations)
+	}
+}
+
+func TestSFTPDryRun(t *testing.T) {
+	if err := SFTP(context.Background(), "default", "test-pvc", "", MountOptions{DryRun: true}); err == nil {
+		t.Error("SFTP() should have returned an error for a dry run")
+	}
+}
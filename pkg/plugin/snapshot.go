@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// volumeSnapshotResource is read with the dynamic client, so pv-mounter doesn't depend on the
+// client of the external snapshotter.
+var volumeSnapshotResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+
+// snapshotSource is what restoring a VolumeSnapshot into a PVC needs to know about it.
+type snapshotSource struct {
+	// RestoreSize is the minimum size of a PVC restored from the snapshot, unset if the
+	// snapshot doesn't report it.
+	RestoreSize *resource.Quantity
+	// PVCName is the PVC the snapshot was taken of, if it was taken of one.
+	PVCName string
+}
+
+// MountSnapshot mounts a point-in-time copy of a PVC. It restores the VolumeSnapshot into a
+// temporary PVC, with the storage class and access modes of the PVC the snapshot was taken of,
+// and mounts that like Mount. The live PVC isn't touched, and clean deletes the temporary PVC
+// together with the pod.
+func MountSnapshot(ctx context.Context, namespace, snapshotName, localMountPoint string, opts MountOptions) error {
+	clientset, err := prepareMount(localMountPoint, opts)
+	if err != nil {
+		return err
+	}
+	config, err := buildKubeConfig()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	return mountSnapshot(ctx, clientset, dynamicClient, namespace, snapshotName, localMountPoint, opts)
+}
+
+func mountSnapshot(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, namespace, snapshotName, localMountPoint string, opts MountOptions) (err error) {
+	source, err := getSnapshotSource(ctx, dynamicClient, namespace, snapshotName)
+	if err != nil {
+		return err
+	}
+
+	var sourcePVC *corev1.PersistentVolumeClaim
+	if source.PVCName != "" {
+		sourcePVC, err = clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, source.PVCName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// The snapshot outlives its PVC, the defaults of the namespace have to do then
+			sourcePVC, err = nil, nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get PVC %s of snapshot %s: %v", source.PVCName, snapshotName, err)
+		}
+	}
+
+	pvc, err := buildSnapshotPVC(fmt.Sprintf("pv-mounter-snapshot-%s", randSeq(5)), snapshotName, source, sourcePVC)
+	if err != nil {
+		return err
+	}
+	opts.snapshot = snapshotName
+
+	if opts.DryRun {
+		pvc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"}
+		pvc.Namespace = namespace
+		fmt.Printf("# PVC that would be restored from snapshot %s in namespace %s\n", snapshotName, namespace)
+		if err := printYAML(pvc); err != nil {
+			return err
+		}
+		_, err := startMount(ctx, clientset, namespace, pvc.Name, localMountPoint, opts, mountInForeground)
+		return err
+	}
+
+	if _, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PVC from snapshot %s: %v", snapshotName, err)
+	}
+	fmt.Printf("Restoring snapshot %s into PVC %s\n", snapshotName, pvc.Name)
+	defer func() {
+		if err != nil {
+			deleteSnapshotPVC(context.Background(), clientset, namespace, pvc.Name)
+		}
+	}()
+
+	if err := waitForSnapshotPVC(ctx, clientset, namespace, pvc, opts.waitReadyTimeout()); err != nil {
+		return err
+	}
+	_, err = startMount(ctx, clientset, namespace, pvc.Name, localMountPoint, opts, mountInForeground)
+	return err
+}
+
+// getSnapshotSource reads the snapshot, which has to be ready to restore from.
+func getSnapshotSource(ctx context.Context, dynamicClient dynamic.Interface, namespace, snapshotName string) (snapshotSource, error) {
+	snapshot, err := dynamicClient.Resource(volumeSnapshotResource).Namespace(namespace).Get(ctx, snapshotName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return snapshotSource{}, fmt.Errorf("VolumeSnapshot %s not found in namespace %s", snapshotName, namespace)
+	}
+	if err != nil {
+		return snapshotSource{}, fmt.Errorf("failed to get VolumeSnapshot %s: %v", snapshotName, err)
+	}
+
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); !ready {
+		return snapshotSource{}, fmt.Errorf("VolumeSnapshot %s is not ready to use yet", snapshotName)
+	}
+
+	var source snapshotSource
+	source.PVCName, _, _ = unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+	if value, found, _ := unstructured.NestedString(snapshot.Object, "status", "restoreSize"); found {
+		size, err := resource.ParseQuantity(value)
+		if err != nil {
+			return snapshotSource{}, fmt.Errorf("invalid restore size %q of VolumeSnapshot %s: %v", value, snapshotName, err)
+		}
+		source.RestoreSize = &size
+	}
+	return source, nil
+}
+
+// buildSnapshotPVC builds the temporary PVC restoring the snapshot. It's as large as the
+// snapshot, or as the PVC it was taken of if the snapshot doesn't tell, and uses the storage
+// class and access modes of that PVC, which the CSI driver of the snapshot supports.
+func buildSnapshotPVC(name, snapshotName string, source snapshotSource, sourcePVC *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"app": "volume-exposer"},
+			Annotations: map[string]string{SnapshotAnnotation: snapshotName},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &volumeSnapshotResource.Group,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshotName,
+			},
+		},
+	}
+
+	size := source.RestoreSize
+	if sourcePVC != nil {
+		pvc.Spec.StorageClassName = sourcePVC.Spec.StorageClassName
+		pvc.Spec.VolumeMode = sourcePVC.Spec.VolumeMode
+		if len(sourcePVC.Spec.AccessModes) > 0 {
+			pvc.Spec.AccessModes = sourcePVC.Spec.AccessModes
+		}
+		if request, ok := sourcePVC.Spec.Resources.Requests[corev1.ResourceStorage]; ok && (size == nil || request.Cmp(*size) > 0) {
+			size = &request
+		}
+	}
+	if size == nil {
+		return nil, fmt.Errorf("VolumeSnapshot %s reports no restore size and the PVC it was taken of is gone, the size of the PVC to restore it into is unknown", snapshotName)
+	}
+	pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: *size}
+	return pvc, nil
+}
+
+// waitForSnapshotPVC waits for the PVC restored from a snapshot to bind. PVCs of storage
+// classes binding on the first consumer only bind once the pod mounting them is scheduled,
+// waiting for the pod covers those.
+func waitForSnapshotPVC(ctx context.Context, clientset kubernetes.Interface, namespace string, pvc *corev1.PersistentVolumeClaim, timeout time.Duration) error {
+	if bindsOnFirstConsumer(ctx, clientset, pvc.Spec.StorageClassName) {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := podReadyBackoff.DelayFunc().Until(waitCtx, true, false, func(ctx context.Context) (bool, error) {
+		current, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return current.Status.Phase == corev1.ClaimBound, nil
+	})
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted while waiting for PVC %s to bind: %w", pvc.Name, ctx.Err())
+	}
+	if wait.Interrupted(err) {
+		return fmt.Errorf("PVC %s restored from snapshot %s not bound in time (%s)", pvc.Name, pvc.Annotations[SnapshotAnnotation], timeout)
+	}
+	return err
+}
+
+// bindsOnFirstConsumer reports whether PVCs of the storage class, the default one if unset,
+// bind only once a pod using them is scheduled. Unknown classes are assumed to bind right away.
+func bindsOnFirstConsumer(ctx context.Context, clientset kubernetes.Interface, storageClassName *string) bool {
+	var class *storagev1.StorageClass
+	if storageClassName != nil {
+		if *storageClassName == "" {
+			// Explicitly no class, only binds to existing PVs
+			return false
+		}
+		var err error
+		if class, err = clientset.StorageV1().StorageClasses().Get(ctx, *storageClassName, metav1.GetOptions{}); err != nil {
+			return false
+		}
+	} else {
+		classes, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false
+		}
+		for i := range classes.Items {
+			if classes.Items[i].Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+				class = &classes.Items[i]
+				break
+			}
+		}
+	}
+	return class != nil && class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
+}
+
+// deleteSnapshotPVC deletes the PVC restored for a mount. A mount that failed can't do much
+// about a failure to delete it, so it's only reported.
+func deleteSnapshotPVC(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string) {
+	if err := deletePVC(ctx, clientset, namespace, pvcName); err != nil {
+		fmt.Printf("Warning: %v, delete it yourself\n", err)
+	}
+}
+
+// deletePVC deletes a PVC restored from a snapshot. PVCs that are already gone are fine.
+func deletePVC(ctx context.Context, clientset kubernetes.Interface, namespace, pvcName string) error {
+	err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvcName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete PVC %s: %v", pvcName, err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newVolumeSnapshot(namespace, name, pvcName, restoreSize string, ready bool) *unstructured.Unstructured {
+	status := map[string]interface{}{"readyToUse": ready}
+	if restoreSize != "" {
+		status["restoreSize"] = restoreSize
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{"persistentVolumeClaimName": pvcName},
+		},
+		"status": status,
+	}}
+}
+
+func newSnapshotSourcePVC(namespace, name, storageClass, size string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+func TestGetSnapshotSource(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newVolumeSnapshot("default", "ready", "data", "5Gi", true),
+		newVolumeSnapshot("default", "pending", "data", "", false),
+	)
+
+	source, err := getSnapshotSource(context.Background(), dynamicClient, "default", "ready")
+	if err != nil {
+		t.Fatalf("getSnapshotSource() returned an error: %v", err)
+	}
+	if source.PVCName != "data" || source.RestoreSize == nil || source.RestoreSize.String() != "5Gi" {
+		t.Errorf("Unexpected source %+v", source)
+	}
+
+	for _, name := range []string{"pending", "missing"} {
+		if _, err := getSnapshotSource(context.Background(), dynamicClient, "default", name); err == nil {
+			t.Errorf("Expected snapshot %s to be rejected", name)
+		}
+	}
+}
+
+func TestBuildSnapshotPVC(t *testing.T) {
+	restoreSize := resource.MustParse("5Gi")
+
+	t.Run("With the source PVC", func(t *testing.T) {
+		sourcePVC := newSnapshotSourcePVC("default", "data", "fast", "10Gi")
+		pvc, err := buildSnapshotPVC("pv-mounter-snapshot-abcde", "nightly", snapshotSource{RestoreSize: &restoreSize, PVCName: "data"}, sourcePVC)
+		if err != nil {
+			t.Fatalf("buildSnapshotPVC() returned an error: %v", err)
+		}
+
+		source := pvc.Spec.DataSource
+		if source == nil || source.Kind != "VolumeSnapshot" || source.Name != "nightly" || source.APIGroup == nil || *source.APIGroup != "snapshot.storage.k8s.io" {
+			t.Errorf("Expected the snapshot as data source, got %+v", source)
+		}
+		if class := pvc.Spec.StorageClassName; class == nil || *class != "fast" {
+			t.Errorf("Expected the storage class of the source PVC, got %v", class)
+		}
+		if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteOncePod {
+			t.Errorf("Expected the access modes of the source PVC, got %v", pvc.Spec.AccessModes)
+		}
+		// The source PVC may have been resized since the snapshot was taken
+		if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "10Gi" {
+			t.Errorf("Expected the larger size of the source PVC, got %s", size.String())
+		}
+		if pvc.Annotations[SnapshotAnnotation] != "nightly" {
+			t.Errorf("Expected the snapshot to be recorded, got %v", pvc.Annotations)
+		}
+	})
+
+	t.Run("Without the source PVC", func(t *testing.T) {
+		pvc, err := buildSnapshotPVC("pv-mounter-snapshot-abcde", "nightly", snapshotSource{RestoreSize: &restoreSize}, nil)
+		if err != nil {
+			t.Fatalf("buildSnapshotPVC() returned an error: %v", err)
+		}
+		if pvc.Spec.StorageClassName != nil {
+			t.Errorf("Expected the default storage class, got %v", *pvc.Spec.StorageClassName)
+		}
+		if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "5Gi" {
+			t.Errorf("Expected the restore size, got %s", size.String())
+		}
+		if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteOnce {
+			t.Errorf("Expected ReadWriteOnce, got %v", pvc.Spec.AccessModes)
+		}
+	})
+
+	t.Run("Unknown size", func(t *testing.T) {
+		if _, err := buildSnapshotPVC("pv-mounter-snapshot-abcde", "nightly", snapshotSource{}, nil); err == nil {
+			t.Error("Expected an error without any size")
+		}
+	})
+}
+
+func TestMountSnapshotDryRun(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newSnapshotSourcePVC("default", "data", "fast", "10Gi"))
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newVolumeSnapshot("default", "nightly", "data", "5Gi", true))
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mountSnapshot(context.Background(), clientset, dynamicClient, "default", "nightly", "/mnt/data", MountOptions{DryRun: true})
+	})
+	if err != nil {
+		t.Fatalf("mountSnapshot() returned an error: %v", err)
+	}
+
+	for _, expected := range []string{"kind: PersistentVolumeClaim", "kind: VolumeSnapshot", "claimName: pv-mounter-snapshot-", SnapshotAnnotation + ": nightly", "value: standalone"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in the dry run, got:\n%s", expected, out)
+		}
+	}
+	assertNoWrites(t, clientset)
+}
+
+func TestMountSnapshotDeletesPVCOnFailure(t *testing.T) {
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	clientset := fake.NewSimpleClientset(
+		newSnapshotSourcePVC("default", "data", "fast", "10Gi"),
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}, VolumeBindingMode: &waitForFirstConsumer},
+	)
+	clientset.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "", errors.New("quota exceeded"))
+	})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newVolumeSnapshot("default", "nightly", "data", "5Gi", true))
+
+	var err error
+	captureStdout(t, func() {
+		err = mountSnapshot(context.Background(), clientset, dynamicClient, "default", "nightly", "/mnt/data", MountOptions{})
+	})
+	if err == nil {
+		t.Fatal("Expected the failed pod creation to fail the mount")
+	}
+
+	var created, deleted string
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource != "persistentvolumeclaims" {
+			continue
+		}
+		switch action := action.(type) {
+		case k8stesting.CreateAction:
+			created = action.GetObject().(*corev1.PersistentVolumeClaim).Name
+		case k8stesting.DeleteAction:
+			deleted = action.GetName()
+		}
+	}
+	if !strings.HasPrefix(created, "pv-mounter-snapshot-") || deleted != created {
+		t.Errorf("Expected the restored PVC %q to be deleted again, got %q", created, deleted)
+	}
+}
+
+func TestCleanPodDeletesSnapshotPVC(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	useFakeRunner(t, &fakeRunner{})
+
+	pod := newExposerPod("default", "volume-exposer-abcde", "pv-mounter-snapshot-fghij", "/mnt/data")
+	pod.Annotations = map[string]string{SnapshotAnnotation: "nightly"}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pv-mounter-snapshot-fghij", Namespace: "default"}}
+	clientset := fake.NewSimpleClientset(pod, pvc)
+
+	result := &CleanResult{}
+	var err error
+	captureStdout(t, func() {
+		err = cleanPod(context.Background(), clientset, pod, CleanOptions{}, result)
+	})
+	if err != nil {
+		t.Fatalf("cleanPod() returned an error: %v", err)
+	}
+	if _, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), pvc.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the restored PVC to be deleted, got %v", err)
+	}
+	if len(result.PVCsDeleted) != 1 || result.PVCsDeleted[0] != "default/pv-mounter-snapshot-fghij" {
+		t.Errorf("Expected the PVC in the result, got %v", result.PVCsDeleted)
+	}
+}
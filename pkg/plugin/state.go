@@ -0,0 +1,264 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	stateFileName = "mounts.json"
+	stateLockName = "mounts.lock"
+
+	// stateLockTimeout is how long to wait for another pv-mounter to release the state.
+	stateLockTimeout = 10 * time.Second
+	// staleStateLockAge is when a lock is considered left behind by a crashed pv-mounter.
+	staleStateLockAge = time.Minute
+)
+
+// mountRecord is what's recorded locally about a mount, so clean doesn't have to
+// re-derive it from the cluster and the running processes.
+type mountRecord struct {
+	Namespace       string    `json:"namespace"`
+	PVCName         string    `json:"pvcName"`
+	PodName         string    `json:"podName"`
+	Backend         string    `json:"backend"`
+	LocalPort       int       `json:"localPort"`
+	LocalMountPoint string    `json:"localMountPoint"`
+	PortForwardPID  int       `json:"portForwardPID"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// stateStore keeps the records of the mounts in a JSON file. A lock file serializes
+// access, since several pv-mounters may mount or clean at the same time.
+type stateStore struct {
+	dir string
+}
+
+// defaultStateStore returns the store under $XDG_STATE_HOME/pv-mounter, ~/.local/state/pv-mounter if unset.
+func defaultStateStore() (*stateStore, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find state directory: %v", err)
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return &stateStore{dir: filepath.Join(stateHome, "pv-mounter")}, nil
+}
+
+// Add records a mount, replacing an earlier record of the same pod.
+func (s *stateStore) Add(record mountRecord) error {
+	return s.update(func(records []mountRecord) []mountRecord {
+		return append(withoutRecord(records, record.Namespace, record.PodName), record)
+	})
+}
+
+// Get returns the record of the pod, nil if there is none.
+func (s *stateStore) Get(namespace, podName string) (*mountRecord, error) {
+	records, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Namespace == namespace && record.PodName == podName {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindByMountPoint returns the record of the mount at the local mount point, nil if there is none.
+func (s *stateStore) FindByMountPoint(localMountPoint string) (*mountRecord, error) {
+	records, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	localMountPoint = absMountPoint(localMountPoint)
+	for _, record := range records {
+		if record.LocalMountPoint == localMountPoint {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns all recorded mounts.
+func (s *stateStore) List() ([]mountRecord, error) {
+	var records []mountRecord
+	err := s.withLock(func() error {
+		var err error
+		records, err = s.read()
+		return err
+	})
+	return records, err
+}
+
+// Remove drops the record of the pod, if there is one.
+func (s *stateStore) Remove(namespace, podName string) error {
+	return s.update(func(records []mountRecord) []mountRecord {
+		return withoutRecord(records, namespace, podName)
+	})
+}
+
+// Prune drops the records whose port-forward is no longer running and returns them.
+func (s *stateStore) Prune(alive func(pid int) bool) ([]mountRecord, error) {
+	var pruned []mountRecord
+	err := s.update(func(records []mountRecord) []mountRecord {
+		var kept []mountRecord
+		for _, record := range records {
+			if alive(record.PortForwardPID) {
+				kept = append(kept, record)
+			} else {
+				pruned = append(pruned, record)
+			}
+		}
+		return kept
+	})
+	return pruned, err
+}
+
+func withoutRecord(records []mountRecord, namespace, podName string) []mountRecord {
+	var kept []mountRecord
+	for _, record := range records {
+		if record.Namespace != namespace || record.PodName != podName {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+func (s *stateStore) update(fn func([]mountRecord) []mountRecord) error {
+	return s.withLock(func() error {
+		records, err := s.read()
+		if err != nil {
+			return err
+		}
+		return s.write(fn(records))
+	})
+}
+
+func (s *stateStore) read() ([]mountRecord, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount state: %v", err)
+	}
+
+	var records []mountRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse mount state: %v", err)
+	}
+	return records, nil
+}
+
+// write replaces the state file atomically, so readers never see a partial one.
+func (s *stateStore) write(records []mountRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal mount state: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(s.dir, stateFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write mount state: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write mount state: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write mount state: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(s.dir, stateFileName)); err != nil {
+		return fmt.Errorf("failed to write mount state: %v", err)
+	}
+	return nil
+}
+
+func (s *stateStore) withLock(fn func() error) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	lockPath := filepath.Join(s.dir, stateLockName)
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			lockFile.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to lock mount state: %v", err)
+		}
+		// A lock left behind by a crashed pv-mounter would otherwise block everyone forever
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleStateLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for lock %s of mount state", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+
+	return fn()
+}
+
+// processAlive reports whether a process with the PID is running.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// recordMount remembers a mount for clean. Failing to do so isn't fatal, clean then
+// falls back to finding the port-forward by its command line.
+func recordMount(record mountRecord) {
+	store, err := defaultStateStore()
+	if err == nil {
+		if _, err = store.Prune(processAlive); err == nil {
+			err = store.Add(record)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to record mount of PVC %s: %v\n", record.PVCName, err)
+	}
+}
+
+// recordedMounts returns the mounts recorded on this machine. They only add to what the
+// cluster knows, so failing to read them is just a warning.
+func recordedMounts() []mountRecord {
+	store, err := defaultStateStore()
+	var records []mountRecord
+	if err == nil {
+		records, err = store.List()
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to read recorded mounts: %v\n", err)
+	}
+	return records
+}
+
+// forgetMount drops the record of a mount once it was cleaned up.
+func forgetMount(namespace, podName string) {
+	store, err := defaultStateStore()
+	if err == nil {
+		err = store.Remove(namespace, podName)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to forget mount of pod %s: %v\n", podName, err)
+	}
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestRecord(namespace, podName string, pid int) mountRecord {
+	return mountRecord{
+		Namespace:       namespace,
+		PVCName:         "test-pvc",
+		PodName:         podName,
+		Backend:         "sshfs",
+		LocalPort:       12345,
+		LocalMountPoint: "/mnt/data",
+		PortForwardPID:  pid,
+		CreatedAt:       time.Now(),
+	}
+}
+
+func TestDefaultStateStore(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+	store, err := defaultStateStore()
+	if err != nil {
+		t.Fatalf("defaultStateStore() returned an error: %v", err)
+	}
+	if store.dir != "/tmp/state/pv-mounter" {
+		t.Errorf("Expected state under XDG_STATE_HOME, got %s", store.dir)
+	}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/user")
+	store, err = defaultStateStore()
+	if err != nil {
+		t.Fatalf("defaultStateStore() returned an error: %v", err)
+	}
+	if store.dir != "/home/user/.local/state/pv-mounter" {
+		t.Errorf("Expected state under ~/.local/state, got %s", store.dir)
+	}
+}
+
+func TestStateStoreRecords(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+
+	record, err := store.Get("default", "volume-exposer-abcde")
+	if err != nil || record != nil {
+		t.Fatalf("Expected no record before adding, got %v, %v", record, err)
+	}
+
+	if err := store.Add(newTestRecord("default", "volume-exposer-abcde", 4242)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	if err := store.Add(newTestRecord("other", "volume-exposer-abcde", 4343)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	// Adding the same pod again replaces its record
+	if err := store.Add(newTestRecord("default", "volume-exposer-abcde", 4444)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	records, err := store.List()
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", records)
+	}
+	record, err = store.Get("default", "volume-exposer-abcde")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if record == nil || record.PortForwardPID != 4444 || record.PVCName != "test-pvc" || record.LocalMountPoint != "/mnt/data" {
+		t.Errorf("Expected the replaced record, got %+v", record)
+	}
+
+	if err := store.Remove("default", "volume-exposer-abcde"); err != nil {
+		t.Fatalf("Remove() returned an error: %v", err)
+	}
+	if err := store.Remove("default", "volume-exposer-abcde"); err != nil {
+		t.Errorf("Removing a missing record should succeed, got %v", err)
+	}
+	if record, _ := store.Get("default", "volume-exposer-abcde"); record != nil {
+		t.Errorf("Expected no record after removing it, got %+v", record)
+	}
+	if record, _ := store.Get("other", "volume-exposer-abcde"); record == nil {
+		t.Error("Expected the record of the other namespace to be kept")
+	}
+	if _, err := os.Stat(filepath.Join(store.dir, stateLockName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+}
+
+func TestStateStorePrune(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+	for i, podName := range []string{"alive", "dead"} {
+		if err := store.Add(newTestRecord("default", podName, 100+i)); err != nil {
+			t.Fatalf("Add() returned an error: %v", err)
+		}
+	}
+
+	pruned, err := store.Prune(func(pid int) bool { return pid == 100 })
+	if err != nil {
+		t.Fatalf("Prune() returned an error: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].PodName != "dead" {
+		t.Errorf("Expected the dead record to be pruned, got %+v", pruned)
+	}
+	records, _ := store.List()
+	if len(records) != 1 || records[0].PodName != "alive" {
+		t.Errorf("Expected only the alive record to be kept, got %+v", records)
+	}
+}
+
+func TestStateStoreConcurrentAdds(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.Add(newTestRecord("default", string(rune('a'+i)), i))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Add() returned an error: %v", err)
+		}
+	}
+	if records, _ := store.List(); len(records) != len(errs) {
+		t.Errorf("Expected %d records, got %d", len(errs), len(records))
+	}
+}
+
+func TestStateStoreStaleLock(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+	lockPath := filepath.Join(store.dir, stateLockName)
+	if err := os.WriteFile(lockPath, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	old := time.Now().Add(-2 * staleStateLockAge)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatalf("Failed to age lock: %v", err)
+	}
+
+	if err := store.Add(newTestRecord("default", "volume-exposer-abcde", 4242)); err != nil {
+		t.Errorf("Expected a stale lock to be taken over, got %v", err)
+	}
+}
+
+// useProcessCommandLine reports the command line of the given process as the port-forward of
+// the pod, as the stub processes of the tests aren't real port-forwards.
+func useProcessCommandLine(t *testing.T, pid int, pod *corev1.Pod) {
+	t.Helper()
+	oldProcessCommandLine := processCommandLine
+	t.Cleanup(func() { processCommandLine = oldProcessCommandLine })
+	processCommandLine = func(p int) (string, error) {
+		if p == pid {
+			return portForwardPattern(pod), nil
+		}
+		return oldProcessCommandLine(p)
+	}
+}
+
+func TestStopRecordedPortForward(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "volume-exposer-abcde", Namespace: "default"}}
+
+	portForward := exec.Command("sleep", "60")
+	if err := portForward.Start(); err != nil {
+		t.Fatalf("Failed to start the port-forward stub: %v", err)
+	}
+	t.Cleanup(func() { _ = portForward.Process.Kill() })
+	useProcessCommandLine(t, portForward.Process.Pid, pod)
+
+	if err := store.Add(newTestRecord(pod.Namespace, pod.Name, portForward.Process.Pid)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	killed, err := stopRecordedPortForward(store, pod)
+	if err != nil {
+		t.Fatalf("stopRecordedPortForward() returned an error: %v", err)
+	}
+	if !killed {
+		t.Error("Expected the port-forward to be reported as killed")
+	}
+	if err := portForward.Wait(); err == nil {
+		t.Error("Expected the recorded port-forward to be killed")
+	}
+	if record, _ := store.Get(pod.Namespace, pod.Name); record != nil {
+		t.Errorf("Expected the record to be removed, got %+v", record)
+	}
+
+	// The process is gone by now, stopping it again only has to clean up the record
+	if err := store.Add(newTestRecord(pod.Namespace, pod.Name, portForward.Process.Pid)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	out := captureStdout(t, func() { killed, err = stopRecordedPortForward(store, pod) })
+	if err != nil {
+		t.Errorf("Stopping an exited port-forward should succeed, got %v", err)
+	}
+	if killed {
+		t.Errorf("Expected an exited port-forward not to be reported as killed: %s", out)
+	}
+}
+
+func TestStopRecordedPortForwardReusedPID(t *testing.T) {
+	store := &stateStore{dir: t.TempDir()}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "volume-exposer-abcde", Namespace: "default"}}
+
+	// The recorded PID now belongs to a process that isn't the port-forward
+	unrelated := exec.Command("sleep", "60")
+	if err := unrelated.Start(); err != nil {
+		t.Fatalf("Failed to start the unrelated process: %v", err)
+	}
+	t.Cleanup(func() { _ = unrelated.Process.Kill() })
+
+	if err := store.Add(newTestRecord(pod.Namespace, pod.Name, unrelated.Process.Pid)); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		_, err = stopRecordedPortForward(store, pod)
+	})
+	if err != nil {
+		t.Fatalf("stopRecordedPortForward() returned an error: %v", err)
+	}
+	if !strings.Contains(out, "not killing it") {
+		t.Errorf("Expected a warning about the reused PID, got %q", out)
+	}
+	if err := unrelated.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("Expected the unrelated process to keep running, got %v", err)
+	}
+	if record, _ := store.Get(pod.Namespace, pod.Name); record != nil {
+		t.Errorf("Expected the stale record to be removed, got %+v", record)
+	}
+}
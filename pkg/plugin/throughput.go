@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readProcessIO returns the I/O counters of a process, only available on Linux.
+var readProcessIO = func(pid int) (string, error) {
+	out, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	return string(out), err
+}
+
+// parseProcessIO returns the bytes a process read and wrote through syscalls, the rchar and
+// wchar counters of /proc/<pid>/io.
+func parseProcessIO(content string) (read, written uint64, err error) {
+	var foundRead, foundWritten bool
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		var counter *uint64
+		switch name {
+		case "rchar":
+			counter, foundRead = &read, true
+		case "wchar":
+			counter, foundWritten = &written, true
+		default:
+			continue
+		}
+		if *counter, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid %s counter: %v", name, err)
+		}
+	}
+	if !foundRead || !foundWritten {
+		return 0, 0, fmt.Errorf("rchar or wchar counter missing")
+	}
+	return read, written, nil
+}
+
+// reportThroughput prints how much the port-forward of a mount relayed since the mount was
+// created. Every byte SSHFS sends or receives passes through it, so what it read is about the
+// traffic of the mount in both directions. Skipped where the counters aren't available.
+func reportThroughput(podName string, pid int, since time.Time) {
+	if runtime.GOOS != "linux" || since.IsZero() {
+		return
+	}
+	content, err := readProcessIO(pid)
+	if err != nil {
+		return
+	}
+	read, _, err := parseProcessIO(content)
+	if err != nil {
+		return
+	}
+
+	elapsed := clock().Sub(since)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	fmt.Printf("Port-forward for pod %s transferred about %s in %s (%s/s)\n",
+		podName, formatBytes(read), elapsed.Round(time.Second), formatBytes(uint64(float64(read)/elapsed.Seconds())))
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcessIO(t *testing.T) {
+	read, written, err := parseProcessIO("rchar: 1048576\nwchar: 2048\nsyscr: 12\nsyscw: 4\nread_bytes: 0\nwrite_bytes: 0\ncancelled_write_bytes: 0\n")
+	if err != nil {
+		t.Fatalf("parseProcessIO() returned an error: %v", err)
+	}
+	if read != 1048576 || written != 2048 {
+		t.Errorf("Expected 1048576 bytes read and 2048 written, got %d and %d", read, written)
+	}
+
+	for _, content := range []string{"", "rchar: 10\n", "rchar: ten\nwchar: 2\n"} {
+		if _, _, err := parseProcessIO(content); err == nil {
+			t.Errorf("Expected %q to fail", content)
+		}
+	}
+}
+
+func TestParseProcessIOSelf(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("/proc/<pid>/io is only available on Linux")
+	}
+	content, err := readProcessIO(os.Getpid())
+	if err != nil {
+		t.Skipf("/proc/self/io isn't readable: %v", err)
+	}
+	if _, _, err := parseProcessIO(content); err != nil {
+		t.Errorf("Failed to parse /proc/self/io: %v", err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for bytes, expected := range map[uint64]string{
+		0:                "0 B",
+		1023:             "1023 B",
+		1536:             "1.5 KiB",
+		10 * 1024 * 1024: "10.0 MiB",
+		3 << 40:          "3.0 TiB",
+	} {
+		if got := formatBytes(bytes); got != expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", bytes, got, expected)
+		}
+	}
+}
+
+func TestReportThroughput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("throughput is only reported on Linux")
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	originalClock, originalRead := clock, readProcessIO
+	t.Cleanup(func() { clock, readProcessIO = originalClock, originalRead })
+	clock = func() time.Time { return now }
+	readProcessIO = func(int) (string, error) { return "rchar: 20971520\nwchar: 10485760\n", nil }
+
+	output := captureStdout(t, func() { reportThroughput("pod-a", 42, now.Add(-10*time.Second)) })
+	if !strings.Contains(output, "pod-a transferred about 20.0 MiB in 10s (2.0 MiB/s)") {
+		t.Errorf("Unexpected report: %q", output)
+	}
+
+	readProcessIO = func(int) (string, error) { return "", os.ErrNotExist }
+	if output := captureStdout(t, func() { reportThroughput("pod-a", 42, now.Add(-10*time.Second)) }); output != "" {
+		t.Errorf("Expected nothing to be reported without counters, got %q", output)
+	}
+}
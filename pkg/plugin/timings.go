@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// clock returns the current time, tests replace it to control the measured durations.
+var clock = time.Now
+
+// phaseTiming is how long one phase of a mount took.
+type phaseTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// phaseTimer records how long the phases of a mount take. A nil timer records
+// nothing, so the phases can be measured whether or not timings were asked for.
+type phaseTimer struct {
+	phases []phaseTiming
+}
+
+// start starts measuring a phase, the returned function ends it.
+func (t *phaseTimer) start(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	started := clock()
+	return func() {
+		t.phases = append(t.phases, phaseTiming{Name: name, Duration: clock().Sub(started)})
+	}
+}
+
+// print prints a summary of the measured phases.
+func (t *phaseTimer) print(pvcName string) {
+	if t == nil || len(t.phases) == 0 {
+		return
+	}
+
+	var total time.Duration
+	fmt.Printf("Timings of mounting PVC %s:\n", pvcName)
+	for _, phase := range t.phases {
+		fmt.Printf("  %-26s %s\n", phase.Name, phase.Duration.Round(time.Millisecond))
+		total += phase.Duration
+	}
+	fmt.Printf("  %-26s %s\n", "total", total.Round(time.Millisecond))
+}
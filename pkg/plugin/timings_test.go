@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// useFakeClock makes every reading of the clock one second later than the previous one.
+func useFakeClock(t *testing.T) {
+	t.Helper()
+	original := clock
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	t.Cleanup(func() { clock = original })
+}
+
+func TestPhaseTimer(t *testing.T) {
+	useFakeClock(t)
+
+	timer := &phaseTimer{}
+	stop := timer.start("setupPod")
+	stop()
+	stop = timer.start("mount")
+	stop()
+
+	expected := []phaseTiming{{"setupPod", time.Second}, {"mount", time.Second}}
+	if len(timer.phases) != len(expected) {
+		t.Fatalf("Expected phases %v, got %v", expected, timer.phases)
+	}
+	for i := range expected {
+		if timer.phases[i] != expected[i] {
+			t.Errorf("Expected phase %v, got %v", expected[i], timer.phases[i])
+		}
+	}
+
+	out := captureStdout(t, func() { timer.print("test-pvc") })
+	if !strings.Contains(out, "Timings of mounting PVC test-pvc") || !strings.Contains(out, "total") || !strings.Contains(out, "2s") {
+		t.Errorf("Unexpected summary:\n%s", out)
+	}
+}
+
+func TestNilPhaseTimer(t *testing.T) {
+	var timer *phaseTimer
+	timer.start("setupPod")()
+	if out := captureStdout(t, func() { timer.print("test-pvc") }); out != "" {
+		t.Errorf("Expected no summary from a nil timer, got:\n%s", out)
+	}
+}
+
+func TestMountTimings(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	namespace := "default"
+	pvcName := "test-pvc"
+	clientset := fake.NewSimpleClientset(newTestObjects(namespace, pvcName, corev1.ReadWriteMany)...)
+	markPodsReady(clientset)
+	useFakeRunner(t, &fakeRunner{})
+	mountPoint := t.TempDir()
+	useMountTable(t, fmt.Sprintf("ve@localhost:/volume %s fuse.sshfs rw,nosuid,nodev 0 0\n", mountPoint))
+	useFakeClock(t)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = mount(context.Background(), clientset, namespace, pvcName, mountPoint, MountOptions{Timings: true})
+	})
+	if err != nil {
+		t.Fatalf("mount() returned an error: %v", err)
+	}
+
+	for _, phase := range []string{"setupPod", "waitForPodReady", "setupPortForwarding", "mount", "total"} {
+		if !strings.Contains(out, "  "+phase+" ") {
+			t.Errorf("Expected phase %s in the timings, got:\n%s", phase, out)
+		}
+	}
+	if !strings.Contains(out, "4s") {
+		t.Errorf("Expected a total of 4s, got:\n%s", out)
+	}
+}
@@ -8,20 +8,67 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 	"math/rand"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
+// DefaultAPIRetries is how many times transient Kubernetes API errors are retried by default.
+const DefaultAPIRetries = 5
+
+// apiRetryBackoff is the backoff between retried Kubernetes API calls.
+var apiRetryBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
 func BuildKubeClient() (*kubernetes.Clientset, error) {
+	config, err := buildKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	return clientset, nil
+}
+
+// inClusterConfig builds the config of the service account of the pod pv-mounter runs in.
+var inClusterConfig = rest.InClusterConfig
+
+// buildKubeConfig follows the precedence of client-go: an explicit KUBECONFIG wins, then the
+// service account when running in a pod, then ~/.kube/config. The proxy-url of the cluster in
+// the kubeconfig ends up in Proxy; without one, client-go falls back to HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY, for API calls and exec streams alike. The kubectl port-forward reads the same
+// kubeconfig and environment, so it goes through the same proxy.
+func buildKubeConfig() (*rest.Config, error) {
 	kubeconfig := os.Getenv("KUBECONFIG")
 	if kubeconfig == "" {
+		config, err := inClusterConfig()
+		if err == nil {
+			return config, nil
+		}
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			fmt.Printf("Warning: failed to use in-cluster config, falling back to kubeconfig: %v\n", err)
+		}
+
 		home := os.Getenv("HOME")
 		kubeconfig = fmt.Sprintf("%s/.kube/config", home)
 	}
@@ -30,13 +77,28 @@ func BuildKubeClient() (*kubernetes.Clientset, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to build Kubernetes config: %v", err)
 	}
+	return config, nil
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
-	}
+// retryAPICall calls fn and retries it up to retries times with exponential backoff
+// as long as it fails with an error that is worth retrying.
+func retryAPICall(retries int, fn func() error) error {
+	backoff := apiRetryBackoff
+	backoff.Steps = max(retries, 0) + 1
+	return retry.OnError(backoff, isRetryableAPIError, fn)
+}
 
-	return clientset, nil
+// isRetryableAPIError reports whether err is transient, like throttling or a dropped connection.
+// Errors such as NotFound or Forbidden won't go away by retrying.
+func isRetryableAPIError(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err)
 }
 
 func randSeq(n int) string {
@@ -83,7 +145,15 @@ func GenerateKeyPair(curve elliptic.Curve) (string, string, error) {
 	return string(privateKeyPEM), trimmedPublicKey, nil
 }
 
-func checkSSHFS() {
+func checkSSHFS(sshfsPath string) error {
+	if sshfsPath != "" {
+		// LookPath only checks that a path with a slash is an executable file
+		if _, err := exec.LookPath(sshfsPath); err != nil {
+			return fmt.Errorf("%w: %s is not an executable: %v", ErrSSHFSNotFound, sshfsPath, err)
+		}
+		return nil
+	}
+
 	_, err := exec.LookPath("sshfs")
 	if err != nil {
 		fmt.Println("sshfs is not available in your environment.")
@@ -94,6 +164,44 @@ func checkSSHFS() {
 		} else {
 			fmt.Println("Please install sshfs and try again.")
 		}
-		os.Exit(1)
+		return ErrSSHFSNotFound
 	}
+	return nil
 }
+
+// checkSupportedOS returns an error for platforms where mounting and unmounting can't work.
+// Windows has no fusermount/umount and sshfs-win mounts drive letters through WinFsp
+// rather than directories, so fail early with a pointer to WSL instead.
+func checkSupportedOS(goos string) error {
+	if goos == "windows" {
+		return fmt.Errorf("%w: windows, please run pv-mounter from WSL2 with sshfs installed", ErrUnsupportedOS)
+	}
+	return nil
+}
+
+// commandRunner runs the local commands of a mount, so tests can replace them without really mounting anything.
+type commandRunner interface {
+	Run(cmd *exec.Cmd) error
+	Start(cmd *exec.Cmd) error
+	// Wait waits for a command started with Start to exit.
+	Wait(cmd *exec.Cmd) error
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+func (execRunner) Start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func (execRunner) Wait(cmd *exec.Cmd) error {
+	return cmd.Wait()
+}
+
+var runner commandRunner = execRunner{}
+
+// portForwardSettleTime is how long a new port-forward gets to establish before it's used.
+var portForwardSettleTime = 5 * time.Second
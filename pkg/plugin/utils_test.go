@@ -3,8 +3,14 @@ package plugin
 import (
 	// Necessary imports
 	"crypto/elliptic"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"k8s.io/client-go/rest"
 )
 
 func TestRandSeq(t *testing.T) {
@@ -31,3 +37,141 @@ func TestGenerateKeyPair(t *testing.T) {
 	}
 	// Additional checks can be added to validate the key formats
 }
+
+func TestCheckSupportedOS(t *testing.T) {
+	for _, goos := range []string{"linux", "darwin"} {
+		if err := checkSupportedOS(goos); err != nil {
+			t.Errorf("checkSupportedOS(%s) returned an unexpected error: %v", goos, err)
+		}
+	}
+	if err := checkSupportedOS("windows"); err == nil {
+		t.Error("checkSupportedOS(windows) should have returned an error")
+	}
+}
+
+// useInClusterConfig replaces the in-cluster config with one returning config and err, and counts its calls.
+func useInClusterConfig(t *testing.T, config *rest.Config, err error) *int {
+	t.Helper()
+	original := inClusterConfig
+	calls := 0
+	inClusterConfig = func() (*rest.Config, error) {
+		calls++
+		return config, err
+	}
+	t.Cleanup(func() { inClusterConfig = original })
+	return &calls
+}
+
+// writeKubeconfig writes a kubeconfig for a cluster at server and returns its path.
+func writeKubeconfig(t *testing.T, dir, server string) string {
+	t.Helper()
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: ` + server + `
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+	return path
+}
+
+func TestBuildKubeConfigInCluster(t *testing.T) {
+	t.Setenv("KUBECONFIG", "")
+	calls := useInClusterConfig(t, &rest.Config{Host: "https://10.96.0.1:443"}, nil)
+
+	config, err := buildKubeConfig()
+	if err != nil {
+		t.Fatalf("buildKubeConfig() returned an error: %v", err)
+	}
+	if *calls != 1 || config.Host != "https://10.96.0.1:443" {
+		t.Errorf("Expected the in-cluster config to be used, got host %s", config.Host)
+	}
+}
+
+func TestBuildKubeConfigOutsideCluster(t *testing.T) {
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, ".kube"), 0o700); err != nil {
+		t.Fatalf("Failed to create .kube: %v", err)
+	}
+	writeKubeconfig(t, filepath.Join(home, ".kube"), "https://home.example.com")
+	t.Setenv("HOME", home)
+	t.Setenv("KUBECONFIG", "")
+	useInClusterConfig(t, nil, rest.ErrNotInCluster)
+
+	config, err := buildKubeConfig()
+	if err != nil {
+		t.Fatalf("buildKubeConfig() returned an error: %v", err)
+	}
+	if config.Host != "https://home.example.com" {
+		t.Errorf("Expected ~/.kube/config to be used, got host %s", config.Host)
+	}
+}
+
+func TestBuildKubeConfigExplicitKubeconfig(t *testing.T) {
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, t.TempDir(), "https://explicit.example.com"))
+	calls := useInClusterConfig(t, nil, errors.New("should not be called"))
+
+	config, err := buildKubeConfig()
+	if err != nil {
+		t.Fatalf("buildKubeConfig() returned an error: %v", err)
+	}
+	if *calls != 0 {
+		t.Error("Expected the in-cluster config not to be tried with KUBECONFIG set")
+	}
+	if config.Host != "https://explicit.example.com" {
+		t.Errorf("Expected KUBECONFIG to be used, got host %s", config.Host)
+	}
+}
+
+func TestBuildKubeConfigProxyURL(t *testing.T) {
+	kubeconfig := writeKubeconfig(t, t.TempDir(), "https://proxied.example.com")
+	content, err := os.ReadFile(kubeconfig)
+	if err != nil {
+		t.Fatalf("Failed to read kubeconfig: %v", err)
+	}
+	withProxy := strings.Replace(string(content), "    server: https://proxied.example.com\n", "    server: https://proxied.example.com\n    proxy-url: http://bastion.example.com:3128\n", 1)
+	if err := os.WriteFile(kubeconfig, []byte(withProxy), 0o600); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	config, err := buildKubeConfig()
+	if err != nil {
+		t.Fatalf("buildKubeConfig() returned an error: %v", err)
+	}
+	if config.Proxy == nil {
+		t.Fatal("Expected the proxy-url of the kubeconfig to set the proxy")
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://proxied.example.com/api", nil)
+	proxy, err := config.Proxy(req)
+	if err != nil || proxy == nil || proxy.String() != "http://bastion.example.com:3128" {
+		t.Errorf("Expected requests to go through the bastion, got %v (%v)", proxy, err)
+	}
+}
+
+func TestBuildKubeConfigWithoutProxyURL(t *testing.T) {
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, t.TempDir(), "https://direct.example.com"))
+
+	config, err := buildKubeConfig()
+	if err != nil {
+		t.Fatalf("buildKubeConfig() returned an error: %v", err)
+	}
+	// client-go uses the proxy environment variables when the config sets none
+	if config.Proxy != nil {
+		t.Error("Expected no proxy from a kubeconfig without proxy-url")
+	}
+}